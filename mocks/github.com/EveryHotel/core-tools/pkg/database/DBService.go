@@ -5,6 +5,7 @@ package mocks
 import (
 	context "context"
 
+	database "github.com/EveryHotel/core-tools/pkg/database"
 	goqu "github.com/doug-martin/goqu/v9"
 
 	mock "github.com/stretchr/testify/mock"
@@ -27,6 +28,10 @@ func (_m *DBService) EXPECT() *DBService_Expecter {
 func (_m *DBService) Begin(ctx context.Context) (context.Context, error) {
 	ret := _m.Called(ctx)
 
+	if len(ret) == 0 {
+		panic("no return value specified for Begin")
+	}
+
 	var r0 context.Context
 	var r1 error
 	if rf, ok := ret.Get(0).(func(context.Context) (context.Context, error)); ok {
@@ -81,6 +86,10 @@ func (_c *DBService_Begin_Call) RunAndReturn(run func(context.Context) (context.
 func (_m *DBService) Commit(ctx context.Context) error {
 	ret := _m.Called(ctx)
 
+	if len(ret) == 0 {
+		panic("no return value specified for Commit")
+	}
+
 	var r0 error
 	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
 		r0 = rf(ctx)
@@ -119,10 +128,73 @@ func (_c *DBService_Commit_Call) RunAndReturn(run func(context.Context) error) *
 	return _c
 }
 
+// CopyFrom provides a mock function with given fields: ctx, table, columns, rows
+func (_m *DBService) CopyFrom(ctx context.Context, table string, columns []string, rows [][]interface{}) (int64, error) {
+	ret := _m.Called(ctx, table, columns, rows)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CopyFrom")
+	}
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, []string, [][]interface{}) (int64, error)); ok {
+		return rf(ctx, table, columns, rows)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, []string, [][]interface{}) int64); ok {
+		r0 = rf(ctx, table, columns, rows)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, []string, [][]interface{}) error); ok {
+		r1 = rf(ctx, table, columns, rows)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DBService_CopyFrom_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'CopyFrom'
+type DBService_CopyFrom_Call struct {
+	*mock.Call
+}
+
+// CopyFrom is a helper method to define mock.On call
+//   - ctx context.Context
+//   - table string
+//   - columns []string
+//   - rows [][]interface{}
+func (_e *DBService_Expecter) CopyFrom(ctx interface{}, table interface{}, columns interface{}, rows interface{}) *DBService_CopyFrom_Call {
+	return &DBService_CopyFrom_Call{Call: _e.mock.On("CopyFrom", ctx, table, columns, rows)}
+}
+
+func (_c *DBService_CopyFrom_Call) Run(run func(ctx context.Context, table string, columns []string, rows [][]interface{})) *DBService_CopyFrom_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string), args[2].([]string), args[3].([][]interface{}))
+	})
+	return _c
+}
+
+func (_c *DBService_CopyFrom_Call) Return(_a0 int64, _a1 error) *DBService_CopyFrom_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *DBService_CopyFrom_Call) RunAndReturn(run func(context.Context, string, []string, [][]interface{}) (int64, error)) *DBService_CopyFrom_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // Count provides a mock function with given fields: ctx, sql, args
 func (_m *DBService) Count(ctx context.Context, sql string, args []interface{}) (int64, error) {
 	ret := _m.Called(ctx, sql, args)
 
+	if len(ret) == 0 {
+		panic("no return value specified for Count")
+	}
+
 	var r0 int64
 	var r1 error
 	if rf, ok := ret.Get(0).(func(context.Context, string, []interface{}) (int64, error)); ok {
@@ -173,10 +245,14 @@ func (_c *DBService_Count_Call) RunAndReturn(run func(context.Context, string, [
 	return _c
 }
 
-// Dialect provides a mock function with given fields:
+// Dialect provides a mock function with no fields
 func (_m *DBService) Dialect() goqu.DialectWrapper {
 	ret := _m.Called()
 
+	if len(ret) == 0 {
+		panic("no return value specified for Dialect")
+	}
+
 	var r0 goqu.DialectWrapper
 	if rf, ok := ret.Get(0).(func() goqu.DialectWrapper); ok {
 		r0 = rf()
@@ -218,6 +294,10 @@ func (_c *DBService_Dialect_Call) RunAndReturn(run func() goqu.DialectWrapper) *
 func (_m *DBService) Exec(ctx context.Context, query string, args []interface{}) error {
 	ret := _m.Called(ctx, query, args)
 
+	if len(ret) == 0 {
+		panic("no return value specified for Exec")
+	}
+
 	var r0 error
 	if rf, ok := ret.Get(0).(func(context.Context, string, []interface{}) error); ok {
 		r0 = rf(ctx, query, args)
@@ -258,10 +338,72 @@ func (_c *DBService_Exec_Call) RunAndReturn(run func(context.Context, string, []
 	return _c
 }
 
+// ExecAffected provides a mock function with given fields: ctx, query, args
+func (_m *DBService) ExecAffected(ctx context.Context, query string, args []interface{}) (int64, error) {
+	ret := _m.Called(ctx, query, args)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ExecAffected")
+	}
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, []interface{}) (int64, error)); ok {
+		return rf(ctx, query, args)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, []interface{}) int64); ok {
+		r0 = rf(ctx, query, args)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, []interface{}) error); ok {
+		r1 = rf(ctx, query, args)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DBService_ExecAffected_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ExecAffected'
+type DBService_ExecAffected_Call struct {
+	*mock.Call
+}
+
+// ExecAffected is a helper method to define mock.On call
+//   - ctx context.Context
+//   - query string
+//   - args []interface{}
+func (_e *DBService_Expecter) ExecAffected(ctx interface{}, query interface{}, args interface{}) *DBService_ExecAffected_Call {
+	return &DBService_ExecAffected_Call{Call: _e.mock.On("ExecAffected", ctx, query, args)}
+}
+
+func (_c *DBService_ExecAffected_Call) Run(run func(ctx context.Context, query string, args []interface{})) *DBService_ExecAffected_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string), args[2].([]interface{}))
+	})
+	return _c
+}
+
+func (_c *DBService_ExecAffected_Call) Return(_a0 int64, _a1 error) *DBService_ExecAffected_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *DBService_ExecAffected_Call) RunAndReturn(run func(context.Context, string, []interface{}) (int64, error)) *DBService_ExecAffected_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // Insert provides a mock function with given fields: ctx, sql, args, dest
 func (_m *DBService) Insert(ctx context.Context, sql string, args []interface{}, dest interface{}) error {
 	ret := _m.Called(ctx, sql, args, dest)
 
+	if len(ret) == 0 {
+		panic("no return value specified for Insert")
+	}
+
 	var r0 error
 	if rf, ok := ret.Get(0).(func(context.Context, string, []interface{}, interface{}) error); ok {
 		r0 = rf(ctx, sql, args, dest)
@@ -307,6 +449,10 @@ func (_c *DBService_Insert_Call) RunAndReturn(run func(context.Context, string,
 func (_m *DBService) InsertMany(ctx context.Context, sql string, args []interface{}, dest interface{}) error {
 	ret := _m.Called(ctx, sql, args, dest)
 
+	if len(ret) == 0 {
+		panic("no return value specified for InsertMany")
+	}
+
 	var r0 error
 	if rf, ok := ret.Get(0).(func(context.Context, string, []interface{}, interface{}) error); ok {
 		r0 = rf(ctx, sql, args, dest)
@@ -348,10 +494,62 @@ func (_c *DBService_InsertMany_Call) RunAndReturn(run func(context.Context, stri
 	return _c
 }
 
+// Listen provides a mock function with given fields: ctx, channel, handler
+func (_m *DBService) Listen(ctx context.Context, channel string, handler database.NotificationHandler) error {
+	ret := _m.Called(ctx, channel, handler)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Listen")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, database.NotificationHandler) error); ok {
+		r0 = rf(ctx, channel, handler)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DBService_Listen_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Listen'
+type DBService_Listen_Call struct {
+	*mock.Call
+}
+
+// Listen is a helper method to define mock.On call
+//   - ctx context.Context
+//   - channel string
+//   - handler database.NotificationHandler
+func (_e *DBService_Expecter) Listen(ctx interface{}, channel interface{}, handler interface{}) *DBService_Listen_Call {
+	return &DBService_Listen_Call{Call: _e.mock.On("Listen", ctx, channel, handler)}
+}
+
+func (_c *DBService_Listen_Call) Run(run func(ctx context.Context, channel string, handler database.NotificationHandler)) *DBService_Listen_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string), args[2].(database.NotificationHandler))
+	})
+	return _c
+}
+
+func (_c *DBService_Listen_Call) Return(_a0 error) *DBService_Listen_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *DBService_Listen_Call) RunAndReturn(run func(context.Context, string, database.NotificationHandler) error) *DBService_Listen_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // Rollback provides a mock function with given fields: ctx
 func (_m *DBService) Rollback(ctx context.Context) error {
 	ret := _m.Called(ctx)
 
+	if len(ret) == 0 {
+		panic("no return value specified for Rollback")
+	}
+
 	var r0 error
 	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
 		r0 = rf(ctx)
@@ -390,6 +588,132 @@ func (_c *DBService_Rollback_Call) RunAndReturn(run func(context.Context) error)
 	return _c
 }
 
+// RunInTx provides a mock function with given fields: ctx, fn, opts
+func (_m *DBService) RunInTx(ctx context.Context, fn func(context.Context) error, opts ...database.TxOption) error {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, fn)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RunInTx")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, func(context.Context) error, ...database.TxOption) error); ok {
+		r0 = rf(ctx, fn, opts...)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DBService_RunInTx_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'RunInTx'
+type DBService_RunInTx_Call struct {
+	*mock.Call
+}
+
+// RunInTx is a helper method to define mock.On call
+//   - ctx context.Context
+//   - fn func(context.Context) error
+//   - opts ...database.TxOption
+func (_e *DBService_Expecter) RunInTx(ctx interface{}, fn interface{}, opts ...interface{}) *DBService_RunInTx_Call {
+	return &DBService_RunInTx_Call{Call: _e.mock.On("RunInTx",
+		append([]interface{}{ctx, fn}, opts...)...)}
+}
+
+func (_c *DBService_RunInTx_Call) Run(run func(ctx context.Context, fn func(context.Context) error, opts ...database.TxOption)) *DBService_RunInTx_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		variadicArgs := make([]database.TxOption, len(args)-2)
+		for i, a := range args[2:] {
+			if a != nil {
+				variadicArgs[i] = a.(database.TxOption)
+			}
+		}
+		run(args[0].(context.Context), args[1].(func(context.Context) error), variadicArgs...)
+	})
+	return _c
+}
+
+func (_c *DBService_RunInTx_Call) Return(_a0 error) *DBService_RunInTx_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *DBService_RunInTx_Call) RunAndReturn(run func(context.Context, func(context.Context) error, ...database.TxOption) error) *DBService_RunInTx_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// RunLeaderElection provides a mock function with given fields: ctx, key, onElected, onLost, opts
+func (_m *DBService) RunLeaderElection(ctx context.Context, key int64, onElected func(context.Context), onLost func(), opts ...database.LeaderOption) error {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, key, onElected, onLost)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RunLeaderElection")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, func(context.Context), func(), ...database.LeaderOption) error); ok {
+		r0 = rf(ctx, key, onElected, onLost, opts...)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DBService_RunLeaderElection_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'RunLeaderElection'
+type DBService_RunLeaderElection_Call struct {
+	*mock.Call
+}
+
+// RunLeaderElection is a helper method to define mock.On call
+//   - ctx context.Context
+//   - key int64
+//   - onElected func(context.Context)
+//   - onLost func()
+//   - opts ...database.LeaderOption
+func (_e *DBService_Expecter) RunLeaderElection(ctx interface{}, key interface{}, onElected interface{}, onLost interface{}, opts ...interface{}) *DBService_RunLeaderElection_Call {
+	return &DBService_RunLeaderElection_Call{Call: _e.mock.On("RunLeaderElection",
+		append([]interface{}{ctx, key, onElected, onLost}, opts...)...)}
+}
+
+func (_c *DBService_RunLeaderElection_Call) Run(run func(ctx context.Context, key int64, onElected func(context.Context), onLost func(), opts ...database.LeaderOption)) *DBService_RunLeaderElection_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		variadicArgs := make([]database.LeaderOption, len(args)-4)
+		for i, a := range args[4:] {
+			if a != nil {
+				variadicArgs[i] = a.(database.LeaderOption)
+			}
+		}
+		run(args[0].(context.Context), args[1].(int64), args[2].(func(context.Context)), args[3].(func()), variadicArgs...)
+	})
+	return _c
+}
+
+func (_c *DBService_RunLeaderElection_Call) Return(_a0 error) *DBService_RunLeaderElection_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *DBService_RunLeaderElection_Call) RunAndReturn(run func(context.Context, int64, func(context.Context), func(), ...database.LeaderOption) error) *DBService_RunLeaderElection_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // Select provides a mock function with given fields: ctx, sql, args, dest, relations
 func (_m *DBService) Select(ctx context.Context, sql string, args []interface{}, dest interface{}, relations ...string) error {
 	_va := make([]interface{}, len(relations))
@@ -401,6 +725,10 @@ func (_m *DBService) Select(ctx context.Context, sql string, args []interface{},
 	_ca = append(_ca, _va...)
 	ret := _m.Called(_ca...)
 
+	if len(ret) == 0 {
+		panic("no return value specified for Select")
+	}
+
 	var r0 error
 	if rf, ok := ret.Get(0).(func(context.Context, string, []interface{}, interface{}, ...string) error); ok {
 		r0 = rf(ctx, sql, args, dest, relations...)
@@ -461,6 +789,10 @@ func (_m *DBService) SelectOne(ctx context.Context, sql string, args []interface
 	_ca = append(_ca, _va...)
 	ret := _m.Called(_ca...)
 
+	if len(ret) == 0 {
+		panic("no return value specified for SelectOne")
+	}
+
 	var r0 error
 	if rf, ok := ret.Get(0).(func(context.Context, string, []interface{}, interface{}, ...string) error); ok {
 		r0 = rf(ctx, sql, args, dest, relations...)
@@ -510,6 +842,242 @@ func (_c *DBService_SelectOne_Call) RunAndReturn(run func(context.Context, strin
 	return _c
 }
 
+// Stream provides a mock function with given fields: ctx, sql, args, dest, handle, relations
+func (_m *DBService) Stream(ctx context.Context, sql string, args []interface{}, dest interface{}, handle func() error, relations ...string) error {
+	_va := make([]interface{}, len(relations))
+	for _i := range relations {
+		_va[_i] = relations[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, sql, args, dest, handle)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Stream")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, []interface{}, interface{}, func() error, ...string) error); ok {
+		r0 = rf(ctx, sql, args, dest, handle, relations...)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DBService_Stream_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Stream'
+type DBService_Stream_Call struct {
+	*mock.Call
+}
+
+// Stream is a helper method to define mock.On call
+//   - ctx context.Context
+//   - sql string
+//   - args []interface{}
+//   - dest interface{}
+//   - handle func() error
+//   - relations ...string
+func (_e *DBService_Expecter) Stream(ctx interface{}, sql interface{}, args interface{}, dest interface{}, handle interface{}, relations ...interface{}) *DBService_Stream_Call {
+	return &DBService_Stream_Call{Call: _e.mock.On("Stream",
+		append([]interface{}{ctx, sql, args, dest, handle}, relations...)...)}
+}
+
+func (_c *DBService_Stream_Call) Run(run func(ctx context.Context, sql string, args []interface{}, dest interface{}, handle func() error, relations ...string)) *DBService_Stream_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		variadicArgs := make([]string, len(args)-5)
+		for i, a := range args[5:] {
+			if a != nil {
+				variadicArgs[i] = a.(string)
+			}
+		}
+		run(args[0].(context.Context), args[1].(string), args[2].([]interface{}), args[3].(interface{}), args[4].(func() error), variadicArgs...)
+	})
+	return _c
+}
+
+func (_c *DBService_Stream_Call) Return(_a0 error) *DBService_Stream_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *DBService_Stream_Call) RunAndReturn(run func(context.Context, string, []interface{}, interface{}, func() error, ...string) error) *DBService_Stream_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// TryLock provides a mock function with given fields: ctx, key
+func (_m *DBService) TryLock(ctx context.Context, key int64) (bool, error) {
+	ret := _m.Called(ctx, key)
+
+	if len(ret) == 0 {
+		panic("no return value specified for TryLock")
+	}
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) (bool, error)); ok {
+		return rf(ctx, key)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) bool); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, key)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DBService_TryLock_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'TryLock'
+type DBService_TryLock_Call struct {
+	*mock.Call
+}
+
+// TryLock is a helper method to define mock.On call
+//   - ctx context.Context
+//   - key int64
+func (_e *DBService_Expecter) TryLock(ctx interface{}, key interface{}) *DBService_TryLock_Call {
+	return &DBService_TryLock_Call{Call: _e.mock.On("TryLock", ctx, key)}
+}
+
+func (_c *DBService_TryLock_Call) Run(run func(ctx context.Context, key int64)) *DBService_TryLock_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(int64))
+	})
+	return _c
+}
+
+func (_c *DBService_TryLock_Call) Return(_a0 bool, _a1 error) *DBService_TryLock_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *DBService_TryLock_Call) RunAndReturn(run func(context.Context, int64) (bool, error)) *DBService_TryLock_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// TrySessionLock provides a mock function with given fields: ctx, key
+func (_m *DBService) TrySessionLock(ctx context.Context, key int64) (func(), bool, error) {
+	ret := _m.Called(ctx, key)
+
+	if len(ret) == 0 {
+		panic("no return value specified for TrySessionLock")
+	}
+
+	var r0 func()
+	var r1 bool
+	var r2 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) (func(), bool, error)); ok {
+		return rf(ctx, key)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) func()); ok {
+		r0 = rf(ctx, key)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(func())
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) bool); ok {
+		r1 = rf(ctx, key)
+	} else {
+		r1 = ret.Get(1).(bool)
+	}
+
+	if rf, ok := ret.Get(2).(func(context.Context, int64) error); ok {
+		r2 = rf(ctx, key)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// DBService_TrySessionLock_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'TrySessionLock'
+type DBService_TrySessionLock_Call struct {
+	*mock.Call
+}
+
+// TrySessionLock is a helper method to define mock.On call
+//   - ctx context.Context
+//   - key int64
+func (_e *DBService_Expecter) TrySessionLock(ctx interface{}, key interface{}) *DBService_TrySessionLock_Call {
+	return &DBService_TrySessionLock_Call{Call: _e.mock.On("TrySessionLock", ctx, key)}
+}
+
+func (_c *DBService_TrySessionLock_Call) Run(run func(ctx context.Context, key int64)) *DBService_TrySessionLock_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(int64))
+	})
+	return _c
+}
+
+func (_c *DBService_TrySessionLock_Call) Return(unlock func(), locked bool, err error) *DBService_TrySessionLock_Call {
+	_c.Call.Return(unlock, locked, err)
+	return _c
+}
+
+func (_c *DBService_TrySessionLock_Call) RunAndReturn(run func(context.Context, int64) (func(), bool, error)) *DBService_TrySessionLock_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// WithLock provides a mock function with given fields: ctx, key, fn
+func (_m *DBService) WithLock(ctx context.Context, key int64, fn func(context.Context) error) error {
+	ret := _m.Called(ctx, key, fn)
+
+	if len(ret) == 0 {
+		panic("no return value specified for WithLock")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, func(context.Context) error) error); ok {
+		r0 = rf(ctx, key, fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DBService_WithLock_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'WithLock'
+type DBService_WithLock_Call struct {
+	*mock.Call
+}
+
+// WithLock is a helper method to define mock.On call
+//   - ctx context.Context
+//   - key int64
+//   - fn func(context.Context) error
+func (_e *DBService_Expecter) WithLock(ctx interface{}, key interface{}, fn interface{}) *DBService_WithLock_Call {
+	return &DBService_WithLock_Call{Call: _e.mock.On("WithLock", ctx, key, fn)}
+}
+
+func (_c *DBService_WithLock_Call) Run(run func(ctx context.Context, key int64, fn func(context.Context) error)) *DBService_WithLock_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(int64), args[2].(func(context.Context) error))
+	})
+	return _c
+}
+
+func (_c *DBService_WithLock_Call) Return(_a0 error) *DBService_WithLock_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *DBService_WithLock_Call) RunAndReturn(run func(context.Context, int64, func(context.Context) error) error) *DBService_WithLock_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // NewDBService creates a new instance of DBService. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewDBService(t interface {
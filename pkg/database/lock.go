@@ -0,0 +1,239 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var ErrLockOutsideTx = errors.New("advisory xact lock requires transaction in context")
+
+// unlockTimeout ограничивает освобождение сессионной блокировки, чтобы зависшее соединение не блокировало остановку
+const unlockTimeout = 5 * time.Second
+
+// LockKey возвращает ключ advisory блокировки по имени, например имени cron задачи
+func LockKey(name string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+
+	return int64(h.Sum64())
+}
+
+// TryLock пытается взять advisory блокировку транзакции из контекста без ожидания
+// Блокировка освобождается при завершении транзакции, вне транзакции возвращается ErrLockOutsideTx
+func (s *dbService) TryLock(ctx context.Context, key int64) (bool, error) {
+	tx, ok := ctx.Value(CtxDbTxKey).(pgx.Tx)
+	if !ok {
+		return false, ErrLockOutsideTx
+	}
+
+	var locked bool
+	if err := tx.QueryRow(ctx, "SELECT pg_try_advisory_xact_lock($1)", key).Scan(&locked); err != nil {
+		return false, err
+	}
+
+	return locked, nil
+}
+
+// TrySessionLock пытается взять сессионную advisory блокировку key на выделенном соединении пула без ожидания
+// В отличие от TryLock транзакция не нужна: блокировка держится до вызова unlock, который возвращает соединение в пул.
+// Если блокировка занята, locked - false и unlock - nil
+func (s *dbService) TrySessionLock(ctx context.Context, key int64) (unlock func(), locked bool, err error) {
+	pool, ok := s.pool.(PgxAcquirePool)
+	if !ok {
+		return nil, false, ErrAcquireNotSupported
+	}
+
+	conn, err := acquireSession(pool)(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err = conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked); err != nil || !locked {
+		conn.Release()
+		return nil, false, err
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			releaseSessionLock(ctx, conn, key)
+		})
+	}, true, nil
+}
+
+// sessionConn выделенное соединение пула, на котором держится сессионная блокировка
+type sessionConn interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	// Close закрывает соединение, чтобы оно не вернулось в пул
+	Close(ctx context.Context) error
+	Release()
+}
+
+// pooledConn соединение *pgxpool.Conn как sessionConn
+type pooledConn struct {
+	*pgxpool.Conn
+}
+
+func (c pooledConn) Close(ctx context.Context) error {
+	return c.Conn.Conn().Close(ctx)
+}
+
+// acquireSession берет из пула выделенное соединение для сессионной блокировки
+func acquireSession(pool PgxAcquirePool) func(ctx context.Context) (sessionConn, error) {
+	return func(ctx context.Context) (sessionConn, error) {
+		conn, err := pool.Acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return pooledConn{conn}, nil
+	}
+}
+
+// releaseSessionLock снимает сессионную блокировку и возвращает соединение в пул
+// Если снять блокировку не удалось, соединение закрывается: иначе блокировка осталась бы в пуле вместе с ним
+func releaseSessionLock(ctx context.Context, conn sessionConn, key int64) {
+	unlockCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), unlockTimeout)
+	defer cancel()
+
+	if _, err := conn.Exec(unlockCtx, "SELECT pg_advisory_unlock($1)", key); err != nil {
+		slog.WarnContext(ctx, "Cannot release advisory lock",
+			slog.Any("error", err),
+			slog.Int64("key", key),
+		)
+		_ = conn.Close(unlockCtx)
+	}
+
+	conn.Release()
+}
+
+// WithLock выполняет fn в транзакции под advisory блокировкой key, ожидая ее освобождения другими процессами
+func (s *dbService) WithLock(ctx context.Context, key int64, fn func(ctx context.Context) error) error {
+	return s.RunInTx(ctx, func(ctx context.Context) error {
+		if err := s.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", []any{key}); err != nil {
+			return err
+		}
+
+		return fn(ctx)
+	})
+}
+
+type LeaderOption func(*leaderOptionHandler)
+
+type leaderOptionHandler struct {
+	checkInterval time.Duration
+}
+
+// WithLeaderCheckInterval задает интервал попыток взять лидерство и проверки соединения лидера
+func WithLeaderCheckInterval(interval time.Duration) LeaderOption {
+	return func(h *leaderOptionHandler) {
+		h.checkInterval = interval
+	}
+}
+
+// RunLeaderElection держит на выделенном соединении сессионную advisory блокировку key
+// Процесс, взявший блокировку, становится лидером: onElected запускается в отдельной горутине с контекстом,
+// который отменяется при потере лидерства. После возврата из onElected блокировка освобождается, вызывается onLost
+// и через интервал проверки выборы начинаются заново, так что лидерство может перейти к другому процессу.
+// При потере соединения блокировка освобождается сервером и выборы также начинаются заново. Блокируется до отмены ctx
+func (s *dbService) RunLeaderElection(ctx context.Context, key int64, onElected func(ctx context.Context), onLost func(), opts ...LeaderOption) error {
+	pool, ok := s.pool.(PgxAcquirePool)
+	if !ok {
+		return ErrAcquireNotSupported
+	}
+
+	handler := &leaderOptionHandler{
+		checkInterval: 5 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(handler)
+	}
+
+	acquire := acquireSession(pool)
+	for {
+		err := lead(ctx, acquire, key, onElected, onLost, handler.checkInterval)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err != nil {
+			slog.WarnContext(ctx, "Leader election connection lost",
+				slog.Any("error", err),
+				slog.Int64("key", key),
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(handler.checkInterval):
+		}
+	}
+}
+
+// lead проводит выборы на одном соединении: возвращает ошибку при потере соединения
+// и nil, если onElected вернулся сам и лидерство отпущено
+func lead(ctx context.Context, acquire func(ctx context.Context) (sessionConn, error), key int64, onElected func(ctx context.Context), onLost func(), interval time.Duration) error {
+	conn, err := acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	leaderCtx, leaderCancel := context.WithCancel(ctx)
+	leading := false
+	// done закрывается, когда onElected вернулся, до этого канал nil и в select не срабатывает
+	var done chan struct{}
+	var elected sync.WaitGroup
+	defer func() {
+		leaderCancel()
+		if !leading {
+			conn.Release()
+			return
+		}
+
+		// пока onElected не вернулся, лидер еще работает и отпускать блокировку нельзя
+		elected.Wait()
+		releaseSessionLock(ctx, conn, key)
+		onLost()
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if !leading {
+			if err = conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&leading); err != nil {
+				return err
+			}
+
+			if leading {
+				done = make(chan struct{})
+				elected.Add(1)
+				go func() {
+					defer elected.Done()
+					defer close(done)
+					onElected(leaderCtx)
+				}()
+			}
+		} else if _, err = conn.Exec(ctx, "SELECT 1"); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-done:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
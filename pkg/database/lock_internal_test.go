@@ -0,0 +1,66 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// lockedRow строка ответа pg_try_advisory_lock
+type lockedRow struct {
+	locked bool
+}
+
+func (r lockedRow) Scan(dest ...any) error {
+	*dest[0].(*bool) = r.locked
+	return nil
+}
+
+// fakeSessionConn соединение, на котором блокировка всегда свободна, запоминает запросы
+type fakeSessionConn struct {
+	execs    []string
+	released int
+}
+
+func (c *fakeSessionConn) QueryRow(context.Context, string, ...any) pgx.Row {
+	return lockedRow{locked: true}
+}
+
+func (c *fakeSessionConn) Exec(_ context.Context, sql string, _ ...any) (pgconn.CommandTag, error) {
+	c.execs = append(c.execs, sql)
+	return pgconn.CommandTag("SELECT 1"), nil
+}
+
+func (c *fakeSessionConn) Close(context.Context) error {
+	return nil
+}
+
+func (c *fakeSessionConn) Release() {
+	c.released++
+}
+
+var _ = Describe("lead", func() {
+	It("releases lock and calls onLost when onElected returns", func() {
+		conn := &fakeSessionConn{}
+		acquire := func(context.Context) (sessionConn, error) {
+			return conn, nil
+		}
+		elected, lost := 0, 0
+
+		err := lead(context.Background(), acquire, 1, func(context.Context) {
+			elected++
+		}, func() {
+			lost++
+		}, time.Hour)
+
+		Expect(err).Should(Succeed())
+		Expect(elected).Should(Equal(1))
+		Expect(lost).Should(Equal(1))
+		Expect(conn.execs).Should(Equal([]string{"SELECT pg_advisory_unlock($1)"}))
+		Expect(conn.released).Should(Equal(1))
+	})
+})
@@ -0,0 +1,112 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/EveryHotel/core-tools/pkg/database"
+)
+
+var _ = Describe("Advisory locks", func() {
+	var mockCtrl *gomock.Controller
+	var mockPool *pgxpoolmock.MockPgxPool
+	var service database.DBService
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockPool = pgxpoolmock.NewMockPgxPool(mockCtrl)
+		service = database.NewDBService(mockPool)
+	})
+
+	It("returns stable key for name", func() {
+		Expect(database.LockKey("reindex")).Should(Equal(database.LockKey("reindex")))
+		Expect(database.LockKey("reindex")).ShouldNot(Equal(database.LockKey("cleanup")))
+	})
+
+	Describe("TryLock", func() {
+		It("fails outside tx", func() {
+			_, err := service.TryLock(context.Background(), 1)
+
+			Expect(err).Should(MatchError(database.ErrLockOutsideTx))
+		})
+
+		It("takes xact lock inside tx", func() {
+			tx := &fakeTx{row: pgxpoolmock.NewRow([]string{"pg_try_advisory_xact_lock"}, true)}
+			ctx := context.WithValue(context.Background(), database.CtxDbTxKey, pgx.Tx(tx))
+
+			locked, err := service.TryLock(ctx, 1)
+
+			Expect(err).Should(Succeed())
+			Expect(locked).Should(BeTrue())
+			Expect(tx.queries).Should(ConsistOf(ContainSubstring("pg_try_advisory_xact_lock")))
+		})
+	})
+
+	Describe("TrySessionLock", func() {
+		It("requires pool with dedicated connections", func() {
+			service = database.NewDBService(struct{ pgxpoolmock.PgxPool }{mockPool})
+
+			unlock, locked, err := service.TrySessionLock(context.Background(), 1)
+
+			Expect(err).Should(MatchError(database.ErrAcquireNotSupported))
+			Expect(locked).Should(BeFalse())
+			Expect(unlock).Should(BeNil())
+		})
+
+		It("does not require tx and returns acquire error", func() {
+			mockPool.EXPECT().Acquire(gomock.Any()).Return(nil, errors.New("connection refused"))
+
+			unlock, locked, err := service.TrySessionLock(context.Background(), 1)
+
+			Expect(err).Should(MatchError("connection refused"))
+			Expect(locked).Should(BeFalse())
+			Expect(unlock).Should(BeNil())
+		})
+	})
+
+	Describe("WithLock", func() {
+		It("runs fn under xact lock in tx", func() {
+			tx := &fakeTx{}
+			mockPool.EXPECT().Begin(gomock.Any()).Return(tx, nil)
+
+			called := false
+			err := service.WithLock(context.Background(), 1, func(ctx context.Context) error {
+				called = true
+				Expect(ctx.Value(database.CtxDbTxKey)).Should(BeIdenticalTo(tx))
+				return nil
+			})
+
+			Expect(err).Should(Succeed())
+			Expect(called).Should(BeTrue())
+			Expect(tx.queries).Should(ConsistOf(ContainSubstring("pg_advisory_xact_lock")))
+			Expect(tx.commits).Should(Equal(1))
+		})
+	})
+
+	Describe("RunLeaderElection", func() {
+		It("retries connection until context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			attempts := 0
+			mockPool.EXPECT().Acquire(gomock.Any()).DoAndReturn(func(context.Context) (*pgxpool.Conn, error) {
+				attempts++
+				if attempts == 2 {
+					cancel()
+				}
+				return nil, errors.New("connection refused")
+			}).Times(2)
+
+			err := service.RunLeaderElection(ctx, 1, func(ctx context.Context) {
+				Fail("must not be elected")
+			}, func() {}, database.WithLeaderCheckInterval(0))
+
+			Expect(err).Should(Succeed())
+		})
+	})
+})
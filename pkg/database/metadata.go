@@ -0,0 +1,382 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/guregu/null"
+	"github.com/jackc/pgx/v4"
+	"github.com/shopspring/decimal"
+)
+
+// StructField колонка структуры, описанная тегом db
+// Поля вложенных структур (embedded_struct и inner_struct) разворачиваются одинаково,
+// Index - путь до поля для reflect.Value.FieldByIndex
+type StructField struct {
+	Column    string
+	Index     []int
+	Type      reflect.Type
+	Tag       reflect.StructTag
+	Primary   bool
+	NotSerial bool
+	// Embedded поле из embedded_struct, его primary не считается первичным ключом сущности
+	Embedded bool
+}
+
+type structMeta struct {
+	fields    []StructField
+	columns   []any
+	relations []relationMeta
+	// scanner структура сканируется целиком: это не структура или она реализует sql.Scanner
+	scanner bool
+}
+
+type relationMeta struct {
+	name     string
+	nullable bool
+	index    int
+	typ      reflect.Type
+	pointer  bool
+	// pos позиция колонок связи среди колонок сущности
+	pos int
+}
+
+type scanPlanKey struct {
+	typ       reflect.Type
+	relations string
+}
+
+// scanPlan порядок сканирования колонок запроса в поля структуры для набора связей
+type scanPlan struct {
+	scanner   bool
+	targets   []scanTarget
+	relations []scanRelation
+	nullable  bool
+}
+
+// scanRelation связь в плане сканирования, в том числе вложенная (связь связи)
+type scanRelation struct {
+	relationMeta
+	// parent индекс родительской связи в scanPlan.relations, -1 для связей сущности
+	parent int
+	// nullable колонки связи могут быть NULL: связь или одна из родительских связей nullable
+	nullable bool
+	// keyTargets индексы колонок, по которым определяется, что LEFT JOIN ничего не нашел:
+	// primary колонки связи, а если их нет - все ее колонки
+	keyTargets []int
+}
+
+type scanTarget struct {
+	// relation индекс связи в scanPlan.relations, -1 для собственных полей
+	relation int
+	index    []int
+	// nullType тип, в который сканируется значение колонки nullable связи, nil - сканируем напрямую в поле
+	nullType reflect.Type
+}
+
+var (
+	structMetaCache sync.Map
+	scanPlanCache   sync.Map
+	scannerType     = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+)
+
+// StructFields возвращает колонки структуры в порядке Sanitize, результат кешируется по типу
+func StructFields(typ reflect.Type) []StructField {
+	return getStructMeta(typ).fields
+}
+
+func getStructMeta(typ reflect.Type) *structMeta {
+	if meta, ok := structMetaCache.Load(typ); ok {
+		return meta.(*structMeta)
+	}
+
+	meta := &structMeta{
+		scanner: typ.Kind() != reflect.Struct || typ.Implements(scannerType),
+	}
+	if typ.Kind() == reflect.Struct {
+		meta.fields = collectStructFields(typ, nil, false)
+
+		for i := 0; i < typ.NumField(); i++ {
+			typeField := typ.Field(i)
+			tagVal := typeField.Tag.Get("relation")
+			if tagVal == "" {
+				continue
+			}
+
+			relationsField := strings.Split(tagVal, ",")
+			relation := relationMeta{
+				name:     relationsField[0],
+				nullable: isNullableField(relationsField),
+				index:    i,
+				typ:      typeField.Type,
+				pointer:  typeField.Type.Kind() == reflect.Pointer,
+			}
+			for _, field := range meta.fields {
+				if field.Index[0] <= i {
+					relation.pos++
+				}
+			}
+			if relation.pointer {
+				relation.typ = typeField.Type.Elem()
+			}
+			meta.relations = append(meta.relations, relation)
+		}
+	}
+
+	meta.columns = make([]any, len(meta.fields))
+	for i, field := range meta.fields {
+		meta.columns[i] = field.Column
+	}
+
+	actual, _ := structMetaCache.LoadOrStore(typ, meta)
+
+	return actual.(*structMeta)
+}
+
+// collectStructFields разворачивает поля с тегом db, в том числе из embedded_struct и inner_struct
+func collectStructFields(typ reflect.Type, index []int, embedded bool) []StructField {
+	var fields []StructField
+	for i := 0; i < typ.NumField(); i++ {
+		typeField := typ.Field(i)
+		tag := typeField.Tag
+		fieldIndex := append(append([]int{}, index...), i)
+
+		if column := tag.Get("db"); column != "" {
+			fields = append(fields, StructField{
+				Column:    column,
+				Index:     fieldIndex,
+				Type:      typeField.Type,
+				Tag:       tag,
+				Primary:   tag.Get("primary") != "",
+				NotSerial: tag.Get("not_serial") != "",
+				Embedded:  embedded,
+			})
+		}
+
+		if typeField.Type.Kind() != reflect.Struct {
+			continue
+		}
+
+		if tag.Get("embedded_struct") == "1" {
+			fields = append(fields, collectStructFields(typeField.Type, fieldIndex, true)...)
+		} else if tag.Get("inner_struct") != "" {
+			fields = append(fields, collectStructFields(typeField.Type, fieldIndex, embedded)...)
+		}
+	}
+
+	return fields
+}
+
+// getScanPlan возвращает закешированный план сканирования типа для набора связей
+// Колонки связей идут на месте поля связи, колонки вложенной связи - на месте ее поля в родительской связи,
+// так же как их возвращает Sanitize с WithRelations
+func getScanPlan(typ reflect.Type, relations ...string) *scanPlan {
+	key := scanPlanKey{typ: typ, relations: strings.Join(relations, ",")}
+	if plan, ok := scanPlanCache.Load(key); ok {
+		return plan.(*scanPlan)
+	}
+
+	meta := getStructMeta(typ)
+	plan := &scanPlan{scanner: meta.scanner}
+	if !meta.scanner {
+		plan.addStruct(meta, -1, false, relations)
+	}
+
+	actual, _ := scanPlanCache.LoadOrStore(key, plan)
+
+	return actual.(*scanPlan)
+}
+
+// addStruct добавляет в план колонки структуры и ее связей из relations
+// Каждая связь используется один раз, поэтому связь на собственный тип не приводит к бесконечной рекурсии
+func (p *scanPlan) addStruct(meta *structMeta, parent int, nullable bool, relations []string) {
+	var included []relationMeta
+	for _, relation := range meta.relations {
+		if relation.typ.Kind() == reflect.Struct && slices.Contains(relations, relation.name) {
+			included = append(included, relation)
+		}
+	}
+
+	addRelations := func(pos int) {
+		for _, relation := range included {
+			if relation.pos != pos {
+				continue
+			}
+
+			relationNullable := nullable || relation.nullable
+			p.nullable = p.nullable || relationNullable
+			p.relations = append(p.relations, scanRelation{
+				relationMeta: relation,
+				parent:       parent,
+				nullable:     relationNullable,
+			})
+
+			p.addStruct(getStructMeta(relation.typ), len(p.relations)-1, relationNullable, withoutRelation(relations, relation.name))
+		}
+	}
+
+	var primaryTargets, allTargets []int
+	for i, field := range meta.fields {
+		addRelations(i)
+
+		target := scanTarget{relation: parent, index: field.Index}
+		if nullable {
+			target.nullType = nullScanType(field.Type)
+			if field.Primary {
+				primaryTargets = append(primaryTargets, len(p.targets))
+			}
+			allTargets = append(allTargets, len(p.targets))
+		}
+		p.targets = append(p.targets, target)
+	}
+	addRelations(len(meta.fields))
+
+	if parent >= 0 && nullable {
+		p.relations[parent].keyTargets = allTargets
+		if len(primaryTargets) > 0 {
+			p.relations[parent].keyTargets = primaryTargets
+		}
+	}
+}
+
+// scan сканирует строку в vDest по плану
+// Указатель на nullable связь остается nil, если LEFT JOIN ничего не нашел
+func (p *scanPlan) scan(row pgx.Row, vDest reflect.Value) error {
+	if p.scanner {
+		return row.Scan(vDest.Addr().Interface())
+	}
+
+	var relationValues, relationPointers []reflect.Value
+	if len(p.relations) > 0 {
+		relationValues = make([]reflect.Value, len(p.relations))
+		relationPointers = make([]reflect.Value, len(p.relations))
+		for i, relation := range p.relations {
+			field := p.parentValue(vDest, relationValues, relation.parent).Field(relation.index)
+			if relation.pointer {
+				value := reflect.New(relation.typ)
+				if !relation.nullable {
+					field.Set(value)
+				}
+				relationPointers[i] = value
+				field = value.Elem()
+			}
+			relationValues[i] = field
+		}
+	}
+
+	scanFields := make([]any, len(p.targets))
+	for i, target := range p.targets {
+		if target.nullType != nil {
+			scanFields[i] = reflect.New(target.nullType).Interface()
+			continue
+		}
+
+		scanFields[i] = p.field(vDest, relationValues, target).Addr().Interface()
+	}
+
+	if err := row.Scan(scanFields...); err != nil {
+		return err
+	}
+
+	if !p.nullable {
+		return nil
+	}
+
+	for i, target := range p.targets {
+		if target.nullType == nil {
+			continue
+		}
+
+		field := p.field(vDest, relationValues, target)
+		field.Set(TransformNullableToDest(field, scanFields[i]))
+	}
+
+	for i, relation := range p.relations {
+		if !relation.pointer || !relation.nullable || p.isNullRelation(relation, scanFields) {
+			continue
+		}
+
+		p.parentValue(vDest, relationValues, relation.parent).Field(relation.index).Set(relationPointers[i])
+	}
+
+	return nil
+}
+
+func (p *scanPlan) parentValue(vDest reflect.Value, relationValues []reflect.Value, parent int) reflect.Value {
+	if parent < 0 {
+		return vDest
+	}
+
+	return relationValues[parent]
+}
+
+func (p *scanPlan) field(vDest reflect.Value, relationValues []reflect.Value, target scanTarget) reflect.Value {
+	return p.parentValue(vDest, relationValues, target.relation).FieldByIndex(target.index)
+}
+
+// isNullRelation проверяет, что все ключевые колонки связи NULL
+func (p *scanPlan) isNullRelation(relation scanRelation, scanFields []any) bool {
+	for _, i := range relation.keyTargets {
+		if !isNullScanned(scanFields[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isNullScanned проверяет, что в отсканированное значение пришел NULL
+// Значения, для которых это определить нельзя, считаются не NULL
+func isNullScanned(scanned any) bool {
+	if valuer, ok := scanned.(driver.Valuer); ok {
+		value, err := valuer.Value()
+		return err == nil && value == nil
+	}
+
+	value := reflect.ValueOf(scanned).Elem()
+	switch value.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Interface:
+		return value.IsNil()
+	}
+
+	return false
+}
+
+func withoutRelation(relations []string, name string) []string {
+	res := make([]string, 0, len(relations))
+	for _, relation := range relations {
+		if relation != name {
+			res = append(res, relation)
+		}
+	}
+
+	return res
+}
+
+// nullScanType возвращает null тип для сканирования NULL в поле типа typ, nil - если поле само принимает NULL
+func nullScanType(typ reflect.Type) reflect.Type {
+	switch typ.Kind() {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int, reflect.Int64:
+		return reflect.TypeOf(null.Int{})
+	case reflect.Float32, reflect.Float64:
+		return reflect.TypeOf(null.Float{})
+	case reflect.Bool:
+		return reflect.TypeOf(null.Bool{})
+	case reflect.String:
+		return reflect.TypeOf(null.String{})
+	}
+
+	switch typ {
+	case reflect.TypeOf(time.Time{}):
+		return reflect.TypeOf(null.Time{})
+	case reflect.TypeOf(decimal.Decimal{}):
+		return reflect.TypeOf(decimal.NullDecimal{})
+	}
+
+	return nil
+}
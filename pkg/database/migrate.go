@@ -0,0 +1,327 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	ErrNoDownMigration   = errors.New("down migration not found")
+	ErrMigrationNotFound = errors.New("migration not found")
+)
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration версионированная миграция из файлов <version>_<name>.up.sql и <version>_<name>.down.sql
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus состояние миграции: известна по файлам и/или применена в базе
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+}
+
+type appliedMigration struct {
+	Version   int64     `db:"version"`
+	Name      string    `db:"name"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+// Migrator применяет и откатывает миграции схемы
+type Migrator interface {
+	Up(ctx context.Context) error
+	Down(ctx context.Context, n int) error
+	Status(ctx context.Context) ([]MigrationStatus, error)
+}
+
+type MigratorOption func(*migrator)
+
+// WithMigrationsTable задает таблицу с примененными версиями, по умолчанию schema_migrations
+func WithMigrationsTable(table string) MigratorOption {
+	return func(m *migrator) {
+		m.table = table
+	}
+}
+
+// WithDryRun вместо выполнения миграций печатает их SQL в w
+func WithDryRun(w io.Writer) MigratorOption {
+	return func(m *migrator) {
+		m.dryRun = w
+	}
+}
+
+type migrator struct {
+	db     DBService
+	fsys   fs.FS
+	table  string
+	dryRun io.Writer
+}
+
+// NewMigrator создает мигратор, читающий миграции из корня fsys (например embed.FS, при необходимости через fs.Sub)
+func NewMigrator(db DBService, fsys fs.FS, opts ...MigratorOption) Migrator {
+	m := &migrator{
+		db:    db,
+		fsys:  fsys,
+		table: "schema_migrations",
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// LoadMigrations читает миграции из корня fsys и возвращает их по возрастанию версии
+// Файлы, не подходящие под формат имени, пропускаются. Up файл обязателен, down - нет
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		matches := migrationFileRe.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", entry.Name(), err)
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = migration
+		} else if migration.Name != matches[2] {
+			return nil, fmt.Errorf("migration version %d has different names: %s and %s", version, migration.Name, matches[2])
+		}
+
+		if matches[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	res := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", migration.Version, migration.Name)
+		}
+		res = append(res, *migration)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Version < res[j].Version
+	})
+
+	return res, nil
+}
+
+// Up применяет все еще не примененные миграции по возрастанию версии, каждую в своей транзакции
+func (m *migrator) Up(ctx context.Context) error {
+	migrations, err := LoadMigrations(m.fsys)
+	if err != nil {
+		return err
+	}
+
+	return m.locked(ctx, func(ctx context.Context) error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			insertSql := fmt.Sprintf(`INSERT INTO %s (version, name) VALUES ($1, $2)`, m.tableIdent())
+			err = m.run(ctx, migration, "up", migration.Up, insertSql, []any{migration.Version, migration.Name})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Down откатывает n последних примененных миграций
+func (m *migrator) Down(ctx context.Context, n int) error {
+	migrations, err := LoadMigrations(m.fsys)
+	if err != nil {
+		return err
+	}
+
+	byVersion := make(map[int64]Migration, len(migrations))
+	for _, migration := range migrations {
+		byVersion[migration.Version] = migration
+	}
+
+	return m.locked(ctx, func(ctx context.Context) error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+
+		versions := make([]int64, 0, len(applied))
+		for version := range applied {
+			versions = append(versions, version)
+		}
+		sort.Slice(versions, func(i, j int) bool {
+			return versions[i] > versions[j]
+		})
+
+		for i := 0; i < n && i < len(versions); i++ {
+			migration, ok := byVersion[versions[i]]
+			if !ok {
+				return fmt.Errorf("%w: %d_%s", ErrMigrationNotFound, versions[i], applied[versions[i]].Name)
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("%w: %d_%s", ErrNoDownMigration, migration.Version, migration.Name)
+			}
+
+			deleteSql := fmt.Sprintf(`DELETE FROM %s WHERE version = $1`, m.tableIdent())
+			if err = m.run(ctx, migration, "down", migration.Down, deleteSql, []any{migration.Version}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Status возвращает все известные по файлам и примененные миграции по возрастанию версии
+func (m *migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := LoadMigrations(m.fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := m.applied(context.WithValue(ctx, CtxDbForcePrimaryKey, true))
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if item, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &item.AppliedAt
+			delete(applied, migration.Version)
+		}
+		res = append(res, status)
+	}
+
+	// примененные миграции, файлов которых уже нет
+	for _, item := range applied {
+		res = append(res, MigrationStatus{Version: item.Version, Name: item.Name, AppliedAt: &item.AppliedAt})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Version < res[j].Version
+	})
+
+	return res, nil
+}
+
+// locked выполняет fn под advisory блокировкой, чтобы миграции не запускались параллельно
+// Блокировка держится транзакцией отдельного соединения, поэтому fn получает контекст без транзакции
+func (m *migrator) locked(ctx context.Context, fn func(ctx context.Context) error) error {
+	ctx = context.WithValue(ctx, CtxDbForcePrimaryKey, true)
+
+	if m.dryRun != nil {
+		return fn(ctx)
+	}
+
+	return m.db.WithLock(ctx, LockKey("migrations:"+m.table), func(lockCtx context.Context) error {
+		ctx := context.WithValue(lockCtx, CtxDbTxKey, nil)
+		if err := m.createTable(ctx); err != nil {
+			return err
+		}
+
+		return fn(ctx)
+	})
+}
+
+// run выполняет SQL миграции и изменение таблицы версий в одной транзакции
+func (m *migrator) run(ctx context.Context, migration Migration, direction, query, versionSql string, versionArgs []any) error {
+	if m.dryRun != nil {
+		_, err := fmt.Fprintf(m.dryRun, "-- %d_%s.%s.sql\n%s\n", migration.Version, migration.Name, direction, strings.TrimSpace(query))
+		return err
+	}
+
+	err := m.db.RunInTx(ctx, func(ctx context.Context) error {
+		if err := m.db.Exec(ctx, query, nil); err != nil {
+			return err
+		}
+
+		return m.db.Exec(ctx, versionSql, versionArgs)
+	}, WithMaxRetries(0))
+	if err != nil {
+		return fmt.Errorf("migration %d_%s %s: %w", migration.Version, migration.Name, direction, err)
+	}
+
+	return nil
+}
+
+func (m *migrator) createTable(ctx context.Context) error {
+	return m.db.Exec(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	version bigint PRIMARY KEY,
+	name text NOT NULL,
+	applied_at timestamptz NOT NULL DEFAULT now()
+)`, m.tableIdent()), nil)
+}
+
+// applied возвращает примененные миграции, если таблицы версий еще нет - пустой список
+func (m *migrator) applied(ctx context.Context) (map[int64]appliedMigration, error) {
+	var exists bool
+	if err := m.db.SelectOne(ctx, `SELECT to_regclass($1) IS NOT NULL`, []any{m.table}, &exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return map[int64]appliedMigration{}, nil
+	}
+
+	var items []appliedMigration
+	query := fmt.Sprintf(`SELECT version, name, applied_at FROM %s ORDER BY version`, m.tableIdent())
+	if err := m.db.Select(ctx, query, nil, &items); err != nil {
+		return nil, err
+	}
+
+	res := make(map[int64]appliedMigration, len(items))
+	for _, item := range items {
+		res[item.Version] = item
+	}
+
+	return res, nil
+}
+
+func (m *migrator) tableIdent() string {
+	return pgx.Identifier(strings.Split(m.table, ".")).Sanitize()
+}
@@ -0,0 +1,115 @@
+package database_test
+
+import (
+	"bytes"
+	"context"
+	"testing/fstest"
+	"time"
+
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/EveryHotel/core-tools/pkg/database"
+)
+
+var _ = Describe("Migrator", func() {
+	var mockCtrl *gomock.Controller
+	var mockPool *pgxpoolmock.MockPgxPool
+	var service database.DBService
+	var fsys fstest.MapFS
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockPool = pgxpoolmock.NewMockPgxPool(mockCtrl)
+		service = database.NewDBService(mockPool)
+		fsys = fstest.MapFS{
+			"1_create_hotels.up.sql":   {Data: []byte("CREATE TABLE hotels (id bigint);")},
+			"1_create_hotels.down.sql": {Data: []byte("DROP TABLE hotels;")},
+			"2_add_hotels_name.up.sql": {Data: []byte("ALTER TABLE hotels ADD name text;")},
+			"README.md":                {Data: []byte("migrations")},
+		}
+	})
+
+	expectApplied := func(versions ...int64) {
+		mockPool.EXPECT().QueryRow(gomock.Any(), gomock.Any(), gomock.Any()).
+			Return(pgxpoolmock.NewRow([]string{"exists"}, true))
+		rows := pgxpoolmock.NewRows([]string{"version", "name", "applied_at"})
+		for _, version := range versions {
+			rows.AddRow(version, "create_hotels", time.Now())
+		}
+		mockPool.EXPECT().Query(gomock.Any(), gomock.Any()).Return(rows.ToPgxRows(), nil)
+	}
+
+	It("loads migrations sorted by version", func() {
+		migrations, err := database.LoadMigrations(fsys)
+
+		Expect(err).Should(Succeed())
+		Expect(migrations).Should(HaveLen(2))
+		Expect(migrations[0].Version).Should(Equal(int64(1)))
+		Expect(migrations[0].Down).Should(Equal("DROP TABLE hotels;"))
+		Expect(migrations[1].Name).Should(Equal("add_hotels_name"))
+	})
+
+	It("fails without up file", func() {
+		fsys["3_orphan.down.sql"] = &fstest.MapFile{Data: []byte("SELECT 1;")}
+
+		_, err := database.LoadMigrations(fsys)
+
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("applies pending migrations each in own tx under lock", func() {
+		lockTx := &fakeTx{}
+		migrationTx := &fakeTx{}
+		gomock.InOrder(
+			mockPool.EXPECT().Begin(gomock.Any()).Return(lockTx, nil),
+			mockPool.EXPECT().Exec(gomock.Any(), gomock.Any()).Return(pgconn.CommandTag("CREATE TABLE"), nil),
+			mockPool.EXPECT().Begin(gomock.Any()).Return(migrationTx, nil),
+		)
+		expectApplied(1)
+
+		err := database.NewMigrator(service, fsys).Up(context.Background())
+
+		Expect(err).Should(Succeed())
+		Expect(lockTx.queries).Should(ConsistOf(ContainSubstring("pg_advisory_xact_lock")))
+		Expect(lockTx.commits).Should(Equal(1))
+		Expect(migrationTx.queries).Should(HaveLen(2))
+		Expect(migrationTx.queries[0]).Should(Equal("ALTER TABLE hotels ADD name text;"))
+		Expect(migrationTx.queries[1]).Should(ContainSubstring(`INSERT INTO "schema_migrations"`))
+		Expect(migrationTx.commits).Should(Equal(1))
+	})
+
+	It("prints pending migrations in dry run", func() {
+		expectApplied(1)
+		var out bytes.Buffer
+
+		err := database.NewMigrator(service, fsys, database.WithDryRun(&out)).Up(context.Background())
+
+		Expect(err).Should(Succeed())
+		Expect(out.String()).Should(Equal("-- 2_add_hotels_name.up.sql\nALTER TABLE hotels ADD name text;\n"))
+	})
+
+	It("fails to roll back migration without down file", func() {
+		expectApplied(1, 2)
+		var out bytes.Buffer
+
+		err := database.NewMigrator(service, fsys, database.WithDryRun(&out)).Down(context.Background(), 1)
+
+		Expect(err).Should(MatchError(database.ErrNoDownMigration))
+		Expect(out.String()).Should(BeEmpty())
+	})
+
+	It("returns status of migrations", func() {
+		expectApplied(1)
+
+		statuses, err := database.NewMigrator(service, fsys).Status(context.Background())
+
+		Expect(err).Should(Succeed())
+		Expect(statuses).Should(HaveLen(2))
+		Expect(statuses[0].AppliedAt).ShouldNot(BeNil())
+		Expect(statuses[1].AppliedAt).Should(BeNil())
+	})
+})
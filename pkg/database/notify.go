@@ -0,0 +1,187 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	listenMinBackoff = 100 * time.Millisecond
+	listenMaxBackoff = 30 * time.Second
+	// maxIdentifierLength ограничение postgres на длину идентификатора в байтах, длинные имена обрезаются
+	maxIdentifierLength = 63
+)
+
+// PgxAcquirePool пул соединений, из которого можно взять выделенное соединение, ему удовлетворяет *pgxpool.Pool
+type PgxAcquirePool interface {
+	pgxpoolmock.PgxPool
+	Acquire(ctx context.Context) (*pgxpool.Conn, error)
+}
+
+var ErrAcquireNotSupported = errors.New("pool does not support acquiring dedicated connections")
+
+// Notification уведомление, полученное через LISTEN
+type Notification struct {
+	Channel string
+	Payload json.RawMessage
+}
+
+// NotificationHandler обработчик уведомлений, ошибка обработчика логируется и не прерывает подписку
+type NotificationHandler func(ctx context.Context, notification Notification) error
+
+// ChangeEvent уведомление об изменении строки таблицы, которое отправляет триггер из InstallNotifyTrigger
+type ChangeEvent struct {
+	Schema    string          `json:"schema"`
+	Table     string          `json:"table"`
+	Operation string          `json:"operation"`
+	Id        json.RawMessage `json:"id"`
+}
+
+// Listen подписывается на канал на выделенном соединении и передает уведомления в handler
+// При потере соединения переподключается с экспоненциальной задержкой. Блокируется до отмены ctx
+func (s *dbService) Listen(ctx context.Context, channel string, handler NotificationHandler) error {
+	pool, ok := s.pool.(PgxAcquirePool)
+	if !ok {
+		return ErrAcquireNotSupported
+	}
+
+	backoff := listenMinBackoff
+	for {
+		err := s.listen(ctx, pool, channel, handler, func() {
+			backoff = listenMinBackoff
+		})
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		slog.WarnContext(ctx, "Listen connection lost",
+			slog.Any("error", err),
+			slog.String("channel", channel),
+			slog.Duration("backoff", backoff),
+		)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, listenMaxBackoff)
+	}
+}
+
+func (s *dbService) listen(ctx context.Context, pool PgxAcquirePool, channel string, handler NotificationHandler, connected func()) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = conn.Exec(context.Background(), "UNLISTEN *")
+		conn.Release()
+	}()
+
+	if _, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		return err
+	}
+	connected()
+
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = handler(ctx, Notification{
+			Channel: notification.Channel,
+			Payload: json.RawMessage(notification.Payload),
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Error during handle notification",
+				slog.Any("error", err),
+				slog.String("channel", channel),
+				slog.String("payload", notification.Payload),
+			)
+		}
+	}
+}
+
+// ListenJSON подписывается на канал и декодирует JSON payload каждого уведомления в T
+func ListenJSON[T any](ctx context.Context, db DBService, channel string, handler func(ctx context.Context, payload T) error) error {
+	return db.Listen(ctx, channel, func(ctx context.Context, notification Notification) error {
+		var payload T
+		if err := json.Unmarshal(notification.Payload, &payload); err != nil {
+			return fmt.Errorf("decode notification payload: %w", err)
+		}
+
+		return handler(ctx, payload)
+	})
+}
+
+// InstallNotifyTrigger создает на таблице триггер, который после insert/update/delete
+// отправляет в channel ChangeEvent с значением колонки idColumn
+func InstallNotifyTrigger(ctx context.Context, db DBService, table, channel, idColumn string) error {
+	for _, query := range notifyTriggerSql(table, channel, idColumn) {
+		if err := db.Exec(ctx, query, nil); err != nil {
+			return fmt.Errorf("install notify trigger on %s: %w", table, err)
+		}
+	}
+
+	return nil
+}
+
+func notifyTriggerSql(table, channel, idColumn string) []string {
+	tableIdent := pgx.Identifier(strings.Split(table, ".")).Sanitize()
+	name := notifyTriggerName(table, channel)
+	funcIdent := pgx.Identifier{name}.Sanitize()
+	triggerIdent := pgx.Identifier{name}.Sanitize()
+	column := pgx.Identifier{idColumn}.Sanitize()
+	channelLiteral := "'" + strings.ReplaceAll(channel, "'", "''") + "'"
+
+	return []string{
+		fmt.Sprintf(`CREATE OR REPLACE FUNCTION %s() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_notify(%s, json_build_object(
+		'schema', TG_TABLE_SCHEMA,
+		'table', TG_TABLE_NAME,
+		'operation', TG_OP,
+		'id', CASE TG_OP WHEN 'DELETE' THEN OLD.%s ELSE NEW.%s END
+	)::text);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql`, funcIdent, channelLiteral, column, column),
+		fmt.Sprintf(`DROP TRIGGER IF EXISTS %s ON %s`, triggerIdent, tableIdent),
+		fmt.Sprintf(`CREATE TRIGGER %s AFTER INSERT OR UPDATE OR DELETE ON %s FOR EACH ROW EXECUTE FUNCTION %s()`, triggerIdent, tableIdent, funcIdent),
+	}
+}
+
+// notifyTriggerName возвращает имя функции и триггера для таблицы и канала
+// Имя длиннее maxIdentifierLength postgres молча обрезал бы, и триггеры разных таблиц с общим началом имени
+// заменяли бы друг друга. Поэтому длинное имя обрезается здесь и дополняется хешем полного имени
+func notifyTriggerName(table, channel string) string {
+	name := "notify_" + strings.ReplaceAll(table, ".", "_") + "_" + channel
+	if len(name) <= maxIdentifierLength {
+		return name
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	suffix := fmt.Sprintf("_%016x", h.Sum64())
+
+	cut := maxIdentifierLength - len(suffix)
+	for cut > 0 && !utf8.RuneStart(name[cut]) {
+		cut--
+	}
+
+	return name[:cut] + suffix
+}
@@ -0,0 +1,90 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4/pgxpool"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/EveryHotel/core-tools/pkg/database"
+)
+
+var _ = Describe("Notify", func() {
+	var mockCtrl *gomock.Controller
+	var mockPool *pgxpoolmock.MockPgxPool
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockPool = pgxpoolmock.NewMockPgxPool(mockCtrl)
+	})
+
+	Describe("Listen", func() {
+		It("reconnects until context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			attempts := 0
+			mockPool.EXPECT().Acquire(gomock.Any()).DoAndReturn(func(context.Context) (*pgxpool.Conn, error) {
+				attempts++
+				if attempts == 2 {
+					cancel()
+				}
+				return nil, errors.New("connection refused")
+			}).Times(2)
+			service := database.NewDBService(mockPool)
+
+			err := service.Listen(ctx, "hotels", func(ctx context.Context, notification database.Notification) error {
+				return nil
+			})
+
+			Expect(err).Should(Succeed())
+			Expect(attempts).Should(Equal(2))
+		})
+	})
+
+	Describe("InstallNotifyTrigger", func() {
+		It("creates function and trigger", func() {
+			var queries []string
+			mockPool.EXPECT().Exec(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+				queries = append(queries, sql)
+				return pgconn.CommandTag("OK"), nil
+			}).Times(3)
+			service := database.NewDBService(mockPool)
+
+			err := database.InstallNotifyTrigger(context.Background(), service, "public.hotels", "hotels_changes", "id")
+
+			Expect(err).Should(Succeed())
+			Expect(queries[0]).Should(ContainSubstring(`pg_notify('hotels_changes'`))
+			Expect(queries[1]).Should(Equal(`DROP TRIGGER IF EXISTS "notify_public_hotels_hotels_changes" ON "public"."hotels"`))
+			Expect(queries[2]).Should(ContainSubstring(`AFTER INSERT OR UPDATE OR DELETE ON "public"."hotels"`))
+		})
+
+		It("shortens long trigger names deterministically", func() {
+			var queries []string
+			mockPool.EXPECT().Exec(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+				queries = append(queries, sql)
+				return pgconn.CommandTag("OK"), nil
+			}).Times(9)
+			service := database.NewDBService(mockPool)
+
+			prefix := "public.hotel_" + strings.Repeat("booking_", 6)
+			Expect(database.InstallNotifyTrigger(context.Background(), service, prefix+"rooms", "changes", "id")).Should(Succeed())
+			Expect(database.InstallNotifyTrigger(context.Background(), service, prefix+"rates", "changes", "id")).Should(Succeed())
+			Expect(database.InstallNotifyTrigger(context.Background(), service, prefix+"rooms", "changes", "id")).Should(Succeed())
+
+			triggerName := func(query string) string {
+				name := strings.TrimPrefix(query, `DROP TRIGGER IF EXISTS "`)
+				return name[:strings.Index(name, `"`)]
+			}
+			rooms, rates := triggerName(queries[1]), triggerName(queries[4])
+			Expect(len(rooms)).Should(BeNumerically("<=", 63))
+			Expect(len(rates)).Should(BeNumerically("<=", 63))
+			Expect(rooms).ShouldNot(Equal(rates))
+			Expect(triggerName(queries[7])).Should(Equal(rooms))
+		})
+	})
+})
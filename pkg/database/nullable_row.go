@@ -2,6 +2,7 @@ package database
 
 import (
 	"reflect"
+	"slices"
 	"strings"
 	"time"
 
@@ -9,29 +10,15 @@ import (
 	"github.com/shopspring/decimal"
 )
 
-// TODO everyHotel
-//  Здесь поддерживаем два вараинта обработки вложенных структур
-//  - через inner_struct
-//  - через embedded_struct
-//  Желательно потом привести это все к одному знаменателю
-
 // DestHasNullableRelations проверяем, есть ли сущности join с nullable
 func DestHasNullableRelations(vDest reflect.Value, relations ...string) bool {
 	if len(relations) == 0 {
 		return false
 	}
 
-	for i := 0; i < vDest.NumField(); i++ {
-		field := vDest.Field(i)
-		tag := vDest.Type().Field(i).Tag
-
-		if (field.Kind() == reflect.Struct || field.Kind() == reflect.Pointer) && tag.Get("relation") != "" {
-			relationsField := strings.Split(tag.Get("relation"), ",")
-			for _, relation := range relations {
-				if len(relationsField) > 0 && relation == relationsField[0] && isNullableField(relationsField) {
-					return true
-				}
-			}
+	for _, relation := range getStructMeta(vDest.Type()).relations {
+		if relation.nullable && slices.Contains(relations, relation.name) {
+			return true
 		}
 	}
 
@@ -43,27 +30,11 @@ func DestHasNullableRelations(vDest reflect.Value, relations ...string) bool {
 //	ввод int64
 //	результат null.Int
 func TransformDestToNullable(field reflect.Value) any {
-	newField := reflect.New(field.Type()).Interface()
-
-	switch field.Kind() {
-	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int, reflect.Int64:
-		newField = reflect.New(reflect.TypeOf(null.Int{})).Interface()
-	case reflect.Float32, reflect.Float64:
-		newField = reflect.New(reflect.TypeOf(null.Float{})).Interface()
-	case reflect.Bool:
-		newField = reflect.New(reflect.TypeOf(null.Bool{})).Interface()
-	case reflect.String:
-		newField = reflect.New(reflect.TypeOf(null.String{})).Interface()
-	default:
-		if field.Type().Name() == reflect.TypeOf(time.Time{}).Name() {
-			newField = reflect.New(reflect.TypeOf(null.Time{})).Interface()
-		}
-		if field.Type().Name() == reflect.TypeOf(decimal.Decimal{}).Name() {
-			newField = reflect.New(reflect.TypeOf(decimal.NullDecimal{})).Interface()
-		}
+	if nullType := nullScanType(field.Type()); nullType != nil {
+		return reflect.New(nullType).Interface()
 	}
 
-	return newField
+	return reflect.New(field.Type()).Interface()
 }
 
 // GetNullableRowFromOrigDest получаем слайс интерфейсов с nullable полями в join сущностях
@@ -83,6 +54,8 @@ func TransformDestToNullable(field reflect.Value) any {
 //				3.	DescriptionEn null.String `db:"description_en"`
 //			]
 //	 ]
+//
+// Deprecated: Select и SelectOne сканируют строки по закешированному плану, см. getScanPlan
 func GetNullableRowFromOrigDest(vDest reflect.Value, nullable bool, relations ...string) (newItem []any) {
 	for i := 0; i < vDest.NumField(); i++ {
 		field := vDest.Field(i)
@@ -154,6 +127,8 @@ func GetNullableRowFromOrigDest(vDest reflect.Value, nullable bool, relations ..
 //			4.DescriptionRu null.String `db:"description_ru"`
 //			5.DescriptionEn null.String `db:"description_en"`
 //	 ]
+//
+// Deprecated: Select и SelectOne сканируют строки по закешированному плану, см. getScanPlan
 func SetNullableDestFields(vDest reflect.Value, scanFields []any) []any {
 	for i := 0; i < vDest.Len(); i++ {
 		field := vDest.Index(i)
@@ -179,16 +154,16 @@ func TransformNullableToDest(fieldOrig reflect.Value, field any) reflect.Value {
 		fieldOrig.SetUint(uint64((*(field.(*null.Int))).Int64))
 	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int, reflect.Int64:
 		fieldOrig.SetInt((*(field.(*null.Int))).Int64)
-	case reflect.Float32:
+	case reflect.Float32, reflect.Float64:
 		fieldOrig.SetFloat((*(field.(*null.Float))).Float64)
 	case reflect.Bool:
 		fieldOrig.SetBool((*(field.(*null.Bool))).Bool)
 	case reflect.String:
 		fieldOrig.SetString((*(field.(*null.String))).String)
 	default:
-		if fieldOrig.Type().String() == reflect.TypeOf(time.Time{}).String() {
+		if fieldOrig.Type() == reflect.TypeOf(time.Time{}) {
 			fieldOrig = reflect.ValueOf((*(field.(*null.Time))).Time)
-		} else if fieldOrig.Type().String() == reflect.TypeOf(decimal.Decimal{}).String() {
+		} else if fieldOrig.Type() == reflect.TypeOf(decimal.Decimal{}) {
 			fieldOrig = reflect.ValueOf((*(field.(*decimal.NullDecimal))).Decimal)
 		} else {
 			fieldOrig = reflect.ValueOf(field).Elem()
@@ -213,6 +188,8 @@ func TransformNullableToDest(fieldOrig reflect.Value, field any) reflect.Value {
 //		Id      int64     `db:"id" primary:"1"`
 //		PhotoId null.Int  `db:"photo_id"`
 //		Photo   RoomPhoto `relation:"rp,nullable"`
+//
+// Deprecated: Select и SelectOne сканируют строки по закешированному плану, см. getScanPlan
 func SetDestFromNullable(vDestOrig reflect.Value, vDest []any, nullable bool, iDest int, relations ...string) (reflect.Value, int) {
 	for i := 0; i < vDestOrig.NumField(); i++ {
 		fieldOrig := vDestOrig.Field(i)
@@ -253,12 +230,16 @@ func SetDestFromNullable(vDestOrig reflect.Value, vDest []any, nullable bool, iD
 				relationsField := strings.Split(tag.Get("relation"), ",")
 				for _, relation := range relations {
 					if len(relationsField) > 0 && relation == relationsField[0] {
-						setField, newIDest := SetDestFromNullable(fieldValue, vDest, isNullableField(relationsField), iDest)
+						nullable := isNullableField(relationsField)
+						setField, newIDest := SetDestFromNullable(fieldValue, vDest, nullable, iDest)
+						allNull := nullable && allNullScanned(vDest[iDest:newIDest])
 						iDest = newIDest
 
 						if kind == reflect.Pointer {
-							// проставляем для указателя, указатель из Value
-							fieldOrig.Set(setField.Addr())
+							// указатель на связь, для которой LEFT JOIN ничего не нашел, оставляем nil
+							if !allNull {
+								fieldOrig.Set(setField.Addr())
+							}
 						} else {
 							fieldOrig.Set(setField)
 						}
@@ -271,6 +252,16 @@ func SetDestFromNullable(vDestOrig reflect.Value, vDest []any, nullable bool, iD
 	return vDestOrig, iDest
 }
 
+func allNullScanned(values []any) bool {
+	for _, value := range values {
+		if !isNullScanned(value) {
+			return false
+		}
+	}
+
+	return true
+}
+
 func isNullableField(relationsField []string) bool {
 	count := 0
 	for _, relationField := range relationsField {
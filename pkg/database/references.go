@@ -0,0 +1,79 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrCompositeForeignKey на таблицу ссылается составной внешний ключ, ссылки по нему не переносятся
+var ErrCompositeForeignKey = errors.New("composite foreign key is not supported")
+
+// ForeignKeyReference колонка другой таблицы, ссылающаяся внешним ключом на таблицу
+type ForeignKeyReference struct {
+	Schema string `db:"schema"`
+	Table  string `db:"table_name"`
+	Column string `db:"column_name"`
+}
+
+// foreignKeyConstraint внешний ключ с количеством колонок для проверки составных ключей
+type foreignKeyConstraint struct {
+	ForeignKeyReference `embedded_struct:"1"`
+	Name                string `db:"name"`
+	Columns             int64  `db:"columns"`
+}
+
+// UniqueIndex уникальный индекс (или ограничение) таблицы
+type UniqueIndex struct {
+	Name    string   `db:"name"`
+	Columns []string `db:"columns"`
+}
+
+const referencingForeignKeysSql = `SELECT n.nspname, c.relname, a.attname, con.conname, array_length(con.conkey, 1)
+FROM pg_constraint con
+JOIN pg_class c ON c.oid = con.conrelid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+JOIN pg_attribute a ON a.attrelid = con.conrelid AND a.attnum = con.conkey[1]
+JOIN pg_attribute ra ON ra.attrelid = con.confrelid AND ra.attnum = ANY(con.confkey)
+WHERE con.contype = 'f' AND con.confrelid = $1::regclass AND ra.attname = $2
+ORDER BY n.nspname, c.relname, a.attname`
+
+const uniqueIndexesSql = `SELECT name, columns FROM (
+	SELECT i.indexrelid::regclass::text AS name,
+		array(SELECT a.attname FROM unnest(i.indkey) k JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = k) AS columns
+	FROM pg_index i
+	WHERE i.indrelid = $1::regclass AND i.indisunique AND i.indpred IS NULL
+) t
+WHERE $2 = ANY(columns)`
+
+// GetReferencingForeignKeys возвращает все внешние ключи, ссылающиеся на колонку column таблицы
+// Ключи на другие уникальные колонки таблицы не возвращаются. Если среди ключей есть составной,
+// возвращается ErrCompositeForeignKey: молча пропустить его нельзя, а перенос ссылок по нескольким колонкам не поддерживается
+func GetReferencingForeignKeys(ctx context.Context, db DBService, table, column string) ([]ForeignKeyReference, error) {
+	var constraints []foreignKeyConstraint
+	if err := db.Select(ctx, referencingForeignKeysSql, []any{table, column}, &constraints); err != nil {
+		return nil, err
+	}
+
+	res := make([]ForeignKeyReference, 0, len(constraints))
+	for _, constraint := range constraints {
+		if constraint.Columns > 1 {
+			return nil, fmt.Errorf("%w: %s on %s.%s", ErrCompositeForeignKey, constraint.Name, constraint.Schema, constraint.Table)
+		}
+
+		res = append(res, constraint.ForeignKeyReference)
+	}
+
+	return res, nil
+}
+
+// GetUniqueIndexes возвращает уникальные индексы таблицы, в которые входит колонка column
+// Частичные индексы и индексы по выражениям не учитываются
+func GetUniqueIndexes(ctx context.Context, db DBService, table, column string) ([]UniqueIndex, error) {
+	var res []UniqueIndex
+	if err := db.Select(ctx, uniqueIndexesSql, []any{table, column}, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
@@ -0,0 +1,52 @@
+package database_test
+
+import (
+	"context"
+
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/EveryHotel/core-tools/pkg/database"
+)
+
+var _ = Describe("References", func() {
+	var mockCtrl *gomock.Controller
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+	})
+
+	Describe("GetReferencingForeignKeys", func() {
+		It("scans referencing columns", func() {
+			mockPool := pgxpoolmock.NewMockPgxPool(mockCtrl)
+			pgxRows := pgxpoolmock.NewRows([]string{"nspname", "relname", "attname", "conname", "array_length"}).
+				AddRow("public", "rooms", "hotel_id", "rooms_hotel_id_fkey", int64(1)).
+				ToPgxRows()
+			mockPool.EXPECT().Query(gomock.Any(), gomock.Any(), "hotels", "id").Return(pgxRows, nil)
+			service := database.NewDBService(mockPool)
+
+			refs, err := database.GetReferencingForeignKeys(context.Background(), service, "hotels", "id")
+
+			Expect(err).Should(Succeed())
+			Expect(refs).Should(Equal([]database.ForeignKeyReference{
+				{Schema: "public", Table: "rooms", Column: "hotel_id"},
+			}))
+		})
+
+		It("fails on composite foreign key", func() {
+			mockPool := pgxpoolmock.NewMockPgxPool(mockCtrl)
+			pgxRows := pgxpoolmock.NewRows([]string{"nspname", "relname", "attname", "conname", "array_length"}).
+				AddRow("public", "rooms", "hotel_id", "rooms_hotel_id_fkey", int64(1)).
+				AddRow("public", "rates", "hotel_id", "rates_hotel_fkey", int64(2)).
+				ToPgxRows()
+			mockPool.EXPECT().Query(gomock.Any(), gomock.Any(), "hotels", "id").Return(pgxRows, nil)
+			service := database.NewDBService(mockPool)
+
+			_, err := database.GetReferencingForeignKeys(context.Background(), service, "hotels", "id")
+
+			Expect(err).Should(MatchError(database.ErrCompositeForeignKey))
+			Expect(err.Error()).Should(ContainSubstring("rates_hotel_fkey"))
+		})
+	})
+})
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/driftprogramming/pgxpoolmock"
+)
+
+// CtxDbForcePrimaryKey ключ контекста, при значении true чтение выполняется с primary (read-your-writes)
+const CtxDbForcePrimaryKey = "db_force_primary"
+
+type ReplicaStrategy int
+
+const (
+	// ReplicaRoundRobin реплики выбираются по очереди
+	ReplicaRoundRobin ReplicaStrategy = iota
+	// ReplicaLeastBusy выбирается реплика с наименьшим числом выполняющихся запросов
+	ReplicaLeastBusy
+)
+
+// WithReplicaStrategy задает стратегию выбора реплики для чтения
+func WithReplicaStrategy(strategy ReplicaStrategy) DBServiceOption {
+	return func(s *dbService) {
+		if s.replicas != nil {
+			s.replicas.strategy = strategy
+		}
+	}
+}
+
+type replicaSet struct {
+	pools    []pgxpoolmock.PgxPool
+	inflight []atomic.Int64
+	next     atomic.Uint64
+	strategy ReplicaStrategy
+}
+
+// NewDBServiceWithReplicas возвращает сервис БД, который направляет Select/SelectOne/Count/Stream на реплики,
+// а запись и все запросы внутри транзакции из контекста на primary
+func NewDBServiceWithReplicas(primary pgxpoolmock.PgxPool, replicas []pgxpoolmock.PgxPool, opts ...DBServiceOption) DBService {
+	s := &dbService{
+		pool:    primary,
+		dialect: "postgres",
+	}
+
+	if len(replicas) > 0 {
+		s.replicas = &replicaSet{
+			pools:    replicas,
+			inflight: make([]atomic.Int64, len(replicas)),
+		}
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// readPool возвращает пул для чтения и функцию, которую нужно вызвать после завершения запроса
+func (s *dbService) readPool(ctx context.Context) (pgxpoolmock.PgxPool, func()) {
+	if s.replicas == nil {
+		return s.pool, func() {}
+	}
+
+	if force, ok := ctx.Value(CtxDbForcePrimaryKey).(bool); ok && force {
+		return s.pool, func() {}
+	}
+
+	i := s.replicas.pick()
+	s.replicas.inflight[i].Add(1)
+
+	return s.replicas.pools[i], func() {
+		s.replicas.inflight[i].Add(-1)
+	}
+}
+
+func (r *replicaSet) pick() int {
+	if r.strategy == ReplicaLeastBusy {
+		best := 0
+		for i := 1; i < len(r.pools); i++ {
+			if r.inflight[i].Load() < r.inflight[best].Load() {
+				best = i
+			}
+		}
+
+		return best
+	}
+
+	return int((r.next.Add(1) - 1) % uint64(len(r.pools)))
+}
@@ -0,0 +1,59 @@
+package database_test
+
+import (
+	"context"
+
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/EveryHotel/core-tools/pkg/database"
+)
+
+var _ = Describe("Replicas", func() {
+	const countSql = "select count(*) from t"
+
+	var mockCtrl *gomock.Controller
+	var primary, replica1, replica2 *pgxpoolmock.MockPgxPool
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		primary = pgxpoolmock.NewMockPgxPool(mockCtrl)
+		replica1 = pgxpoolmock.NewMockPgxPool(mockCtrl)
+		replica2 = pgxpoolmock.NewMockPgxPool(mockCtrl)
+	})
+
+	It("reads from replicas in round robin order", func() {
+		replica1.EXPECT().QueryRow(gomock.Any(), countSql, gomock.Any()).Return(pgxpoolmock.NewRow([]string{"count"}, int64(1))).Times(2)
+		replica2.EXPECT().QueryRow(gomock.Any(), countSql, gomock.Any()).Return(pgxpoolmock.NewRow([]string{"count"}, int64(2))).Times(1)
+		service := database.NewDBServiceWithReplicas(primary, []pgxpoolmock.PgxPool{replica1, replica2})
+
+		var counts []int64
+		for i := 0; i < 3; i++ {
+			count, err := service.Count(context.Background(), countSql, nil)
+			Expect(err).Should(Succeed())
+			counts = append(counts, count)
+		}
+
+		Expect(counts).Should(Equal([]int64{1, 2, 1}))
+	})
+
+	It("reads from primary when forced by context", func() {
+		primary.EXPECT().QueryRow(gomock.Any(), countSql, gomock.Any()).Return(pgxpoolmock.NewRow([]string{"count"}, int64(1)))
+		service := database.NewDBServiceWithReplicas(primary, []pgxpoolmock.PgxPool{replica1})
+
+		ctx := context.WithValue(context.Background(), database.CtxDbForcePrimaryKey, true)
+		_, err := service.Count(ctx, countSql, nil)
+
+		Expect(err).Should(Succeed())
+	})
+
+	It("writes to primary", func() {
+		primary.EXPECT().Exec(gomock.Any(), "delete from t", gomock.Any()).Return(pgconn.CommandTag("DELETE 1"), nil)
+		service := database.NewDBServiceWithReplicas(primary, []pgxpoolmock.PgxPool{replica1})
+
+		Expect(service.Exec(context.Background(), "delete from t", nil)).Should(Succeed())
+	})
+})
@@ -1,97 +1,38 @@
 package database
 
 import (
-	"fmt"
 	"reflect"
-	"strings"
+	"slices"
 
 	"github.com/jonboulle/clockwork"
 )
 
-// TODO everyHotel
-//  Здесь поддерживаем два вараинта обработки вложенных структур
-//  - через inner_struct
-//  - через embedded_struct
-//  Желательно потом привести это все к одному знаменателю
-
 type SanitizeOption func(*sanitizeOptionHandler)
 
 type sanitizeOptionHandler struct {
-	relationDests []any
-	relations     []string
-	posRelations  []int
-	cols          []any
+	relations []relationMeta
+	cols      []any
 }
 
 // Sanitize возвращает список доступных DB полей у предоставленной структуры
+// Описание полей структуры кешируется по типу, поэтому повторные вызовы не обходят структуру заново
 func Sanitize(dest any, opts ...SanitizeOption) []any {
-	vDest := reflect.ValueOf(dest)
-	var cols []any
-	var relationDests []any
-	var relations []string
-	var posRelations []int
-
-	for i := 0; i < vDest.NumField(); i++ {
-		typeField := vDest.Type().Field(i)
-		tag := typeField.Tag
-
-		cols = append(cols, fetchColumns(typeField)...)
-
-		if tagVal := tag.Get("relation"); tagVal != "" {
-			relationsField := strings.Split(tag.Get("relation"), ",")
-			var vDestI any
-			kind := vDest.Field(i).Kind()
-
-			if kind == reflect.Pointer {
-				//создаем новый интерфейс из типа указателя
-				vDestI = reflect.New(vDest.Field(i).Type().Elem()).Elem().Interface()
-			} else {
-				vDestI = vDest.Field(i).Interface()
-			}
-			relationDests = append(relationDests, vDestI)
-			relations = append(relations, relationsField[0])
-			posRelations = append(posRelations, len(cols))
-		}
-	}
-
-	optHandler := newSanitizeOptionHandler(cols, relationDests, relations, posRelations)
-	for _, opt := range opts {
-		opt(optHandler)
-	}
-
-	return optHandler.GetCols()
+	return sanitizeType(reflect.TypeOf(dest), opts...)
 }
 
-func fetchColumns(typeField reflect.StructField) []any {
-	var cols []any
-	if tagVal := typeField.Tag.Get("db"); tagVal != "" {
-		cols = append(cols, tagVal)
-	}
+func sanitizeType(typ reflect.Type, opts ...SanitizeOption) []any {
+	meta := getStructMeta(typ)
 
-	embeddedStruct := typeField.Tag.Get("embedded_struct")
-	if embeddedStruct == "1" && typeField.Type.Kind() == reflect.Struct {
-		for i := 0; i < typeField.Type.NumField(); i++ {
-			cols = append(cols, fetchColumns(typeField.Type.Field(i))...)
-		}
+	optHandler := &sanitizeOptionHandler{
+		relations: meta.relations,
+		cols:      slices.Clone(meta.columns),
 	}
 
-	// если это вложенная структура, мы собираем отдельно все ее поля и добавляем в общий список
-	if innerStruct := typeField.Tag.Get("inner_struct"); innerStruct != "" {
-		for i := 0; i < typeField.Type.NumField(); i++ {
-			cols = append(cols, fetchColumns(typeField.Type.Field(i))...)
-		}
+	for _, opt := range opts {
+		opt(optHandler)
 	}
 
-	return cols
-}
-
-func newSanitizeOptionHandler(cols []any, relationDests []any, relations []string, posRelations []int) *sanitizeOptionHandler {
-	return &sanitizeOptionHandler{
-		relationDests: relationDests,
-		relations:     relations,
-		posRelations:  posRelations,
-		cols:          cols,
-	}
+	return optHandler.GetCols()
 }
 
 // WithPrefix возвращает опцию для задания префикса полей
@@ -111,32 +52,22 @@ func WithRelations(r ...string) SanitizeOption {
 // ApplyPrefix применяет префикс ко всем полям
 func (o *sanitizeOptionHandler) ApplyPrefix(p string) {
 	for i, col := range o.cols {
-		o.cols[i] = fmt.Sprintf("%s.%s", p, col)
+		o.cols[i] = p + "." + col.(string)
 	}
 }
 
 // SetRelations ищет по префиксу всех связей в структуре
+// Связи самих связей (вложенные) тоже добавляются, если их алиас есть в rels, каждый алиас используется один раз
 func (o *sanitizeOptionHandler) SetRelations(rels ...string) {
 	countShift := 0
-	for i, relation := range o.relations {
-		for _, rel := range rels {
-			if relation == rel {
-				relationsCols := Sanitize(o.relationDests[i], WithPrefix(rel))
-
-				positionShift := o.posRelations[i] + countShift
-				beforeCols := make([]any, len(o.cols[:positionShift]))
-				copy(beforeCols, o.cols[:positionShift])
-
-				afterCols := make([]any, len(o.cols[positionShift:]))
-				copy(afterCols, o.cols[positionShift:])
-
-				o.cols = append(beforeCols, relationsCols...)
-				o.cols = append(o.cols, afterCols...)
-
-				countShift += len(relationsCols)
-				break
-			}
+	for _, relation := range o.relations {
+		if !slices.Contains(rels, relation.name) {
+			continue
 		}
+
+		relationsCols := sanitizeType(relation.typ, WithPrefix(relation.name), WithRelations(withoutRelation(rels, relation.name)...))
+		o.cols = slices.Insert(o.cols, relation.pos+countShift, relationsCols...)
+		countShift += len(relationsCols)
 	}
 }
 
@@ -176,39 +107,18 @@ func SanitizeRows(entity any, clock clockwork.Clock, opts ...SanitizeRowsOption)
 	vEntity := reflect.ValueOf(entity)
 
 	var primary int64
-	rows := map[string]any{}
-	for i := 0; i < vEntity.NumField(); i++ {
-		tag := vEntity.Type().Field(i).Tag
-
-		// если это вложенная структура, мы собираем отдельно все ее поля и добавляем в общий список
-		if innerStruct := tag.Get("inner_struct"); innerStruct != "" {
-			innerPrimary, innerRows := SanitizeRows(vEntity.Field(i), clock, opts...)
-			for k, v := range innerRows {
-				rows[k] = v
+	fields := getStructMeta(vEntity.Type()).fields
+	rows := make(map[string]any, len(fields))
+	for _, field := range fields {
+		if field.Primary {
+			if !field.Embedded {
+				primary = vEntity.FieldByIndex(field.Index).Int()
 			}
-
-			primary = innerPrimary
-
 			continue
 		}
 
-		if dbFieldName := tag.Get("db"); dbFieldName != "" {
-			if pkTag := tag.Get("primary"); pkTag != "" {
-				primary = vEntity.Field(i).Int()
-				continue
-			}
-
-			if _, ok := handler.SkippingFields[dbFieldName]; !ok {
-				rows[dbFieldName] = vEntity.Field(i).Interface()
-			}
-		}
-		embeddedStruct := tag.Get("embedded_struct")
-		if embeddedStruct == "1" {
-			embeddedEntity := vEntity.Field(i).Interface()
-			_, embeddedRows := SanitizeRows(embeddedEntity, clock, opts...)
-			for key, val := range embeddedRows {
-				rows[key] = val
-			}
+		if _, ok := handler.SkippingFields[field.Column]; !ok {
+			rows[field.Column] = vEntity.FieldByIndex(field.Index).Interface()
 		}
 	}
 
@@ -64,7 +64,32 @@ var _ = Describe("Sanitize", func() {
 		})
 	})
 
+	Describe("Sanitize with relations", func() {
+		It("should insert relation columns in place of relation field", func() {
+			res := database.Sanitize(benchHotel{}, database.WithPrefix("h"), database.WithRelations("c"))
+
+			Expect(res).Should(Equal([]interface{}{
+				"h.id", "h.name", "h.stars", "h.price", "h.city_id", "c.id", "c.name", "h.created_at", "h.updated_at",
+			}))
+		})
+		It("should insert nested relation columns with their own prefix", func() {
+			res := database.Sanitize(relationHotel{}, database.WithPrefix("h"), database.WithRelations("c", "co"))
+
+			Expect(res).Should(Equal([]interface{}{"h.id", "c.id", "c.name", "c.country_id", "co.id", "co.name"}))
+		})
+	})
+
 	Describe("SanitizeRows", func() {
+		It("should collect inner_struct fields with primary", func() {
+			entity := struct {
+				Inner sanitizeTestCase `inner_struct:"1"`
+			}{Inner: testObjWithTags}
+
+			id, res := database.SanitizeRows(entity, clock)
+			Expect(id).Should(Equal(testObjWithTags.Id))
+			Expect(res).Should(HaveKeyWithValue("sentence", testObjWithTags.Sentence))
+			Expect(res).ShouldNot(HaveKey("id"))
+		})
 		It("when rows options is empty", func() {
 			id, res := database.SanitizeRows(testObjWithTags, clock)
 			Expect(id).Should(Equal(testObjWithTags.Id))
@@ -0,0 +1,162 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgx/v4"
+	"github.com/jonboulle/clockwork"
+	"github.com/shopspring/decimal"
+
+	"github.com/EveryHotel/core-tools/pkg/database"
+)
+
+type benchCity struct {
+	Id   int64  `db:"id" primary:"1"`
+	Name string `db:"name"`
+}
+
+type benchTimestamps struct {
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+type benchHotel struct {
+	Id         int64           `db:"id" primary:"1"`
+	Name       string          `db:"name"`
+	Stars      int64           `db:"stars"`
+	Price      decimal.Decimal `db:"price"`
+	CityId     *int64          `db:"city_id"`
+	City       benchCity       `relation:"c,nullable"`
+	Timestamps benchTimestamps `embedded_struct:"1"`
+}
+
+const benchRowsCount = 100
+
+// benchRows строки результата без разбора протокола, чтобы бенчмарк измерял только сканирование в структуры
+type benchRows struct {
+	pgx.Rows
+	values [][]any
+	i      int
+}
+
+func (r *benchRows) Next() bool {
+	r.i++
+	return r.i <= len(r.values)
+}
+
+func (r *benchRows) Scan(dest ...any) error {
+	for i, d := range dest {
+		value := r.values[r.i-1][i]
+		if scanner, ok := d.(sql.Scanner); ok {
+			if err := scanner.Scan(value); err != nil {
+				return err
+			}
+			continue
+		}
+		target := reflect.ValueOf(d).Elem()
+		if value == nil {
+			target.Set(reflect.Zero(target.Type()))
+			continue
+		}
+		target.Set(reflect.ValueOf(value))
+	}
+
+	return nil
+}
+
+func (r *benchRows) Err() error {
+	return nil
+}
+
+func (r *benchRows) Close() {}
+
+func benchHotelRows(relation bool) pgx.Rows {
+	rows := &benchRows{}
+	now := time.Now()
+	cityId := int64(1)
+	for i := 0; i < benchRowsCount; i++ {
+		if relation {
+			rows.values = append(rows.values, []any{int64(i), "hotel", int64(5), "100", &cityId, int64(1), "city", now, now})
+		} else {
+			rows.values = append(rows.values, []any{int64(i), "hotel", int64(5), "100", &cityId, now, now})
+		}
+	}
+
+	return rows
+}
+
+func benchSelect(b *testing.B, relations ...string) {
+	mockCtrl := gomock.NewController(b)
+	mockPool := pgxpoolmock.NewMockPgxPool(mockCtrl)
+	mockPool.EXPECT().Query(gomock.Any(), gomock.Any()).DoAndReturn(func(context.Context, string, ...any) (pgx.Rows, error) {
+		return benchHotelRows(len(relations) > 0), nil
+	}).AnyTimes()
+	service := database.NewDBService(mockPool)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var hotels []benchHotel
+		if err := service.Select(context.Background(), "select", nil, &hotels, relations...); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSelect(b *testing.B) {
+	benchSelect(b)
+}
+
+func BenchmarkSelectNullableRelation(b *testing.B) {
+	benchSelect(b, "c")
+}
+
+// BenchmarkSelectNullableRelationDeprecated сканирует те же строки устаревшими функциями, которые разбирали
+// структуру рефлексией на каждую строку, как Select до кеширования. Базовая линия для BenchmarkSelectNullableRelation
+func BenchmarkSelectNullableRelationDeprecated(b *testing.B) {
+	itemType := reflect.TypeFor[benchHotel]()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		rows := benchHotelRows(true)
+		var hotels []benchHotel
+		vDest := reflect.ValueOf(&hotels).Elem()
+
+		if !database.DestHasNullableRelations(reflect.New(itemType).Elem(), "c") {
+			b.Fatal("relation c must be nullable")
+		}
+		for rows.Next() {
+			nullableRow := database.GetNullableRowFromOrigDest(reflect.New(itemType).Elem(), false, "c")
+			scanFields := database.SetNullableDestFields(reflect.ValueOf(nullableRow), []any{})
+			if err := rows.Scan(scanFields...); err != nil {
+				b.Fatal(err)
+			}
+
+			destItem := reflect.New(itemType).Elem()
+			database.SetDestFromNullable(destItem, nullableRow, false, 0, "c")
+			vDest.Set(reflect.Append(vDest, destItem))
+		}
+	}
+}
+
+func BenchmarkSanitize(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		database.Sanitize(benchHotel{}, database.WithPrefix("h"), database.WithRelations("c"))
+	}
+}
+
+func BenchmarkSanitizeRows(b *testing.B) {
+	hotel := benchHotel{Id: 1, Name: "hotel", Stars: 5}
+	clock := clockwork.NewFakeClock()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		database.SanitizeRowsForInsert(hotel, clock)
+	}
+}
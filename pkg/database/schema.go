@@ -0,0 +1,229 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/EveryHotel/core-tools/pkg/types"
+)
+
+type SchemaIssueKind string
+
+const (
+	// SchemaColumnMissing колонка описана тегом db, но отсутствует в таблице
+	SchemaColumnMissing SchemaIssueKind = "missing_column"
+	// SchemaColumnNotMapped NOT NULL колонка без значения по умолчанию отсутствует в сущности, вставка упадет
+	SchemaColumnNotMapped SchemaIssueKind = "not_mapped_column"
+	// SchemaTypeMismatch тип поля несовместим с типом колонки
+	SchemaTypeMismatch SchemaIssueKind = "type_mismatch"
+	// SchemaDeletedAtMissing у soft удаляемой сущности в таблице нет колонки deleted_at
+	SchemaDeletedAtMissing SchemaIssueKind = "missing_deleted_at"
+)
+
+// SchemaIssue расхождение между тегами сущности и схемой таблицы
+type SchemaIssue struct {
+	Kind    SchemaIssueKind
+	Column  string
+	Message string
+}
+
+func (i SchemaIssue) String() string {
+	return fmt.Sprintf("%s %s: %s", i.Kind, i.Column, i.Message)
+}
+
+type schemaColumn struct {
+	Name       string `db:"column_name"`
+	DataType   string `db:"data_type"`
+	UdtName    string `db:"udt_name"`
+	Nullable   bool   `db:"nullable"`
+	HasDefault bool   `db:"has_default"`
+}
+
+type entityColumn struct {
+	name string
+	typ  reflect.Type
+}
+
+const schemaColumnsSql = `SELECT column_name, data_type, udt_name,
+	is_nullable = 'YES' AS nullable,
+	(column_default IS NOT NULL OR is_identity = 'YES' OR is_generated = 'ALWAYS') AS has_default
+FROM information_schema.columns
+WHERE table_schema = COALESCE($1::text, current_schema()) AND table_name = $2
+ORDER BY ordinal_position`
+
+// udtNamesByType совместимые типы колонок (udt_name) для известных типов полей
+var udtNamesByType = map[reflect.Type][]string{
+	reflect.TypeOf(time.Time{}):            {"timestamp", "timestamptz", "date"},
+	reflect.TypeOf(sql.NullTime{}):         {"timestamp", "timestamptz", "date"},
+	reflect.TypeOf(sql.NullString{}):       {"text", "varchar", "bpchar", "uuid", "citext"},
+	reflect.TypeOf(sql.NullInt64{}):        {"int2", "int4", "int8"},
+	reflect.TypeOf(sql.NullInt32{}):        {"int2", "int4"},
+	reflect.TypeOf(sql.NullFloat64{}):      {"float4", "float8", "numeric"},
+	reflect.TypeOf(sql.NullBool{}):         {"bool"},
+	reflect.TypeOf(decimal.Decimal{}):      {"numeric"},
+	reflect.TypeOf(decimal.NullDecimal{}):  {"numeric"},
+	reflect.TypeOf(json.RawMessage{}):      {"json", "jsonb"},
+	reflect.TypeOf(types.RawMessage{}):     {"json", "jsonb"},
+	reflect.TypeOf(types.NullRawMessage{}): {"json", "jsonb"},
+	reflect.TypeOf(types.Date{}):           {"date", "timestamp", "timestamptz"},
+	reflect.TypeOf(types.NullDate{}):       {"date", "timestamp", "timestamptz"},
+	reflect.TypeOf(types.DateTime{}):       {"timestamp", "timestamptz"},
+	reflect.TypeOf(types.NullDateTime{}):   {"timestamp", "timestamptz"},
+	reflect.TypeOf(types.Time{}):           {"time", "timetz"},
+	reflect.TypeOf(types.NullTime{}):       {"time", "timetz"},
+	reflect.TypeOf(types.TimeHM{}):         {"time", "timetz"},
+	reflect.TypeOf(types.NullTimeHM{}):     {"time", "timetz"},
+}
+
+// udtNamesByKind совместимые типы колонок для базовых типов Go
+var udtNamesByKind = map[reflect.Kind][]string{
+	reflect.Bool:    {"bool"},
+	reflect.Int:     {"int2", "int4", "int8"},
+	reflect.Int8:    {"int2"},
+	reflect.Int16:   {"int2"},
+	reflect.Int32:   {"int2", "int4"},
+	reflect.Int64:   {"int2", "int4", "int8"},
+	reflect.Uint8:   {"int2"},
+	reflect.Uint16:  {"int2", "int4"},
+	reflect.Uint32:  {"int4", "int8"},
+	reflect.Uint64:  {"int8", "numeric"},
+	reflect.Float32: {"float4", "float8", "numeric"},
+	reflect.Float64: {"float4", "float8", "numeric"},
+	reflect.String:  {"text", "varchar", "bpchar", "uuid", "citext", "name"},
+	reflect.Map:     {"json", "jsonb"},
+}
+
+// VerifySchema сравнивает колонки, описанные тегами db, embedded_struct и inner_struct сущности, с колонками таблицы
+// Таблица может быть указана со схемой, иначе используется current_schema(). Связи (relation) не проверяются
+func VerifySchema(ctx context.Context, db DBService, table string, entity any) ([]SchemaIssue, error) {
+	var schema any
+	name := table
+	if parts := strings.SplitN(table, ".", 2); len(parts) == 2 {
+		schema, name = parts[0], parts[1]
+	}
+
+	var columns []schemaColumn
+	if err := db.Select(ctx, schemaColumnsSql, []any{schema, name}, &columns); err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %s not found", table)
+	}
+
+	dbColumns := make(map[string]schemaColumn, len(columns))
+	for _, column := range columns {
+		dbColumns[column.Name] = column
+	}
+
+	entityType := reflect.TypeOf(entity)
+	if entityType.Kind() == reflect.Pointer {
+		entityType = entityType.Elem()
+	}
+
+	var issues []SchemaIssue
+	if field, ok := entityType.FieldByName("DeletedAt"); ok && field.Tag.Get("db") != "" {
+		if _, ok = dbColumns["deleted_at"]; !ok {
+			issues = append(issues, SchemaIssue{
+				Kind:    SchemaDeletedAtMissing,
+				Column:  "deleted_at",
+				Message: "entity is soft deleting but table has no deleted_at column",
+			})
+		}
+	}
+
+	mapped := make(map[string]bool)
+	for _, column := range entityColumns(entityType) {
+		mapped[column.name] = true
+
+		dbColumn, ok := dbColumns[column.name]
+		if !ok {
+			if column.name != "deleted_at" {
+				issues = append(issues, SchemaIssue{
+					Kind:    SchemaColumnMissing,
+					Column:  column.name,
+					Message: fmt.Sprintf("field of type %s has no column", column.typ),
+				})
+			}
+			continue
+		}
+
+		if !isCompatibleColumnType(column.typ, dbColumn) {
+			issues = append(issues, SchemaIssue{
+				Kind:    SchemaTypeMismatch,
+				Column:  column.name,
+				Message: fmt.Sprintf("field of type %s is not compatible with column of type %s", column.typ, dbColumn.UdtName),
+			})
+		}
+	}
+
+	for _, column := range columns {
+		if !mapped[column.Name] && !column.Nullable && !column.HasDefault {
+			issues = append(issues, SchemaIssue{
+				Kind:    SchemaColumnNotMapped,
+				Column:  column.Name,
+				Message: "not null column without default is not mapped to entity",
+			})
+		}
+	}
+
+	return issues, nil
+}
+
+// entityColumns собирает колонки сущности так же, как Sanitize, но вместе с типами полей
+func entityColumns(entityType reflect.Type) []entityColumn {
+	var res []entityColumn
+	for i := 0; i < entityType.NumField(); i++ {
+		field := entityType.Field(i)
+
+		if name := field.Tag.Get("db"); name != "" {
+			typ := field.Type
+			if typ.Kind() == reflect.Pointer {
+				typ = typ.Elem()
+			}
+			res = append(res, entityColumn{name: name, typ: typ})
+		}
+
+		if (field.Tag.Get("embedded_struct") == "1" || field.Tag.Get("inner_struct") != "") && field.Type.Kind() == reflect.Struct {
+			res = append(res, entityColumns(field.Type)...)
+		}
+	}
+
+	return res
+}
+
+func isCompatibleColumnType(typ reflect.Type, column schemaColumn) bool {
+	// пользовательские типы (enum, domain) не проверяем
+	if column.DataType == "USER-DEFINED" {
+		return true
+	}
+
+	if names, ok := udtNamesByType[typ]; ok {
+		return slices.Contains(names, column.UdtName)
+	}
+
+	switch typ.Kind() {
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return slices.Contains([]string{"bytea", "json", "jsonb"}, column.UdtName)
+		}
+		return column.DataType == "ARRAY" || column.UdtName == "json" || column.UdtName == "jsonb"
+	case reflect.Struct:
+		// прочие структуры, например со своим sql.Scanner, проверить нельзя
+		return true
+	}
+
+	names, ok := udtNamesByKind[typ.Kind()]
+	if !ok {
+		return true
+	}
+
+	return slices.Contains(names, column.UdtName)
+}
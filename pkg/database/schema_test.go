@@ -0,0 +1,70 @@
+package database_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/shopspring/decimal"
+
+	"github.com/EveryHotel/core-tools/pkg/database"
+)
+
+type schemaHotelAddress struct {
+	City string `db:"city"`
+}
+
+type schemaHotel struct {
+	Id        int64              `db:"id" primary:"1"`
+	Name      string             `db:"name"`
+	Price     decimal.Decimal    `db:"price"`
+	Rating    *float64           `db:"rating"`
+	Address   schemaHotelAddress `embedded_struct:"1"`
+	DeletedAt *time.Time         `db:"deleted_at"`
+}
+
+var _ = Describe("VerifySchema", func() {
+	var mockCtrl *gomock.Controller
+	var mockPool *pgxpoolmock.MockPgxPool
+	var service database.DBService
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockPool = pgxpoolmock.NewMockPgxPool(mockCtrl)
+		service = database.NewDBService(mockPool)
+	})
+
+	It("reports drift between tags and table columns", func() {
+		rows := pgxpoolmock.NewRows([]string{"column_name", "data_type", "udt_name", "nullable", "has_default"}).
+			AddRow("id", "bigint", "int8", false, true).
+			AddRow("name", "text", "text", false, false).
+			AddRow("price", "text", "text", false, false).
+			AddRow("rating", "double precision", "float8", true, false).
+			AddRow("code", "text", "text", false, false).
+			AddRow("note", "text", "text", true, false).
+			ToPgxRows()
+		mockPool.EXPECT().Query(gomock.Any(), gomock.Any(), nil, "hotels").Return(rows, nil)
+
+		issues, err := database.VerifySchema(context.Background(), service, "hotels", schemaHotel{})
+
+		Expect(err).Should(Succeed())
+		Expect(issues).Should(ConsistOf(
+			database.SchemaIssue{Kind: database.SchemaDeletedAtMissing, Column: "deleted_at", Message: "entity is soft deleting but table has no deleted_at column"},
+			database.SchemaIssue{Kind: database.SchemaTypeMismatch, Column: "price", Message: "field of type decimal.Decimal is not compatible with column of type text"},
+			database.SchemaIssue{Kind: database.SchemaColumnMissing, Column: "city", Message: "field of type string has no column"},
+			database.SchemaIssue{Kind: database.SchemaColumnNotMapped, Column: "code", Message: "not null column without default is not mapped to entity"},
+		))
+	})
+
+	It("fails for unknown table", func() {
+		rows := pgxpoolmock.NewRows([]string{"column_name", "data_type", "udt_name", "nullable", "has_default"}).ToPgxRows()
+		mockPool.EXPECT().Query(gomock.Any(), gomock.Any(), "public", "hotels").Return(rows, nil)
+
+		_, err := database.VerifySchema(context.Background(), service, "public.hotels", schemaHotel{})
+
+		Expect(err).Should(HaveOccurred())
+	})
+})
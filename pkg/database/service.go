@@ -2,14 +2,19 @@ package database
 
 import (
 	"context"
-	"database/sql"
+	"errors"
 	"fmt"
+	"log/slog"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
 	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // TODO everyHotel
@@ -27,46 +32,105 @@ const CtxDbTxKey = "db_tx"
 type DBService interface {
 	Dialect() goqu.DialectWrapper
 	Exec(ctx context.Context, query string, args []any) error
+	ExecAffected(ctx context.Context, query string, args []any) (int64, error)
 	Insert(ctx context.Context, sql string, args []any, dest any) error
 	Count(ctx context.Context, sql string, args []any) (int64, error)
 	SelectOne(ctx context.Context, sql string, args []any, dest any, relations ...string) error
 	Select(ctx context.Context, sql string, args []any, dest any, relations ...string) error
+	Stream(ctx context.Context, sql string, args []any, dest any, handle func() error, relations ...string) error
 	Begin(ctx context.Context) (context.Context, error)
+	RunInTx(ctx context.Context, fn func(ctx context.Context) error, opts ...TxOption) error
 	Commit(ctx context.Context) error
 	Rollback(ctx context.Context) error
 	InsertMany(ctx context.Context, sql string, args []any, dest any) error
+	CopyFrom(ctx context.Context, table string, columns []string, rows [][]any) (int64, error)
+	Listen(ctx context.Context, channel string, handler NotificationHandler) error
+	TryLock(ctx context.Context, key int64) (bool, error)
+	TrySessionLock(ctx context.Context, key int64) (unlock func(), locked bool, err error)
+	WithLock(ctx context.Context, key int64, fn func(ctx context.Context) error) error
+	RunLeaderElection(ctx context.Context, key int64, onElected func(ctx context.Context), onLost func(), opts ...LeaderOption) error
 }
 
+// PgxCopyPool пул соединений с поддержкой COPY, ему удовлетворяет *pgxpool.Pool
+// pgxpoolmock.PgxPool не содержит CopyFrom, поэтому поддержка проверяется приведением типа
+type PgxCopyPool interface {
+	pgxpoolmock.PgxPool
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
+}
+
+var ErrCopyNotSupported = errors.New("pool does not support copy")
+
 type dbService struct {
-	pool pgxpoolmock.PgxPool
+	pool               pgxpoolmock.PgxPool
+	replicas           *replicaSet
+	slowQueryThreshold time.Duration
+	dialect            string
 }
 
 // NewDBService возвращает новый экзмпляр сервиса БД
-func NewDBService(pool pgxpoolmock.PgxPool) DBService {
-	return &dbService{
-		pool: pool,
+func NewDBService(pool pgxpoolmock.PgxPool, opts ...DBServiceOption) DBService {
+	s := &dbService{
+		pool:    pool,
+		dialect: "postgres",
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
 }
 
-// Dialect возвращает postgres диалект для goqu
+// Dialect возвращает диалект goqu, по умолчанию postgres
 func (s *dbService) Dialect() goqu.DialectWrapper {
-	return goqu.Dialect("postgres")
+	return goqu.Dialect(s.dialect)
+}
+
+// WithDialect задает диалект goqu, который возвращает Dialect, для пулов не postgres баз
+func WithDialect(dialect string) DBServiceOption {
+	return func(s *dbService) {
+		s.dialect = dialect
+	}
+}
+
+// SupportsRowLocks проверяет, что диалект db умеет блокировать строки (SELECT ... FOR UPDATE)
+// В SQLite блокировок строк нет, запросы с ними выполняются без блокировки
+func SupportsRowLocks(db DBService) bool {
+	sql, _, err := db.Dialect().From("t").ForUpdate(exp.Wait).ToSQL()
+	return err == nil && strings.Contains(sql, "FOR UPDATE")
 }
 
 // Exec выполняет запрос
 func (s *dbService) Exec(ctx context.Context, query string, args []any) (err error) {
+	_, err = s.ExecAffected(ctx, query, args)
+
+	return err
+}
+
+// ExecAffected выполняет запрос и возвращает количество затронутых строк
+func (s *dbService) ExecAffected(ctx context.Context, query string, args []any) (affected int64, err error) {
+	ctx, qt := s.startQuery(ctx, "Exec", query)
+	defer func() { qt.end(ctx, err, affected) }()
+
+	var tag pgconn.CommandTag
 	tx, ok := ctx.Value(CtxDbTxKey).(pgx.Tx)
 	if ok {
-		_, err = tx.Exec(ctx, query, args...)
+		tag, err = tx.Exec(ctx, query, args...)
 	} else {
-		_, err = s.pool.Exec(ctx, query, args...)
+		tag, err = s.pool.Exec(ctx, query, args...)
 	}
 
-	return err
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
 }
 
 // Insert выполняет insert запрос и возвращает данные в dest
 func (s *dbService) Insert(ctx context.Context, sql string, args []any, dest any) (err error) {
+	ctx, qt := s.startQuery(ctx, "Insert", sql)
+	defer func() { qt.end(ctx, err, 1) }()
+
 	tx, ok := ctx.Value(CtxDbTxKey).(pgx.Tx)
 	if ok {
 		err = tx.QueryRow(ctx, sql, args...).Scan(dest)
@@ -79,6 +143,9 @@ func (s *dbService) Insert(ctx context.Context, sql string, args []any, dest any
 
 // InsertMany выполняет insert запрос и возвращает множественные данные в dest
 func (s *dbService) InsertMany(ctx context.Context, sql string, args []any, dest any) (err error) {
+	ctx, qt := s.startQuery(ctx, "InsertMany", sql)
+	defer func() { qt.end(ctx, err, sliceLen(dest)) }()
+
 	var rows pgx.Rows
 	tx, ok := ctx.Value(CtxDbTxKey).(pgx.Tx)
 	if ok {
@@ -98,14 +165,39 @@ func (s *dbService) InsertMany(ctx context.Context, sql string, args []any, dest
 	return nil
 }
 
+// CopyFrom загружает строки в таблицу через COPY и возвращает количество добавленных строк
+func (s *dbService) CopyFrom(ctx context.Context, table string, columns []string, rows [][]any) (copied int64, err error) {
+	ctx, qt := s.startQuery(ctx, "CopyFrom", "")
+	qt.span.SetAttributes(attribute.String("db.sql.table", table))
+	defer func() { qt.end(ctx, err, copied) }()
+
+	identifier := pgx.Identifier(strings.Split(table, "."))
+
+	if tx, ok := ctx.Value(CtxDbTxKey).(pgx.Tx); ok {
+		return tx.CopyFrom(ctx, identifier, columns, pgx.CopyFromRows(rows))
+	}
+
+	pool, ok := s.pool.(PgxCopyPool)
+	if !ok {
+		return 0, ErrCopyNotSupported
+	}
+
+	return pool.CopyFrom(ctx, identifier, columns, pgx.CopyFromRows(rows))
+}
+
 // Select выполняет SELECT запрос и сохраняет результаты в массив структур dest
 func (s *dbService) Select(ctx context.Context, sql string, args []any, dest any, relations ...string) (err error) {
+	ctx, qt := s.startQuery(ctx, "Select", sql)
+	defer func() { qt.end(ctx, err, sliceLen(dest)) }()
+
 	var rows pgx.Rows
 	tx, ok := ctx.Value(CtxDbTxKey).(pgx.Tx)
 	if ok {
 		rows, err = tx.Query(ctx, sql, args...)
 	} else {
-		rows, err = s.pool.Query(ctx, sql, args...)
+		pool, release := s.readPool(ctx)
+		defer release()
+		rows, err = pool.Query(ctx, sql, args...)
 	}
 
 	if err != nil {
@@ -119,14 +211,60 @@ func (s *dbService) Select(ctx context.Context, sql string, args []any, dest any
 	return nil
 }
 
+// Stream выполняет SELECT запрос и по очереди сканирует каждую строку в структуру dest, вызывая handle после каждой
+// Результат не накапливается в памяти. Ошибка handle прерывает чтение и возвращается как есть
+func (s *dbService) Stream(ctx context.Context, sql string, args []any, dest any, handle func() error, relations ...string) (err error) {
+	var handled int64
+	ctx, qt := s.startQuery(ctx, "Stream", sql)
+	defer func() { qt.end(ctx, err, handled) }()
+
+	var rows pgx.Rows
+	tx, ok := ctx.Value(CtxDbTxKey).(pgx.Tx)
+	if ok {
+		rows, err = tx.Query(ctx, sql, args...)
+	} else {
+		pool, release := s.readPool(ctx)
+		defer release()
+		rows, err = pool.Query(ctx, sql, args...)
+	}
+
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	vDest := reflect.ValueOf(dest).Elem()
+	zero := reflect.Zero(vDest.Type())
+	plan := getScanPlan(vDest.Type(), relations...)
+
+	for rows.Next() {
+		vDest.Set(zero)
+		if err = plan.scan(rows, vDest); err != nil {
+			return err
+		}
+
+		if err = handle(); err != nil {
+			return err
+		}
+		handled++
+	}
+
+	return rows.Err()
+}
+
 // SelectOne выполняет SELECT запрос и сохраняет только первый результат в структуру dest
 func (s *dbService) SelectOne(ctx context.Context, sql string, args []any, dest any, relations ...string) (err error) {
+	ctx, qt := s.startQuery(ctx, "SelectOne", sql)
+	defer func() { qt.end(ctx, err, 1) }()
+
 	var row pgx.Row
 	tx, ok := ctx.Value(CtxDbTxKey).(pgx.Tx)
 	if ok {
 		row = tx.QueryRow(ctx, sql, args...)
 	} else {
-		row = s.pool.QueryRow(ctx, sql, args...)
+		pool, release := s.readPool(ctx)
+		defer release()
+		row = pool.QueryRow(ctx, sql, args...)
 	}
 
 	if err = scanRow(row, dest, relations...); err != nil {
@@ -138,12 +276,17 @@ func (s *dbService) SelectOne(ctx context.Context, sql string, args []any, dest
 
 // Count выполняет COUNT запрос
 func (s *dbService) Count(ctx context.Context, sql string, args []any) (count int64, err error) {
+	ctx, qt := s.startQuery(ctx, "Count", sql)
+	defer func() { qt.end(ctx, err, -1) }()
+
 	var row pgx.Row
 	tx, ok := ctx.Value(CtxDbTxKey).(pgx.Tx)
 	if ok {
 		row = tx.QueryRow(ctx, sql, args...)
 	} else {
-		row = s.pool.QueryRow(ctx, sql, args...)
+		pool, release := s.readPool(ctx)
+		defer release()
+		row = pool.QueryRow(ctx, sql, args...)
 	}
 
 	if err = row.Scan(&count); err != nil {
@@ -154,8 +297,27 @@ func (s *dbService) Count(ctx context.Context, sql string, args []any) (count in
 }
 
 // Begin Создает транзакцию и возвращает связанный с нею контекст
+// Если в контексте уже есть транзакция, создается вложенная транзакция через SAVEPOINT,
+// Commit и Rollback для нее освобождают или откатывают только этот SAVEPOINT
 func (s *dbService) Begin(ctx context.Context) (context.Context, error) {
-	tx, err := s.pool.Begin(ctx)
+	spanCtx, qt := s.startQuery(ctx, "Begin", "")
+	txCtx, err := s.beginTx(ctx, pgx.TxOptions{})
+	qt.end(spanCtx, err, -1)
+
+	return txCtx, err
+}
+
+func (s *dbService) beginTx(ctx context.Context, txOptions pgx.TxOptions) (context.Context, error) {
+	var tx pgx.Tx
+	var err error
+	if parent, ok := ctx.Value(CtxDbTxKey).(pgx.Tx); ok {
+		tx, err = parent.Begin(ctx)
+	} else if txOptions == (pgx.TxOptions{}) {
+		tx, err = s.pool.Begin(ctx)
+	} else {
+		tx, err = s.pool.BeginTx(ctx, txOptions)
+	}
+
 	if err != nil {
 		return ctx, err
 	}
@@ -165,11 +327,116 @@ func (s *dbService) Begin(ctx context.Context) (context.Context, error) {
 	return ctx, nil
 }
 
+// RunInTx выполняет fn в транзакции: коммитит при успехе, откатывает при ошибке или панике
+// Транзакция верхнего уровня повторяется при ошибках сериализации и дедлоках (SQLSTATE 40001/40P01),
+// вложенный вызов выполняется в SAVEPOINT без повторов
+func (s *dbService) RunInTx(ctx context.Context, fn func(ctx context.Context) error, opts ...TxOption) error {
+	handler := &txOptionHandler{
+		maxRetries: 3,
+		retryDelay: 50 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(handler)
+	}
+
+	if _, ok := ctx.Value(CtxDbTxKey).(pgx.Tx); ok {
+		return s.runInTx(ctx, fn, pgx.TxOptions{})
+	}
+
+	txOptions := pgx.TxOptions{IsoLevel: handler.isoLevel}
+	for attempt := 0; ; attempt++ {
+		err := s.runInTx(ctx, fn, txOptions)
+		if err == nil || attempt >= handler.maxRetries || !IsRetryableTxError(err) {
+			return err
+		}
+
+		slog.WarnContext(ctx, "Retry transaction",
+			slog.Any("error", err),
+			slog.Int("attempt", attempt+1),
+		)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(handler.retryDelay * time.Duration(attempt+1)):
+		}
+	}
+}
+
+func (s *dbService) runInTx(ctx context.Context, fn func(ctx context.Context) error, txOptions pgx.TxOptions) (err error) {
+	spanCtx, qt := s.startQuery(ctx, "Begin", "")
+	txCtx, err := s.beginTx(ctx, txOptions)
+	qt.end(spanCtx, err, -1)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = s.Rollback(txCtx)
+			err = fmt.Errorf("panic in tx: %v", p)
+		}
+	}()
+
+	if err = fn(txCtx); err != nil {
+		if rbErr := s.Rollback(txCtx); rbErr != nil {
+			slog.ErrorContext(ctx, "Error during rollback tx",
+				slog.Any("error", rbErr),
+			)
+		}
+		return err
+	}
+
+	return s.Commit(txCtx)
+}
+
+// IsRetryableTxError проверяет, можно ли повторить транзакцию после ошибки (serialization_failure, deadlock_detected)
+func IsRetryableTxError(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+
+	return pgErr.Code == "40001" || pgErr.Code == "40P01"
+}
+
+type TxOption func(*txOptionHandler)
+
+type txOptionHandler struct {
+	isoLevel   pgx.TxIsoLevel
+	maxRetries int
+	retryDelay time.Duration
+}
+
+// WithIsolationLevel задает уровень изоляции транзакции верхнего уровня
+func WithIsolationLevel(level pgx.TxIsoLevel) TxOption {
+	return func(h *txOptionHandler) {
+		h.isoLevel = level
+	}
+}
+
+// WithMaxRetries задает количество повторов транзакции при ошибках сериализации и дедлоках
+func WithMaxRetries(retries int) TxOption {
+	return func(h *txOptionHandler) {
+		h.maxRetries = retries
+	}
+}
+
+// WithRetryDelay задает базовую задержку между повторами, она растет линейно с номером попытки
+func WithRetryDelay(delay time.Duration) TxOption {
+	return func(h *txOptionHandler) {
+		h.retryDelay = delay
+	}
+}
+
 // Commit Применяет транзакцию
-func (s *dbService) Commit(ctx context.Context) error {
+func (s *dbService) Commit(ctx context.Context) (err error) {
 	tx, ok := ctx.Value(CtxDbTxKey).(pgx.Tx)
 	if ok {
-		if err := tx.Commit(ctx); err != nil {
+		spanCtx, qt := s.startQuery(ctx, "Commit", "")
+		defer func() { qt.end(spanCtx, err, -1) }()
+
+		if err = tx.Commit(ctx); err != nil {
 			return fmt.Errorf("commit tx: %w", err)
 		}
 	}
@@ -189,33 +456,15 @@ func (s *dbService) Rollback(ctx context.Context) error {
 
 func scanRows(rows pgx.Rows, dest any, relations ...string) error {
 	vDest := reflect.ValueOf(dest).Elem()
-
-	// определяем - является ли сущность с nullable сущностями для оптимизации обычных запросов
-	nullable := DestHasNullableRelations(reflect.New(reflect.TypeOf(dest).Elem().Elem()).Elem(), relations...)
-
-	var nullableRow, scanFields []any
+	// reflect.TypeOf(dest) это указатель
+	// первый Elem() разыименовывает его, второй Elem() получает тип элемента в slice
+	itemType := reflect.TypeOf(dest).Elem().Elem()
+	plan := getScanPlan(itemType, relations...)
 
 	for rows.Next() {
-		// создаем nullableRow, куда будет происходить скан
-		if nullable {
-			nullableRow = GetNullableRowFromOrigDest(reflect.New(reflect.TypeOf(dest).Elem().Elem()).Elem(), false, relations...)
-			scanFields = SetNullableDestFields(reflect.ValueOf(nullableRow), []any{})
-		}
-		// reflect.TypeOf(dest) это указатель
-		// первый Elem() разыименовывает его, второй Elem() получает типо элемента в slice
-		destItem := reflect.New(reflect.TypeOf(dest).Elem().Elem()).Elem()
-
-		// если это сущность с nullable, то мы сначала сканируем,(тк мы до этого уже сделали scanFields) и потом проставляем заначения в сущность из nullableRow
-		if nullable {
-			if err := rows.Scan(scanFields...); err != nil {
-				return err
-			}
-			SetDestFromNullable(destItem, nullableRow, false, 0, relations...)
-		} else {
-			scanFields = setDestFields(destItem, []any{}, relations...)
-			if err := rows.Scan(scanFields...); err != nil {
-				return err
-			}
+		destItem := reflect.New(itemType).Elem()
+		if err := plan.scan(rows, destItem); err != nil {
+			return err
 		}
 
 		vDest.Set(reflect.Append(vDest, destItem))
@@ -227,61 +476,5 @@ func scanRows(rows pgx.Rows, dest any, relations ...string) error {
 func scanRow(row pgx.Row, dest any, relations ...string) error {
 	vDest := reflect.ValueOf(dest).Elem()
 
-	// определяем - является ли сущность с nullable сущностями для оптимизации обычных запросов
-	nullable := DestHasNullableRelations(vDest, relations...)
-
-	if nullable {
-		// создаем nullableRow, куда будет происходить скан
-		nullableRow := GetNullableRowFromOrigDest(vDest, false, relations...)
-
-		scanFields := SetNullableDestFields(reflect.ValueOf(nullableRow), []any{})
-		if err := row.Scan(scanFields...); err != nil {
-			return err
-		}
-
-		//проставляем заначения в сущность из nullableRow
-		SetDestFromNullable(vDest, nullableRow, false, 0, relations...)
-	} else {
-		scanFields := setDestFields(vDest, []any{}, relations...)
-		if err := row.Scan(scanFields...); err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-func setDestFields(vDest reflect.Value, scanFields []any, relations ...string) []any {
-	if vDest.Type().Kind() != reflect.Struct || vDest.Type().Implements(reflect.TypeOf((*sql.Scanner)(nil)).Elem()) {
-		scanFields = append(scanFields, vDest.Addr().Interface())
-		return scanFields
-	}
-
-	for i := 0; i < vDest.NumField(); i++ {
-		field := vDest.Field(i)
-		typeField := vDest.Type().Field(i)
-		tag := typeField.Tag
-
-		if field.Kind() == reflect.Struct && len(relations) > 0 {
-			for _, relation := range relations {
-				values := strings.Split(tag.Get("relation"), ",")
-				if len(values) > 0 && values[0] == relation {
-					scanFields = setDestFields(field, scanFields)
-				}
-			}
-		}
-
-		if tag.Get("inner_struct") != "" {
-			scanFields = setDestFields(field, scanFields)
-		}
-
-		if tag.Get("db") != "" {
-			scanFields = append(scanFields, field.Addr().Interface())
-		}
-		if tag.Get("embedded_struct") == "1" && field.Kind() == reflect.Struct {
-			scanFields = setDestFields(field, scanFields)
-		}
-	}
-
-	return scanFields
+	return getScanPlan(vDest.Type(), relations...).scan(row, vDest)
 }
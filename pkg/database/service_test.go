@@ -2,9 +2,12 @@ package database_test
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/driftprogramming/pgxpoolmock"
 	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4/pgxpool"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -12,6 +15,23 @@ import (
 	"github.com/EveryHotel/core-tools/pkg/database"
 )
 
+type relationCountry struct {
+	Id   int64  `db:"id" primary:"1"`
+	Name string `db:"name"`
+}
+
+type relationCity struct {
+	Id        int64            `db:"id" primary:"1"`
+	Name      string           `db:"name"`
+	CountryId *int64           `db:"country_id"`
+	Country   *relationCountry `relation:"co,nullable"`
+}
+
+type relationHotel struct {
+	Id   int64         `db:"id" primary:"1"`
+	City *relationCity `relation:"c,nullable"`
+}
+
 var _ = Describe("Service", func() {
 	var mockCtrl *gomock.Controller
 	BeforeEach(func() {
@@ -35,6 +55,127 @@ var _ = Describe("Service", func() {
 		})
 	})
 
+	Describe("ExecAffected", func() {
+		It("returns rows affected from command tag", func() {
+			mockPool := pgxpoolmock.NewMockPgxPool(mockCtrl)
+			mockPool.EXPECT().Exec(gomock.Any(), "update t set v = 1", gomock.Any()).Return(pgconn.CommandTag("UPDATE 2"), nil)
+			service := database.NewDBService(mockPool)
+
+			affected, err := service.ExecAffected(context.Background(), "update t set v = 1", nil)
+
+			Expect(err).Should(Succeed())
+			Expect(affected).Should(Equal(int64(2)))
+		})
+	})
+
+	Describe("Stream", func() {
+		type streamItem struct {
+			Id   int64  `db:"id"`
+			Name string `db:"name"`
+		}
+
+		It("handles rows one by one", func() {
+			mockPool := pgxpoolmock.NewMockPgxPool(mockCtrl)
+			pgxRows := pgxpoolmock.NewRows([]string{"id", "name"}).
+				AddRow(int64(1), "first").
+				AddRow(int64(2), "second").
+				ToPgxRows()
+			mockPool.EXPECT().Query(gomock.Any(), "select id, name from t", gomock.Any()).Return(pgxRows, nil)
+			service := database.NewDBService(mockPool)
+
+			var item streamItem
+			var names []string
+			err := service.Stream(context.Background(), "select id, name from t", nil, &item, func() error {
+				names = append(names, item.Name)
+				return nil
+			})
+
+			Expect(err).Should(Succeed())
+			Expect(names).Should(Equal([]string{"first", "second"}))
+		})
+
+		It("stops on handler error", func() {
+			mockPool := pgxpoolmock.NewMockPgxPool(mockCtrl)
+			pgxRows := pgxpoolmock.NewRows([]string{"id", "name"}).
+				AddRow(int64(1), "first").
+				AddRow(int64(2), "second").
+				ToPgxRows()
+			mockPool.EXPECT().Query(gomock.Any(), "select id, name from t", gomock.Any()).Return(pgxRows, nil)
+			service := database.NewDBService(mockPool)
+
+			stopErr := errors.New("stop")
+			var item streamItem
+			calls := 0
+			err := service.Stream(context.Background(), "select id, name from t", nil, &item, func() error {
+				calls++
+				return stopErr
+			})
+
+			Expect(err).Should(MatchError(stopErr))
+			Expect(calls).Should(Equal(1))
+		})
+	})
+
+	Describe("Select", func() {
+		It("scans nullable relation and embedded fields", func() {
+			mockPool := pgxpoolmock.NewMockPgxPool(mockCtrl)
+			now := time.Now()
+			cityId := int64(7)
+			rows := &benchRows{values: [][]any{
+				{int64(1), "first", int64(5), "100", &cityId, int64(7), "Moscow", now, now},
+				{int64(2), "second", int64(3), "50", &cityId, nil, nil, now, now},
+			}}
+			mockPool.EXPECT().Query(gomock.Any(), "select", gomock.Any()).Return(rows, nil)
+			service := database.NewDBService(mockPool)
+
+			var hotels []benchHotel
+			err := service.Select(context.Background(), "select", nil, &hotels, "c")
+
+			Expect(err).Should(Succeed())
+			Expect(hotels).Should(HaveLen(2))
+			Expect(hotels[0].City).Should(Equal(benchCity{Id: 7, Name: "Moscow"}))
+			Expect(hotels[0].Price.String()).Should(Equal("100"))
+			Expect(hotels[0].Timestamps.CreatedAt).Should(Equal(now))
+			Expect(hotels[1].Name).Should(Equal("second"))
+			Expect(hotels[1].City).Should(Equal(benchCity{}))
+		})
+
+		It("keeps nil pointers for unmatched nested nullable relations", func() {
+			mockPool := pgxpoolmock.NewMockPgxPool(mockCtrl)
+			countryId := int64(3)
+			rows := &benchRows{values: [][]any{
+				{int64(1), int64(7), "Moscow", &countryId, int64(3), "Russia"},
+				{int64(2), int64(8), "Nowhere", nil, nil, nil},
+				{int64(3), nil, nil, nil, nil, nil},
+			}}
+			mockPool.EXPECT().Query(gomock.Any(), "select", gomock.Any()).Return(rows, nil)
+			service := database.NewDBService(mockPool)
+
+			var hotels []relationHotel
+			err := service.Select(context.Background(), "select", nil, &hotels, "c", "co")
+
+			Expect(err).Should(Succeed())
+			Expect(hotels).Should(HaveLen(3))
+			Expect(hotels[0].City).ShouldNot(BeNil())
+			Expect(hotels[0].City.Name).Should(Equal("Moscow"))
+			Expect(hotels[0].City.Country).Should(Equal(&relationCountry{Id: 3, Name: "Russia"}))
+			Expect(hotels[1].City).ShouldNot(BeNil())
+			Expect(hotels[1].City.Country).Should(BeNil())
+			Expect(hotels[2].City).Should(BeNil())
+		})
+	})
+
+	Describe("CopyFrom", func() {
+		It("returns error when pool does not support copy", func() {
+			mockPool := pgxpoolmock.NewMockPgxPool(mockCtrl)
+			service := database.NewDBService(mockPool)
+
+			_, err := service.CopyFrom(context.Background(), "public.t", []string{"id"}, [][]any{{int64(1)}})
+
+			Expect(err).Should(MatchError(database.ErrCopyNotSupported))
+		})
+	})
+
 	Describe("Begin", func() {
 		It("Begin", func() {
 			mockPool := pgxpoolmock.NewMockPgxPool(mockCtrl)
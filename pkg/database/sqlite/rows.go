@@ -0,0 +1,148 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgproto3/v2"
+	"github.com/jackc/pgx/v4"
+)
+
+// rows результат database/sql с интерфейсом pgx.Rows для сканирования database.DBService
+type rows struct {
+	rows    *sql.Rows
+	fields  []pgproto3.FieldDescription
+	err     error
+	scanned int64
+}
+
+func newRows(sqlRows *sql.Rows) (pgx.Rows, error) {
+	columns, err := sqlRows.Columns()
+	if err != nil {
+		_ = sqlRows.Close()
+		return nil, err
+	}
+
+	fields := make([]pgproto3.FieldDescription, len(columns))
+	for i, column := range columns {
+		fields[i] = pgproto3.FieldDescription{Name: []byte(column)}
+	}
+
+	return &rows{rows: sqlRows, fields: fields}, nil
+}
+
+func (r *rows) Close() {
+	_ = r.rows.Close()
+}
+
+func (r *rows) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+
+	return r.rows.Err()
+}
+
+func (r *rows) CommandTag() pgconn.CommandTag {
+	return commandTag(r.scanned)
+}
+
+func (r *rows) FieldDescriptions() []pgproto3.FieldDescription {
+	return r.fields
+}
+
+func (r *rows) Next() bool {
+	if r.err != nil {
+		return false
+	}
+
+	return r.rows.Next()
+}
+
+func (r *rows) Scan(dest ...any) error {
+	if err := r.rows.Scan(timeDest(dest)...); err != nil {
+		r.err = err
+		_ = r.rows.Close()
+		return err
+	}
+	r.scanned++
+
+	return nil
+}
+
+func (r *rows) Values() ([]any, error) {
+	values := make([]any, len(r.fields))
+	pointers := make([]any, len(r.fields))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+
+	if err := r.Scan(pointers...); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// RawValues бинарного представления postgres у SQLite нет
+func (r *rows) RawValues() [][]byte {
+	return nil
+}
+
+// row первая строка результата, sql.ErrNoRows заменяется на pgx.ErrNoRows, как ее проверяют репозитории
+type row struct {
+	row *sql.Row
+	err error
+}
+
+func (r row) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+
+	err := r.row.Scan(timeDest(dest)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return pgx.ErrNoRows
+	}
+
+	return err
+}
+
+// timeDest заменяет *time.Time в dest на timeValue: время из колонок DATETIME драйвер отдает как time.Time,
+// а результат выражения без объявленного типа, например SELECT now(), - строкой, которую database/sql не сканирует во время
+func timeDest(dest []any) []any {
+	res := make([]any, len(dest))
+	for i, d := range dest {
+		if t, ok := d.(*time.Time); ok {
+			d = timeValue{dest: t}
+		}
+		res[i] = d
+	}
+
+	return res
+}
+
+// timeValue сканирует время из time.Time или строки в формате now()
+type timeValue struct {
+	dest *time.Time
+}
+
+func (v timeValue) Scan(src any) error {
+	switch value := src.(type) {
+	case time.Time:
+		*v.dest = value
+	case string:
+		parsed, err := time.Parse(timeFormat, value)
+		if err != nil {
+			return err
+		}
+		*v.dest = parsed
+	default:
+		return fmt.Errorf("unsupported Scan, storing driver.Value type %T into type *time.Time", src)
+	}
+
+	return nil
+}
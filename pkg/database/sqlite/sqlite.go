@@ -0,0 +1,243 @@
+// Package sqlite сервис БД поверх database/sql и встроенного SQLite (modernc.org/sqlite, без cgo)
+// для герметичных интеграционных тестов репозиториев без postgres
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/dialect/sqlite3"
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	sqlitedriver "modernc.org/sqlite"
+
+	"github.com/EveryHotel/core-tools/pkg/database"
+)
+
+// DriverName имя драйвера database/sql
+const DriverName = "sqlite"
+
+// Dialect имя диалекта goqu: sqlite3 с RETURNING и ON CONFLICT ... WHERE, которые есть в SQLite с 3.35
+const Dialect = "sqlite"
+
+// timeFormat формат, в котором now() возвращает время, его драйвер читает в колонки DATETIME и TIMESTAMP
+const timeFormat = "2006-01-02 15:04:05.999999999-07:00"
+
+var ErrNotSupported = errors.New("not supported by sqlite")
+
+func init() {
+	opts := sqlite3.DialectOptions()
+	opts.SupportsReturn = true
+	opts.SupportsConflictUpdateWhere = true
+	// блокировок строк в SQLite нет: sqlite3 убирает только FOR UPDATE, остальные варианты убираем сами
+	opts.ForNoKeyUpdateFragment = []byte("")
+	opts.ForShareFragment = []byte("")
+	opts.ForKeyShareFragment = []byte("")
+	opts.SkipLockedFragment = []byte("")
+	// время в запросах в том же формате, что и now(), иначе строки времени в SQLite сравниваются неверно
+	opts.TimeFormat = timeFormat
+	goqu.RegisterDialect(Dialect, opts)
+
+	// now() используется репозиториями для created_at и updated_at
+	sqlitedriver.MustRegisterScalarFunction("now", 0, func(*sqlitedriver.FunctionContext, []driver.Value) (driver.Value, error) {
+		return time.Now().UTC().Format(timeFormat), nil
+	})
+}
+
+// Open открывает базу SQLite, например "file::memory:" или путь к файлу
+// Соединение одно: in-memory база существует только в своем соединении, а запись в SQLite все равно последовательная
+func Open(dsn string) (*sql.DB, error) {
+	db, err := sql.Open(DriverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	if err = db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// NewDBService возвращает database.DBService поверх db
+// Сканирование в структуры (relation, embedded_struct, nullable связи), транзакции в контексте CtxDbTxKey
+// и вложенные транзакции через SAVEPOINT работают так же, как для postgres. Колонки времени нужно объявлять
+// как DATETIME или TIMESTAMP, иначе драйвер возвращает их строкой. LISTEN/NOTIFY, advisory блокировки
+// и блокировки строк FOR UPDATE в SQLite недоступны, репозитории выполняют такие выборки без блокировки
+func NewDBService(db *sql.DB, opts ...database.DBServiceOption) database.DBService {
+	return database.NewDBService(NewPool(db), append([]database.DBServiceOption{database.WithDialect(Dialect)}, opts...)...)
+}
+
+// NewPool оборачивает db в пул с интерфейсом pgxpool, ему удовлетворяет и database.PgxCopyPool
+func NewPool(db *sql.DB) pgxpoolmock.PgxPool {
+	return &pool{db: db}
+}
+
+type pool struct {
+	db *sql.DB
+}
+
+func (p *pool) Close() {
+	_ = p.db.Close()
+}
+
+func (p *pool) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return execContext(ctx, p.db, sql, args)
+}
+
+func (p *pool) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return queryContext(ctx, p.db, sql, args)
+}
+
+func (p *pool) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return queryRowContext(ctx, p.db, sql, args)
+}
+
+func (p *pool) QueryFunc(context.Context, string, []any, []any, func(pgx.QueryFuncRow) error) (pgconn.CommandTag, error) {
+	return nil, fmt.Errorf("%w: QueryFunc", ErrNotSupported)
+}
+
+func (p *pool) SendBatch(context.Context, *pgx.Batch) pgx.BatchResults {
+	return batchResults{}
+}
+
+func (p *pool) Begin(ctx context.Context) (pgx.Tx, error) {
+	return p.BeginTx(ctx, pgx.TxOptions{})
+}
+
+// BeginTx начинает транзакцию, уровень изоляции в SQLite всегда serializable, поэтому он игнорируется
+func (p *pool) BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error) {
+	sqlTx, err := p.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: txOptions.AccessMode == pgx.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+
+	return &tx{tx: sqlTx}, nil
+}
+
+func (p *pool) BeginFunc(ctx context.Context, f func(pgx.Tx) error) error {
+	return p.BeginTxFunc(ctx, pgx.TxOptions{}, f)
+}
+
+func (p *pool) BeginTxFunc(ctx context.Context, txOptions pgx.TxOptions, f func(pgx.Tx) error) error {
+	t, err := p.BeginTx(ctx, txOptions)
+	if err != nil {
+		return err
+	}
+
+	return runTxFunc(ctx, t, f)
+}
+
+// CopyFrom вставляет строки построчно в одной транзакции, COPY в SQLite нет
+func (p *pool) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	t, err := p.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := t.CopyFrom(ctx, tableName, columnNames, rowSrc)
+	if err != nil {
+		_ = t.Rollback(ctx)
+		return 0, err
+	}
+
+	return count, t.Commit(ctx)
+}
+
+// querier общие методы *sql.DB и *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func execContext(ctx context.Context, q querier, query string, args []any) (pgconn.CommandTag, error) {
+	res, err := q.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	return commandTag(affected), nil
+}
+
+func queryContext(ctx context.Context, q querier, query string, args []any) (pgx.Rows, error) {
+	sqlRows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return newRows(sqlRows)
+}
+
+func queryRowContext(ctx context.Context, q querier, query string, args []any) pgx.Row {
+	return row{row: q.QueryRowContext(ctx, query, args...)}
+}
+
+// copyFrom вставляет строки из rowSrc подготовленным INSERT
+func copyFrom(ctx context.Context, q querier, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	columns := make([]string, len(columnNames))
+	for i, column := range columnNames {
+		columns[i] = pgx.Identifier{column}.Sanitize()
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		tableName.Sanitize(),
+		strings.Join(columns, ", "),
+		strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", "),
+	)
+
+	var count int64
+	for rowSrc.Next() {
+		values, err := rowSrc.Values()
+		if err != nil {
+			return count, err
+		}
+
+		if _, err = q.ExecContext(ctx, query, values...); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, rowSrc.Err()
+}
+
+// commandTag формирует тег, из которого pgconn.CommandTag.RowsAffected читает количество строк
+func commandTag(affected int64) pgconn.CommandTag {
+	return pgconn.CommandTag(fmt.Sprintf("SQLITE %d", affected))
+}
+
+type batchResults struct{}
+
+func (batchResults) Exec() (pgconn.CommandTag, error) {
+	return nil, fmt.Errorf("%w: batch", ErrNotSupported)
+}
+
+func (batchResults) Query() (pgx.Rows, error) {
+	return nil, fmt.Errorf("%w: batch", ErrNotSupported)
+}
+
+func (batchResults) QueryRow() pgx.Row {
+	return row{err: fmt.Errorf("%w: batch", ErrNotSupported)}
+}
+
+func (batchResults) QueryFunc([]any, func(pgx.QueryFuncRow) error) (pgconn.CommandTag, error) {
+	return nil, fmt.Errorf("%w: batch", ErrNotSupported)
+}
+
+func (batchResults) Close() error {
+	return nil
+}
@@ -0,0 +1,279 @@
+package sqlite_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/jackc/pgx/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/EveryHotel/core-tools/pkg/database"
+	"github.com/EveryHotel/core-tools/pkg/database/sqlite"
+	"github.com/EveryHotel/core-tools/pkg/repo"
+)
+
+type city struct {
+	Id   int64  `db:"id" primary:"1"`
+	Name string `db:"name"`
+}
+
+type hotel struct {
+	Id        int64     `db:"id" primary:"1"`
+	Name      string    `db:"name"`
+	CityId    *int64    `db:"city_id"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+	City      *city     `relation:"c,nullable"`
+}
+
+type roomPrice struct {
+	Amount   int64  `db:"price_amount"`
+	Currency string `db:"price_currency"`
+}
+
+type roomInfo struct {
+	Title string `db:"title"`
+	Beds  int64  `db:"beds"`
+}
+
+type room struct {
+	Id      int64     `db:"id" primary:"1"`
+	HotelId int64     `db:"hotel_id"`
+	Price   roomPrice `embedded_struct:"1"`
+	Info    roomInfo  `inner_struct:"info"`
+}
+
+const schema = `
+CREATE TABLE cities (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL);
+CREATE TABLE hotels (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL UNIQUE,
+	city_id INTEGER REFERENCES cities (id),
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+CREATE TABLE rooms (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	hotel_id INTEGER NOT NULL REFERENCES hotels (id),
+	price_amount INTEGER NOT NULL,
+	price_currency TEXT NOT NULL,
+	title TEXT NOT NULL,
+	beds INTEGER NOT NULL
+);`
+
+var _ = Describe("Sqlite", func() {
+	var (
+		ctx context.Context
+		db  *sql.DB
+		svc database.DBService
+	)
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		db, err = sqlite.Open("file::memory:")
+		Expect(err).ShouldNot(HaveOccurred())
+		_, err = db.Exec(schema)
+		Expect(err).ShouldNot(HaveOccurred())
+		svc = sqlite.NewDBService(db)
+	})
+
+	AfterEach(func() {
+		Expect(db.Close()).Should(Succeed())
+	})
+
+	insertCity := func(ctx context.Context, name string) int64 {
+		var id int64
+		sql, args, err := svc.Dialect().Insert("cities").Rows(goqu.Record{"name": name}).Returning("id").ToSQL()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(svc.Insert(ctx, sql, args, &id)).Should(Succeed())
+		return id
+	}
+
+	countCities := func() int64 {
+		count, err := svc.Count(ctx, `SELECT COUNT(*) FROM cities`, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		return count
+	}
+
+	It("uses sqlite dialect", func() {
+		sql, _, err := svc.Dialect().From("cities").Where(goqu.Ex{"id": 1}).ToSQL()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(sql).Should(Equal("SELECT * FROM `cities` WHERE (`id` = 1)"))
+	})
+
+	It("drops row locks", func() {
+		sql, _, err := svc.Dialect().From("cities").ForShare(exp.SkipLocked).ToSQL()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(sql).Should(Equal("SELECT * FROM `cities`"))
+		Expect(database.SupportsRowLocks(svc)).Should(BeFalse())
+		Expect(database.SupportsRowLocks(database.NewDBService(nil))).Should(BeTrue())
+	})
+
+	It("scans rows and affected count", func() {
+		insertCity(ctx, "Moscow")
+		insertCity(ctx, "Kazan")
+
+		var cities []city
+		Expect(svc.Select(ctx, `SELECT id, name FROM cities ORDER BY id`, nil, &cities)).Should(Succeed())
+		Expect(cities).Should(Equal([]city{{Id: 1, Name: "Moscow"}, {Id: 2, Name: "Kazan"}}))
+
+		affected, err := svc.ExecAffected(ctx, `UPDATE cities SET name = upper(name)`, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(affected).Should(Equal(int64(2)))
+
+		var one city
+		err = svc.SelectOne(ctx, `SELECT id, name FROM cities WHERE id = ?`, []any{10}, &one)
+		Expect(err).Should(MatchError(pgx.ErrNoRows))
+	})
+
+	It("copies rows", func() {
+		copied, err := svc.CopyFrom(ctx, "cities", []string{"name"}, [][]any{{"Moscow"}, {"Kazan"}, {"Sochi"}})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(copied).Should(Equal(int64(3)))
+		Expect(countCities()).Should(Equal(int64(3)))
+	})
+
+	Describe("RunInTx", func() {
+		It("commits transaction", func() {
+			Expect(svc.RunInTx(ctx, func(ctx context.Context) error {
+				insertCity(ctx, "Moscow")
+				return nil
+			})).Should(Succeed())
+			Expect(countCities()).Should(Equal(int64(1)))
+		})
+
+		It("rolls back transaction", func() {
+			errFailed := errors.New("failed")
+			Expect(svc.RunInTx(ctx, func(ctx context.Context) error {
+				insertCity(ctx, "Moscow")
+				return errFailed
+			})).Should(MatchError(errFailed))
+			Expect(countCities()).Should(Equal(int64(0)))
+		})
+
+		It("rolls back nested transaction to savepoint", func() {
+			errFailed := errors.New("failed")
+			Expect(svc.RunInTx(ctx, func(ctx context.Context) error {
+				insertCity(ctx, "Moscow")
+				Expect(svc.RunInTx(ctx, func(ctx context.Context) error {
+					insertCity(ctx, "Kazan")
+					return errFailed
+				})).Should(MatchError(errFailed))
+				return nil
+			})).Should(Succeed())
+
+			var cities []city
+			Expect(svc.Select(ctx, `SELECT id, name FROM cities`, nil, &cities)).Should(Succeed())
+			Expect(cities).Should(HaveLen(1))
+			Expect(cities[0].Name).Should(Equal("Moscow"))
+		})
+	})
+
+	Describe("BaseRepo", func() {
+		var hotels repo.BaseRepo[hotel, int64]
+
+		BeforeEach(func() {
+			hotels = repo.NewRepository[hotel, int64](svc, "hotels", "h", "id")
+		})
+
+		It("creates, reads and updates entities with nullable relation", func() {
+			cityId := insertCity(ctx, "Moscow")
+
+			id, err := hotels.Create(ctx, hotel{Name: "Grand", CityId: &cityId})
+			Expect(err).ShouldNot(HaveOccurred())
+			_, err = hotels.Create(ctx, hotel{Name: "Motel"})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			relation := repo.ListOptionRelation{
+				Alias:       "c",
+				Table:       "cities",
+				Expressions: []goqu.Expression{goqu.I("c.id").Eq(goqu.I("h.city_id"))},
+				Nullable:    true,
+			}
+
+			found, err := hotels.Get(ctx, id, relation)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(found.Name).Should(Equal("Grand"))
+			Expect(found.CreatedAt).ShouldNot(BeZero())
+			Expect(found.City).Should(Equal(&city{Id: cityId, Name: "Moscow"}))
+
+			list, err := hotels.ListBy(ctx, nil, repo.WithRelations([]repo.ListOptionRelation{relation}), repo.WithSort([]exp.OrderedExpression{goqu.I("h.id").Asc()}))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(list).Should(HaveLen(2))
+			Expect(list[1].City).Should(BeNil())
+
+			found.Name = "Grand Hotel"
+			Expect(hotels.Update(ctx, found)).Should(Succeed())
+			found, err = hotels.Get(ctx, id)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(found.Name).Should(Equal("Grand Hotel"))
+		})
+
+		It("stores fields of embedded and inner structs", func() {
+			hotelId, err := hotels.Create(ctx, hotel{Name: "Grand"})
+			Expect(err).ShouldNot(HaveOccurred())
+			rooms := repo.NewRepository[room, int64](svc, "rooms", "r", "id")
+
+			id, err := rooms.Create(ctx, room{
+				HotelId: hotelId,
+				Price:   roomPrice{Amount: 5000, Currency: "RUB"},
+				Info:    roomInfo{Title: "Standard", Beds: 2},
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			found, err := rooms.Get(ctx, id)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(found).Should(Equal(room{
+				Id:      id,
+				HotelId: hotelId,
+				Price:   roomPrice{Amount: 5000, Currency: "RUB"},
+				Info:    roomInfo{Title: "Standard", Beds: 2},
+			}))
+
+			found.Price.Amount = 6000
+			found.Info.Beds = 3
+			Expect(rooms.Update(ctx, found)).Should(Succeed())
+
+			list, err := rooms.ListBy(ctx, map[string]any{"r.price_currency": "RUB"})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(list).Should(HaveLen(1))
+			Expect(list[0].Price.Amount).Should(Equal(int64(6000)))
+			Expect(list[0].Info.Beds).Should(Equal(int64(3)))
+		})
+
+		It("runs repository calls in transaction from context", func() {
+			errFailed := errors.New("failed")
+			Expect(svc.RunInTx(ctx, func(ctx context.Context) error {
+				id, err := hotels.Create(ctx, hotel{Name: "Grand"})
+				Expect(err).ShouldNot(HaveOccurred())
+
+				// в транзакции запись видна до коммита
+				found, err := hotels.Get(ctx, id)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(found.Name).Should(Equal("Grand"))
+
+				return errFailed
+			})).Should(MatchError(errFailed))
+
+			list, err := hotels.ListBy(ctx, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(list).Should(BeEmpty())
+
+			Expect(svc.RunInTx(ctx, func(ctx context.Context) error {
+				_, err := hotels.Create(ctx, hotel{Name: "Motel"})
+				return err
+			})).Should(Succeed())
+
+			list, err = hotels.ListBy(ctx, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(list).Should(HaveLen(1))
+			Expect(list[0].Name).Should(Equal("Motel"))
+		})
+	})
+})
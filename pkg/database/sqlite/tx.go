@@ -0,0 +1,125 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// tx транзакция database/sql с интерфейсом pgx.Tx, чтобы ее можно было положить в контекст по CtxDbTxKey
+// Вложенная транзакция (Begin) создается через SAVEPOINT, как в pgx
+type tx struct {
+	tx *sql.Tx
+	// savepoint имя точки сохранения для вложенной транзакции, пусто для транзакции верхнего уровня
+	savepoint string
+	depth     int
+	closed    bool
+}
+
+func (t *tx) Begin(ctx context.Context) (pgx.Tx, error) {
+	if t.closed {
+		return nil, pgx.ErrTxClosed
+	}
+
+	savepoint := fmt.Sprintf("sp_%d", t.depth+1)
+	if _, err := t.tx.ExecContext(ctx, "SAVEPOINT "+savepoint); err != nil {
+		return nil, err
+	}
+
+	return &tx{tx: t.tx, savepoint: savepoint, depth: t.depth + 1}, nil
+}
+
+func (t *tx) BeginFunc(ctx context.Context, f func(pgx.Tx) error) error {
+	nested, err := t.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	return runTxFunc(ctx, nested, f)
+}
+
+func (t *tx) Commit(ctx context.Context) error {
+	if t.closed {
+		return pgx.ErrTxClosed
+	}
+	t.closed = true
+
+	if t.savepoint != "" {
+		_, err := t.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+t.savepoint)
+		return err
+	}
+
+	return t.tx.Commit()
+}
+
+func (t *tx) Rollback(ctx context.Context) error {
+	if t.closed {
+		return pgx.ErrTxClosed
+	}
+	t.closed = true
+
+	if t.savepoint != "" {
+		if _, err := t.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+t.savepoint); err != nil {
+			return err
+		}
+		_, err := t.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+t.savepoint)
+		return err
+	}
+
+	if err := t.tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+		return err
+	}
+
+	return nil
+}
+
+func (t *tx) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	return copyFrom(ctx, t.tx, tableName, columnNames, rowSrc)
+}
+
+func (t *tx) SendBatch(context.Context, *pgx.Batch) pgx.BatchResults {
+	return batchResults{}
+}
+
+func (t *tx) LargeObjects() pgx.LargeObjects {
+	return pgx.LargeObjects{}
+}
+
+func (t *tx) Prepare(context.Context, string, string) (*pgconn.StatementDescription, error) {
+	return nil, fmt.Errorf("%w: Prepare", ErrNotSupported)
+}
+
+func (t *tx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return execContext(ctx, t.tx, sql, args)
+}
+
+func (t *tx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return queryContext(ctx, t.tx, sql, args)
+}
+
+func (t *tx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return queryRowContext(ctx, t.tx, sql, args)
+}
+
+func (t *tx) QueryFunc(context.Context, string, []any, []any, func(pgx.QueryFuncRow) error) (pgconn.CommandTag, error) {
+	return nil, fmt.Errorf("%w: QueryFunc", ErrNotSupported)
+}
+
+// Conn соединения pgx у транзакции database/sql нет
+func (t *tx) Conn() *pgx.Conn {
+	return nil
+}
+
+// runTxFunc выполняет f в транзакции t, коммитит при успехе и откатывает при ошибке
+func runTxFunc(ctx context.Context, t pgx.Tx, f func(pgx.Tx) error) error {
+	if err := f(t); err != nil {
+		_ = t.Rollback(ctx)
+		return err
+	}
+
+	return t.Commit(ctx)
+}
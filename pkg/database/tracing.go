@@ -0,0 +1,136 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/EveryHotel/core-tools/pkg/telemetry"
+)
+
+var (
+	statementLiteralRegexp = regexp.MustCompile(`'(?:[^']|'')*'|\$\d+|\b\d+(?:\.\d+)?\b`)
+	statementListRegexp    = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)+\s*\)`)
+	statementTableRegexp   = regexp.MustCompile(`(?i)\b(?:from|into|update)\s+((?:"[^"]+"|\w+)(?:\.(?:"[^"]+"|\w+))?)`)
+)
+
+type DBServiceOption func(*dbService)
+
+// WithSlowQueryThreshold задает порог, после которого запрос логируется как медленный, 0 отключает логирование
+// Медленные запросы группируются по statement_fingerprint - отпечатку нормализованного текста запроса
+func WithSlowQueryThreshold(threshold time.Duration) DBServiceOption {
+	return func(s *dbService) {
+		s.slowQueryThreshold = threshold
+	}
+}
+
+// SanitizeStatement заменяет литералы запроса на ?, оставляя плейсхолдеры $n, и схлопывает списки IN (?, ?) в (?)
+func SanitizeStatement(sql string) string {
+	sanitized := statementLiteralRegexp.ReplaceAllStringFunc(sql, func(literal string) string {
+		if strings.HasPrefix(literal, "$") {
+			return literal
+		}
+		return "?"
+	})
+	sanitized = statementListRegexp.ReplaceAllString(sanitized, "(?)")
+
+	return strings.Join(strings.Fields(sanitized), " ")
+}
+
+// StatementFingerprint возвращает отпечаток текста запроса после SanitizeStatement, одинаковый для запросов,
+// отличающихся только значениями. План выполнения запроса в отпечатке не учитывается
+func StatementFingerprint(sql string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(SanitizeStatement(sql)))
+
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// StatementTable возвращает первую таблицу из FROM/INTO/UPDATE запроса
+func StatementTable(sql string) string {
+	match := statementTableRegexp.FindStringSubmatch(sql)
+	if len(match) < 2 {
+		return ""
+	}
+
+	return strings.ReplaceAll(match[1], `"`, "")
+}
+
+type queryTrace struct {
+	service   *dbService
+	span      trace.Span
+	start     time.Time
+	operation string
+	sql       string
+}
+
+// startQuery открывает дочерний span для операции с БД
+func (s *dbService) startQuery(ctx context.Context, operation, sql string) (context.Context, *queryTrace) {
+	attrs := []attribute.KeyValue{
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.operation", operation),
+	}
+	if sql != "" {
+		attrs = append(attrs,
+			attribute.String("db.statement", SanitizeStatement(sql)),
+			attribute.String("db.sql.table", StatementTable(sql)),
+		)
+	}
+
+	ctx, span := telemetry.GetTracer().Start(ctx, "db."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+
+	return ctx, &queryTrace{
+		service:   s,
+		span:      span,
+		start:     time.Now(),
+		operation: operation,
+		sql:       sql,
+	}
+}
+
+// end закрывает span, rows < 0 означает, что количество строк неизвестно
+func (t *queryTrace) end(ctx context.Context, err error, rows int64) {
+	duration := time.Since(t.start)
+
+	if rows >= 0 {
+		t.span.SetAttributes(attribute.Int64("db.rows", rows))
+	}
+	if err != nil {
+		t.span.RecordError(err)
+		t.span.SetStatus(codes.Error, err.Error())
+	}
+	t.span.End()
+
+	if t.service.slowQueryThreshold > 0 && duration >= t.service.slowQueryThreshold && t.sql != "" {
+		slog.WarnContext(ctx, "Slow query",
+			slog.String("operation", t.operation),
+			slog.String("table", StatementTable(t.sql)),
+			slog.String("statement_fingerprint", StatementFingerprint(t.sql)),
+			slog.String("statement", SanitizeStatement(t.sql)),
+			slog.Duration("duration", duration),
+			slog.Int64("rows", rows),
+		)
+	}
+}
+
+// sliceLen возвращает длину слайса по указателю dest
+func sliceLen(dest any) int64 {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Slice {
+		return -1
+	}
+
+	return int64(v.Elem().Len())
+}
@@ -0,0 +1,69 @@
+package database_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/EveryHotel/core-tools/pkg/database"
+)
+
+var _ = Describe("Tracing", func() {
+	Describe("SanitizeStatement", func() {
+		It("replaces literals and keeps placeholders", func() {
+			res := database.SanitizeStatement(`SELECT "h"."id" FROM "hotels" AS "h" WHERE ("h"."name" = 'it''s') AND ("h"."id" = $1) LIMIT 10`)
+			Expect(res).Should(Equal(`SELECT "h"."id" FROM "hotels" AS "h" WHERE ("h"."name" = ?) AND ("h"."id" = $1) LIMIT ?`))
+		})
+		It("collapses IN lists", func() {
+			res := database.SanitizeStatement(`SELECT * FROM "t" WHERE ("id" IN (1, 2, 3))`)
+			Expect(res).Should(Equal(`SELECT * FROM "t" WHERE ("id" IN (?))`))
+		})
+	})
+
+	Describe("StatementFingerprint", func() {
+		It("is equal for statements with different values", func() {
+			first := database.StatementFingerprint(`SELECT * FROM "t" WHERE ("id" IN (1, 2))`)
+			second := database.StatementFingerprint(`SELECT *  FROM "t" WHERE ("id" IN (5, 6, 7))`)
+			Expect(first).Should(Equal(second))
+		})
+	})
+
+	Describe("StatementTable", func() {
+		It("returns table with schema", func() {
+			Expect(database.StatementTable(`UPDATE "public"."hotels" SET "name"='a'`)).Should(Equal("public.hotels"))
+		})
+		It("returns table from insert", func() {
+			Expect(database.StatementTable(`INSERT INTO "rooms" ("id") VALUES (1)`)).Should(Equal("rooms"))
+		})
+	})
+
+	Describe("slow query log", func() {
+		It("logs statement fingerprint of slow query", func() {
+			var buf bytes.Buffer
+			defaultLogger := slog.Default()
+			slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+			DeferCleanup(slog.SetDefault, defaultLogger)
+
+			mockPool := pgxpoolmock.NewMockPgxPool(gomock.NewController(GinkgoT()))
+			mockPool.EXPECT().Exec(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+				func(context.Context, string, ...any) (pgconn.CommandTag, error) {
+					time.Sleep(2 * time.Millisecond)
+					return pgconn.CommandTag("UPDATE 1"), nil
+				})
+			service := database.NewDBService(mockPool, database.WithSlowQueryThreshold(time.Millisecond))
+
+			sql := `UPDATE "hotels" SET "name"='a' WHERE ("id" = 1)`
+			Expect(service.Exec(context.Background(), sql, nil)).Should(Succeed())
+
+			Expect(buf.String()).Should(ContainSubstring("Slow query"))
+			Expect(buf.String()).Should(ContainSubstring("statement_fingerprint=" + database.StatementFingerprint(sql)))
+		})
+	})
+})
@@ -0,0 +1,153 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/EveryHotel/core-tools/pkg/database"
+)
+
+// fakeTx транзакция, которая только считает вызовы
+type fakeTx struct {
+	pgx.Tx
+	commitErr error
+	commits   int
+	rollbacks int
+	nested    *fakeTx
+	queries   []string
+	row       pgx.Row
+}
+
+func (t *fakeTx) Begin(context.Context) (pgx.Tx, error) {
+	t.nested = &fakeTx{}
+	return t.nested, nil
+}
+
+func (t *fakeTx) Commit(context.Context) error {
+	t.commits++
+	return t.commitErr
+}
+
+func (t *fakeTx) Rollback(context.Context) error {
+	t.rollbacks++
+	return nil
+}
+
+func (t *fakeTx) Exec(_ context.Context, sql string, _ ...any) (pgconn.CommandTag, error) {
+	t.queries = append(t.queries, sql)
+	return pgconn.CommandTag("SELECT 1"), nil
+}
+
+func (t *fakeTx) QueryRow(_ context.Context, sql string, _ ...any) pgx.Row {
+	t.queries = append(t.queries, sql)
+	return t.row
+}
+
+var _ = Describe("Transactions", func() {
+	var mockCtrl *gomock.Controller
+	var mockPool *pgxpoolmock.MockPgxPool
+	var service database.DBService
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockPool = pgxpoolmock.NewMockPgxPool(mockCtrl)
+		service = database.NewDBService(mockPool)
+	})
+
+	Describe("Begin", func() {
+		It("creates savepoint when context already has tx", func() {
+			tx := &fakeTx{}
+			mockPool.EXPECT().Begin(gomock.Any()).Return(tx, nil).Times(1)
+
+			ctx, err := service.Begin(context.Background())
+			Expect(err).Should(Succeed())
+
+			nestedCtx, err := service.Begin(ctx)
+			Expect(err).Should(Succeed())
+			Expect(nestedCtx.Value(database.CtxDbTxKey)).Should(BeIdenticalTo(tx.nested))
+
+			Expect(service.Rollback(nestedCtx)).Should(Succeed())
+			Expect(tx.nested.rollbacks).Should(Equal(1))
+			Expect(tx.rollbacks).Should(Equal(0))
+		})
+	})
+
+	Describe("RunInTx", func() {
+		It("commits on success", func() {
+			tx := &fakeTx{}
+			mockPool.EXPECT().Begin(gomock.Any()).Return(tx, nil)
+
+			err := service.RunInTx(context.Background(), func(ctx context.Context) error {
+				return nil
+			})
+
+			Expect(err).Should(Succeed())
+			Expect(tx.commits).Should(Equal(1))
+		})
+
+		It("rolls back on error", func() {
+			tx := &fakeTx{}
+			mockPool.EXPECT().Begin(gomock.Any()).Return(tx, nil)
+			fnErr := errors.New("fn")
+
+			err := service.RunInTx(context.Background(), func(ctx context.Context) error {
+				return fnErr
+			})
+
+			Expect(err).Should(MatchError(fnErr))
+			Expect(tx.rollbacks).Should(Equal(1))
+			Expect(tx.commits).Should(Equal(0))
+		})
+
+		It("rolls back and returns error on panic", func() {
+			tx := &fakeTx{}
+			mockPool.EXPECT().Begin(gomock.Any()).Return(tx, nil)
+
+			err := service.RunInTx(context.Background(), func(ctx context.Context) error {
+				panic("boom")
+			})
+
+			Expect(err).Should(HaveOccurred())
+			Expect(tx.rollbacks).Should(Equal(1))
+		})
+
+		It("retries on serialization failure with isolation level", func() {
+			failedTx := &fakeTx{commitErr: &pgconn.PgError{Code: "40001"}}
+			tx := &fakeTx{}
+			gomock.InOrder(
+				mockPool.EXPECT().BeginTx(gomock.Any(), pgx.TxOptions{IsoLevel: pgx.Serializable}).Return(failedTx, nil),
+				mockPool.EXPECT().BeginTx(gomock.Any(), pgx.TxOptions{IsoLevel: pgx.Serializable}).Return(tx, nil),
+			)
+
+			calls := 0
+			err := service.RunInTx(context.Background(), func(ctx context.Context) error {
+				calls++
+				return nil
+			}, database.WithIsolationLevel(pgx.Serializable), database.WithRetryDelay(0))
+
+			Expect(err).Should(Succeed())
+			Expect(calls).Should(Equal(2))
+			Expect(tx.commits).Should(Equal(1))
+		})
+
+		It("uses savepoint without retries inside tx", func() {
+			tx := &fakeTx{}
+			ctx := context.WithValue(context.Background(), database.CtxDbTxKey, pgx.Tx(tx))
+
+			err := service.RunInTx(ctx, func(ctx context.Context) error {
+				return nil
+			})
+
+			Expect(err).Should(Succeed())
+			Expect(tx.nested.commits).Should(Equal(1))
+			Expect(tx.commits).Should(Equal(0))
+		})
+	})
+})
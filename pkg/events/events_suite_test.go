@@ -0,0 +1,110 @@
+package events_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/EveryHotel/core-tools/pkg/amqp"
+	"github.com/EveryHotel/core-tools/pkg/database"
+	"github.com/EveryHotel/core-tools/pkg/database/sqlite"
+	"github.com/EveryHotel/core-tools/pkg/events"
+)
+
+func TestEvents(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Events Suite")
+}
+
+// outboxSchema events.OutboxSchema в типах SQLite
+const outboxSchema = `
+CREATE TABLE outbox (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	event_name TEXT NOT NULL,
+	aggregate_id TEXT,
+	sequence INTEGER,
+	payload TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	available_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	delivered_at DATETIME,
+	failed_at DATETIME
+);
+CREATE TABLE outbox_aggregates (aggregate_id TEXT PRIMARY KEY, sequence INTEGER NOT NULL);`
+
+// openDB открывает пустую базу SQLite с таблицами outbox
+func openDB() database.DBService {
+	db, err := sqlite.Open("file::memory:")
+	Expect(err).ShouldNot(HaveOccurred())
+	DeferCleanup(db.Close)
+
+	_, err = db.Exec(outboxSchema)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	return sqlite.NewDBService(db)
+}
+
+type hotelTask struct {
+	amqp.BaseTask
+	HotelId string `json:"hotel_id"`
+	Action  string `json:"action"`
+}
+
+func (t *hotelTask) GetAggregateId() string {
+	return t.HotelId
+}
+
+type plainTask struct {
+	amqp.BaseTask
+	Action string `json:"action"`
+}
+
+// publishedEvent событие, переданное в AMQP
+type publishedEvent struct {
+	Name    events.EventName
+	Payload map[string]any
+}
+
+// fakePublisher запоминает опубликованные события и номера попыток, fail возвращает ошибку публикации
+type fakePublisher struct {
+	events.AmqpDispatcher
+	published []publishedEvent
+	attempts  []int64
+	fail      func(payload map[string]any) error
+}
+
+func (p *fakePublisher) Dispatch(_ context.Context, eventName events.EventName, task amqp.Task) error {
+	// как amqp.AmqpService.Publish
+	p.attempts = append(p.attempts, task.IncrAttemptNumber())
+	data, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+
+	var payload map[string]any
+	if err = json.Unmarshal(data, &payload); err != nil {
+		return err
+	}
+
+	if p.fail != nil {
+		if err = p.fail(payload); err != nil {
+			return err
+		}
+	}
+
+	p.published = append(p.published, publishedEvent{Name: eventName, Payload: payload})
+	return nil
+}
+
+// actions возвращает действия опубликованных событий по порядку
+func (p *fakePublisher) actions() []string {
+	res := make([]string, len(p.published))
+	for i, event := range p.published {
+		res[i], _ = event.Payload["action"].(string)
+	}
+	return res
+}
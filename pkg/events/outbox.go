@@ -0,0 +1,498 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/EveryHotel/core-tools/pkg/amqp"
+	"github.com/EveryHotel/core-tools/pkg/database"
+)
+
+// ErrOutboxOutsideTx Dispatch вызван без транзакции в контексте, событие могло бы сохраниться без породивших его изменений
+var ErrOutboxOutsideTx = errors.New("outbox dispatch requires transaction in context")
+
+// OutboxSchema таблицы outbox по умолчанию, их нужно добавить в миграции сервиса
+// В outbox_aggregates хранится последний номер события агрегата: id из bigserial выдаются до коммита,
+// поэтому по ним порядок внутри агрегата не восстановить. Индекс по aggregate_id нужен для проверки этого порядка
+const OutboxSchema = `CREATE TABLE IF NOT EXISTS outbox (
+	id bigserial PRIMARY KEY,
+	event_name text NOT NULL,
+	aggregate_id text,
+	sequence bigint,
+	payload jsonb NOT NULL,
+	attempts integer NOT NULL DEFAULT 0,
+	last_error text,
+	available_at timestamptz NOT NULL DEFAULT now(),
+	created_at timestamptz NOT NULL DEFAULT now(),
+	delivered_at timestamptz,
+	failed_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (id) WHERE delivered_at IS NULL AND failed_at IS NULL;
+CREATE INDEX IF NOT EXISTS outbox_aggregate_idx ON outbox (aggregate_id, sequence) WHERE delivered_at IS NULL AND failed_at IS NULL;
+CREATE TABLE IF NOT EXISTS outbox_aggregates (
+	aggregate_id text PRIMARY KEY,
+	sequence bigint NOT NULL
+);`
+
+// AggregateTask задача, относящаяся к агрегату (например, отелю)
+// События одного агрегата публикуются строго в порядке коммита записавших их транзакций
+type AggregateTask interface {
+	amqp.Task
+	GetAggregateId() string
+}
+
+// OutboxDispatcher записывает задачи в outbox вместо публикации в AMQP
+// Запись идет в транзакции из контекста (CtxDbTxKey), поэтому событие сохраняется только вместе с изменениями,
+// которые его породили, без транзакции возвращается ErrOutboxOutsideTx. Публикацией занимается OutboxRelay
+type OutboxDispatcher interface {
+	Dispatch(ctx context.Context, eventName EventName, task amqp.Task) error
+}
+
+type OutboxOption func(*outboxOptionHandler)
+
+type outboxOptionHandler struct {
+	table         string
+	batchSize     uint
+	pollInterval  time.Duration
+	retryDelay    time.Duration
+	maxRetryDelay time.Duration
+	maxAttempts   int64
+	retention     time.Duration
+}
+
+// WithOutboxTable задает таблицу outbox, по умолчанию outbox
+// Номера событий агрегатов хранятся в таблице с суффиксом _aggregates
+func WithOutboxTable(table string) OutboxOption {
+	return func(h *outboxOptionHandler) {
+		h.table = table
+	}
+}
+
+// WithOutboxBatchSize задает количество записей, публикуемых в одной транзакции, по умолчанию 100
+func WithOutboxBatchSize(size uint) OutboxOption {
+	return func(h *outboxOptionHandler) {
+		h.batchSize = size
+	}
+}
+
+// WithOutboxPollInterval задает интервал опроса outbox, когда новых записей нет, по умолчанию секунда
+func WithOutboxPollInterval(interval time.Duration) OutboxOption {
+	return func(h *outboxOptionHandler) {
+		h.pollInterval = interval
+	}
+}
+
+// WithOutboxRetryDelay задает задержку перед повторной публикацией, она удваивается с каждой попыткой до maxDelay
+func WithOutboxRetryDelay(delay, maxDelay time.Duration) OutboxOption {
+	return func(h *outboxOptionHandler) {
+		h.retryDelay = delay
+		h.maxRetryDelay = maxDelay
+	}
+}
+
+// WithOutboxMaxAttempts задает количество попыток публикации, после которых запись помечается failed_at
+// и больше не задерживает следующие события своего агрегата. 0 - без ограничения, по умолчанию 10
+func WithOutboxMaxAttempts(attempts int64) OutboxOption {
+	return func(h *outboxOptionHandler) {
+		h.maxAttempts = attempts
+	}
+}
+
+// WithOutboxRetention задает, сколько хранятся опубликованные записи до удаления, по умолчанию сутки
+func WithOutboxRetention(retention time.Duration) OutboxOption {
+	return func(h *outboxOptionHandler) {
+		h.retention = retention
+	}
+}
+
+func newOutboxOptionHandler(opts []OutboxOption) *outboxOptionHandler {
+	handler := &outboxOptionHandler{
+		table:         "outbox",
+		batchSize:     100,
+		pollInterval:  time.Second,
+		retryDelay:    time.Second,
+		maxRetryDelay: 10 * time.Minute,
+		maxAttempts:   10,
+		retention:     24 * time.Hour,
+	}
+	for _, opt := range opts {
+		opt(handler)
+	}
+
+	return handler
+}
+
+type outboxDispatcher struct {
+	db    database.DBService
+	table string
+}
+
+// NewOutboxDispatcher возвращает диспетчер, записывающий задачи в outbox
+func NewOutboxDispatcher(db database.DBService, opts ...OutboxOption) OutboxDispatcher {
+	return &outboxDispatcher{
+		db:    db,
+		table: newOutboxOptionHandler(opts).table,
+	}
+}
+
+// aggregatesTable таблица с номерами событий агрегатов для таблицы outbox
+func aggregatesTable(table string) string {
+	return table + "_aggregates"
+}
+
+// Dispatch сохраняет задачу в outbox. Как и amqp.AmqpService.Publish, увеличивает номер попытки задачи перед сериализацией
+// Для AggregateTask берется следующий номер события агрегата: строка агрегата остается заблокированной до конца
+// транзакции, поэтому параллельные транзакции одного агрегата получают номера в порядке коммита
+func (d *outboxDispatcher) Dispatch(ctx context.Context, eventName EventName, task amqp.Task) error {
+	disable, ok := ctx.Value(CtxDisableDispatching).(bool)
+	if ok && disable {
+		return nil
+	}
+
+	if _, ok = ctx.Value(database.CtxDbTxKey).(pgx.Tx); !ok {
+		return ErrOutboxOutsideTx
+	}
+
+	task.IncrAttemptNumber()
+	payload, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("marshal task for outbox: %w", err)
+	}
+
+	record := goqu.Record{
+		"event_name": string(eventName),
+		"payload":    string(payload),
+	}
+	if aggregateTask, ok := task.(AggregateTask); ok {
+		aggregateId := aggregateTask.GetAggregateId()
+		sequence, err := d.nextSequence(ctx, aggregateId)
+		if err != nil {
+			return err
+		}
+
+		record["aggregate_id"] = aggregateId
+		record["sequence"] = sequence
+	}
+
+	sql, args, err := d.db.Dialect().Insert(d.table).Rows(record).ToSQL()
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot build SQL query for outbox dispatch",
+			slog.Any("error", err),
+		)
+		return err
+	}
+
+	if err = d.db.Exec(ctx, sql, args); err != nil {
+		slog.ErrorContext(ctx, "Error during exec outbox dispatch",
+			slog.Any("error", err),
+			slog.String("event", string(eventName)),
+		)
+		return err
+	}
+
+	return nil
+}
+
+// nextSequence увеличивает и возвращает номер события агрегата
+func (d *outboxDispatcher) nextSequence(ctx context.Context, aggregateId string) (int64, error) {
+	table := aggregatesTable(d.table)
+	sql, args, err := d.db.Dialect().Insert(table).
+		Rows(goqu.Record{"aggregate_id": aggregateId, "sequence": 1}).
+		OnConflict(goqu.DoUpdate("aggregate_id", goqu.Record{
+			"sequence": goqu.L("? + 1", goqu.I(table+".sequence")),
+		})).
+		Returning("sequence").
+		ToSQL()
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot build SQL query for outbox sequence",
+			slog.Any("error", err),
+		)
+		return 0, err
+	}
+
+	var sequence int64
+	if err = d.db.Insert(ctx, sql, args, &sequence); err != nil {
+		slog.ErrorContext(ctx, "Error during exec outbox sequence",
+			slog.Any("error", err),
+			slog.String("aggregate_id", aggregateId),
+		)
+		return 0, err
+	}
+
+	return sequence, nil
+}
+
+// OutboxRelay публикует записи outbox в AMQP
+type OutboxRelay interface {
+	// Run публикует записи и удаляет опубликованные, пока не отменен ctx
+	Run(ctx context.Context) error
+	// RelayBatch публикует одну пачку готовых записей и возвращает количество обработанных
+	RelayBatch(ctx context.Context) (int, error)
+	// Cleanup удаляет записи, опубликованные раньше срока хранения
+	Cleanup(ctx context.Context) (int64, error)
+}
+
+type outboxRecord struct {
+	Id          int64   `db:"id"`
+	EventName   string  `db:"event_name"`
+	AggregateId *string `db:"aggregate_id"`
+	Payload     string  `db:"payload"`
+	Attempts    int64   `db:"attempts"`
+}
+
+// outboxTask уже сериализованная задача из outbox
+type outboxTask struct {
+	payload  json.RawMessage
+	attempts int64
+}
+
+// IncrAttemptNumber возвращает номер попытки публикации записи outbox, payload при этом не меняется
+func (t outboxTask) IncrAttemptNumber() int64 {
+	return t.attempts
+}
+
+func (t outboxTask) GetFailedCallback() func() {
+	return nil
+}
+
+func (t outboxTask) MarshalJSON() ([]byte, error) {
+	return t.payload, nil
+}
+
+type outboxRelay struct {
+	db        database.DBService
+	publisher AmqpDispatcher
+	options   *outboxOptionHandler
+}
+
+// NewOutboxRelay возвращает воркер, публикующий записи outbox через publisher
+// Записи выбираются FOR UPDATE SKIP LOCKED, поэтому воркеров можно запускать несколько.
+// Запись агрегата не выбирается, пока не опубликованы записи с меньшим номером события агрегата,
+// так сохраняется порядок событий внутри агрегата. При ошибке публикации запись откладывается с экспоненциальной задержкой
+func NewOutboxRelay(db database.DBService, publisher AmqpDispatcher, opts ...OutboxOption) OutboxRelay {
+	return &outboxRelay{
+		db:        db,
+		publisher: publisher,
+		options:   newOutboxOptionHandler(opts),
+	}
+}
+
+func (r *outboxRelay) Run(ctx context.Context) error {
+	cleanupTicker := time.NewTicker(r.cleanupInterval())
+	defer cleanupTicker.Stop()
+
+	for {
+		relayed, err := r.RelayBatch(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Error during outbox relay",
+				slog.Any("error", err),
+			)
+		}
+
+		// полная пачка - скорее всего есть еще записи, опрашиваем сразу
+		if err == nil && uint(relayed) >= r.options.batchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-cleanupTicker.C:
+			if _, err = r.Cleanup(ctx); err != nil && ctx.Err() == nil {
+				slog.ErrorContext(ctx, "Error during outbox cleanup",
+					slog.Any("error", err),
+				)
+			}
+		case <-time.After(r.options.pollInterval):
+		}
+	}
+}
+
+func (r *outboxRelay) cleanupInterval() time.Duration {
+	if r.options.retention > 0 && r.options.retention < time.Hour {
+		return r.options.retention
+	}
+
+	return time.Hour
+}
+
+func (r *outboxRelay) RelayBatch(ctx context.Context) (int, error) {
+	var relayed int
+	err := r.db.RunInTx(ctx, func(ctx context.Context) error {
+		records, err := r.lockPending(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			if err = r.relay(ctx, record); err != nil {
+				return err
+			}
+		}
+		relayed = len(records)
+
+		return nil
+	})
+
+	return relayed, err
+}
+
+// lockPending блокирует готовые к публикации записи, у агрегатов которых нет более ранних неопубликованных записей
+func (r *outboxRelay) lockPending(ctx context.Context) ([]outboxRecord, error) {
+	earlier := r.db.Dialect().From(goqu.T(r.options.table).As("e")).
+		Select(goqu.L("1")).
+		Where(
+			goqu.I("e.aggregate_id").Eq(goqu.I("o.aggregate_id")),
+			goqu.I("e.sequence").Lt(goqu.I("o.sequence")),
+			goqu.I("e.delivered_at").IsNull(),
+			goqu.I("e.failed_at").IsNull(),
+		)
+
+	sql, args, err := r.db.Dialect().From(goqu.T(r.options.table).As("o")).
+		Select("o.id", "o.event_name", "o.aggregate_id", "o.payload", "o.attempts").
+		Where(
+			goqu.I("o.delivered_at").IsNull(),
+			goqu.I("o.failed_at").IsNull(),
+			goqu.I("o.available_at").Lte(goqu.L("now()")),
+			goqu.L("NOT EXISTS ?", earlier),
+		).
+		Order(goqu.I("o.id").Asc()).
+		Limit(r.options.batchSize).
+		ForUpdate(goqu.SkipLocked).
+		ToSQL()
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot build SQL query for outbox pending",
+			slog.Any("error", err),
+		)
+		return nil, err
+	}
+
+	var records []outboxRecord
+	if err = r.db.Select(ctx, sql, args, &records); err != nil {
+		slog.ErrorContext(ctx, "Error during exec outbox pending",
+			slog.Any("error", err),
+		)
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// relay публикует запись и помечает ее опубликованной, при ошибке откладывает следующую попытку
+func (r *outboxRelay) relay(ctx context.Context, record outboxRecord) error {
+	var update goqu.Record
+	attempts := record.Attempts + 1
+	task := outboxTask{payload: json.RawMessage(record.Payload), attempts: attempts}
+	if publishErr := r.publisher.Dispatch(ctx, EventName(record.EventName), task); publishErr != nil {
+		slog.WarnContext(ctx, "Cannot publish outbox record",
+			slog.Any("error", publishErr),
+			slog.Int64("id", record.Id),
+			slog.String("event", record.EventName),
+			slog.Int64("attempts", attempts),
+		)
+
+		// available_at сравнивается с now() базы, поэтому задержка отсчитывается от ее часов, а не от часов сервиса
+		now, err := r.dbNow(ctx)
+		if err != nil {
+			return err
+		}
+
+		update = goqu.Record{
+			"attempts":     attempts,
+			"last_error":   publishErr.Error(),
+			"available_at": now.Add(r.retryDelay(attempts)),
+		}
+		if r.options.maxAttempts > 0 && attempts >= r.options.maxAttempts {
+			slog.ErrorContext(ctx, "Outbox record failed after max attempts",
+				slog.Int64("id", record.Id),
+				slog.String("event", record.EventName),
+			)
+			update["failed_at"] = goqu.L("now()")
+		}
+	} else {
+		update = goqu.Record{
+			"delivered_at": goqu.L("now()"),
+		}
+	}
+
+	sql, args, err := r.db.Dialect().Update(r.options.table).
+		Set(update).
+		Where(goqu.C("id").Eq(record.Id)).
+		ToSQL()
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot build SQL query for outbox relay",
+			slog.Any("error", err),
+		)
+		return err
+	}
+
+	if err = r.db.Exec(ctx, sql, args); err != nil {
+		slog.ErrorContext(ctx, "Error during exec outbox relay",
+			slog.Any("error", err),
+		)
+		return err
+	}
+
+	return nil
+}
+
+func (r *outboxRelay) retryDelay(attempts int64) time.Duration {
+	delay := r.options.retryDelay
+	for i := int64(1); i < attempts && delay < r.options.maxRetryDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, r.options.maxRetryDelay)
+}
+
+// dbNow возвращает текущее время базы, по нему же выставляются delivered_at и failed_at
+func (r *outboxRelay) dbNow(ctx context.Context) (time.Time, error) {
+	var res struct {
+		Now time.Time `db:"now"`
+	}
+	if err := r.db.SelectOne(ctx, "SELECT now() AS now", nil, &res); err != nil {
+		slog.ErrorContext(ctx, "Error during exec outbox now",
+			slog.Any("error", err),
+		)
+		return time.Time{}, err
+	}
+
+	return res.Now, nil
+}
+
+func (r *outboxRelay) Cleanup(ctx context.Context) (int64, error) {
+	now, err := r.dbNow(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	sql, args, err := r.db.Dialect().Delete(r.options.table).
+		Where(goqu.I("delivered_at").Lt(now.Add(-r.options.retention))).
+		ToSQL()
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot build SQL query for outbox cleanup",
+			slog.Any("error", err),
+		)
+		return 0, err
+	}
+
+	deleted, err := r.db.ExecAffected(ctx, sql, args)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error during exec outbox cleanup",
+			slog.Any("error", err),
+		)
+		return 0, err
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,269 @@
+package events_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgx/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/EveryHotel/core-tools/pkg/database"
+	"github.com/EveryHotel/core-tools/pkg/events"
+)
+
+// outboxRow запись outbox для проверок
+type outboxRow struct {
+	Id          int64      `db:"id"`
+	AggregateId *string    `db:"aggregate_id"`
+	Sequence    *int64     `db:"sequence"`
+	Payload     string     `db:"payload"`
+	Attempts    int64      `db:"attempts"`
+	LastError   *string    `db:"last_error"`
+	AvailableAt time.Time  `db:"available_at"`
+	DeliveredAt *time.Time `db:"delivered_at"`
+	FailedAt    *time.Time `db:"failed_at"`
+}
+
+// queryTx транзакция postgres, которая запоминает запросы и возвращает пустые выборки
+type queryTx struct {
+	pgx.Tx
+	queries []string
+}
+
+func (t *queryTx) Query(_ context.Context, sql string, _ ...any) (pgx.Rows, error) {
+	t.queries = append(t.queries, sql)
+	return pgxpoolmock.NewRows([]string{"id"}).ToPgxRows(), nil
+}
+
+func (t *queryTx) Commit(context.Context) error {
+	return nil
+}
+
+func (t *queryTx) Rollback(context.Context) error {
+	return nil
+}
+
+var _ = Describe("Outbox", func() {
+	var (
+		ctx        context.Context
+		db         database.DBService
+		dispatcher events.OutboxDispatcher
+		publisher  *fakePublisher
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		db = openDB()
+		dispatcher = events.NewOutboxDispatcher(db)
+		publisher = &fakePublisher{}
+	})
+
+	dispatch := func(tasks ...any) {
+		err := db.RunInTx(ctx, func(ctx context.Context) error {
+			for _, task := range tasks {
+				switch t := task.(type) {
+				case *hotelTask:
+					if err := dispatcher.Dispatch(ctx, "hotel_changed", t); err != nil {
+						return err
+					}
+				case *plainTask:
+					if err := dispatcher.Dispatch(ctx, "plain", t); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+	}
+
+	rows := func() []outboxRow {
+		var res []outboxRow
+		Expect(db.Select(ctx, `SELECT id, aggregate_id, sequence, payload, attempts, last_error, available_at, delivered_at, failed_at FROM outbox ORDER BY id`, nil, &res)).Should(Succeed())
+		return res
+	}
+
+	relay := func(relay events.OutboxRelay) int {
+		relayed, err := relay.RelayBatch(ctx)
+		Expect(err).ShouldNot(HaveOccurred())
+		return relayed
+	}
+
+	Describe("Dispatch", func() {
+		It("requires transaction in context", func() {
+			err := dispatcher.Dispatch(ctx, "hotel_changed", &hotelTask{HotelId: "1"})
+
+			Expect(err).Should(MatchError(events.ErrOutboxOutsideTx))
+			Expect(rows()).Should(BeEmpty())
+		})
+
+		It("skips disabled dispatching", func() {
+			disabled := context.WithValue(ctx, events.CtxDisableDispatching, true)
+
+			Expect(dispatcher.Dispatch(disabled, "hotel_changed", &hotelTask{HotelId: "1"})).Should(Succeed())
+		})
+
+		It("stores task with attempt number and aggregate sequence", func() {
+			dispatch(&hotelTask{HotelId: "1", Action: "create"}, &plainTask{Action: "ping"})
+			dispatch(&hotelTask{HotelId: "1", Action: "update"}, &hotelTask{HotelId: "2", Action: "create"})
+
+			stored := rows()
+			Expect(stored).Should(HaveLen(4))
+			Expect(stored[0].Payload).Should(ContainSubstring(`"attempt_number":1`))
+
+			sequences := make([]any, len(stored))
+			for i, row := range stored {
+				if row.Sequence != nil {
+					sequences[i] = *row.Sequence
+				}
+			}
+			Expect(sequences).Should(Equal([]any{int64(1), nil, int64(2), int64(1)}))
+		})
+
+		It("discards records with rolled back transaction", func() {
+			err := db.RunInTx(ctx, func(ctx context.Context) error {
+				Expect(dispatcher.Dispatch(ctx, "hotel_changed", &hotelTask{HotelId: "1"})).Should(Succeed())
+				return errors.New("failed")
+			})
+
+			Expect(err).Should(HaveOccurred())
+			Expect(rows()).Should(BeEmpty())
+
+			dispatch(&hotelTask{HotelId: "1"})
+			Expect(*rows()[0].Sequence).Should(Equal(int64(1)))
+		})
+	})
+
+	Describe("Relay", func() {
+		It("publishes records and marks them delivered", func() {
+			dispatch(&hotelTask{HotelId: "1", Action: "create"}, &plainTask{Action: "ping"})
+
+			Expect(relay(events.NewOutboxRelay(db, publisher))).Should(Equal(2))
+
+			Expect(publisher.actions()).Should(Equal([]string{"create", "ping"}))
+			Expect(publisher.published[0].Name).Should(Equal(events.EventName("hotel_changed")))
+			Expect(publisher.published[0].Payload["attempt_number"]).Should(BeEquivalentTo(1))
+			for _, row := range rows() {
+				Expect(row.DeliveredAt).ShouldNot(BeNil())
+			}
+
+			Expect(relay(events.NewOutboxRelay(db, publisher))).Should(BeZero())
+		})
+
+		It("publishes next aggregate record only after previous one", func() {
+			dispatch(&hotelTask{HotelId: "1", Action: "create 1"}, &hotelTask{HotelId: "1", Action: "update 1"}, &hotelTask{HotelId: "2", Action: "create 2"})
+			outboxRelay := events.NewOutboxRelay(db, publisher)
+
+			Expect(relay(outboxRelay)).Should(Equal(2))
+			Expect(publisher.actions()).Should(Equal([]string{"create 1", "create 2"}))
+
+			Expect(relay(outboxRelay)).Should(Equal(1))
+			Expect(publisher.actions()).Should(Equal([]string{"create 1", "create 2", "update 1"}))
+		})
+
+		It("orders aggregate records by sequence instead of id", func() {
+			// транзакция со вторым событием агрегата получила id раньше, но закоммитилась позже
+			Expect(db.Exec(ctx, `INSERT INTO outbox (event_name, aggregate_id, sequence, payload) VALUES
+				('hotel_changed', '1', 2, '{"action":"second"}'),
+				('hotel_changed', '1', 1, '{"action":"first"}')`, nil)).Should(Succeed())
+			outboxRelay := events.NewOutboxRelay(db, publisher)
+
+			Expect(relay(outboxRelay)).Should(Equal(1))
+			Expect(relay(outboxRelay)).Should(Equal(1))
+			Expect(publisher.actions()).Should(Equal([]string{"first", "second"}))
+		})
+
+		It("postpones failed record with growing delay", func() {
+			dispatch(&hotelTask{HotelId: "1", Action: "create"}, &hotelTask{HotelId: "1", Action: "update"})
+			publisher.fail = func(map[string]any) error {
+				return errors.New("broker unavailable")
+			}
+			outboxRelay := events.NewOutboxRelay(db, publisher, events.WithOutboxRetryDelay(time.Hour, 3*time.Hour))
+
+			Expect(relay(outboxRelay)).Should(Equal(1))
+
+			failed := rows()[0]
+			Expect(failed.Attempts).Should(Equal(int64(1)))
+			Expect(*failed.LastError).Should(Equal("broker unavailable"))
+			Expect(failed.AvailableAt).Should(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+			Expect(failed.FailedAt).Should(BeNil())
+
+			// запись отложена, а следующая запись агрегата ждет ее
+			Expect(relay(outboxRelay)).Should(BeZero())
+
+			Expect(db.Exec(ctx, `UPDATE outbox SET attempts = 2, available_at = '2000-01-01 00:00:00' WHERE id = ?`, []any{failed.Id})).Should(Succeed())
+			Expect(relay(outboxRelay)).Should(Equal(1))
+			Expect(rows()[0].AvailableAt).Should(BeTemporally("~", time.Now().Add(3*time.Hour), time.Minute))
+		})
+
+		It("passes publish attempt number to publisher", func() {
+			dispatch(&plainTask{Action: "ping"})
+			publisher.fail = func(map[string]any) error {
+				return errors.New("broker unavailable")
+			}
+			outboxRelay := events.NewOutboxRelay(db, publisher)
+
+			Expect(relay(outboxRelay)).Should(Equal(1))
+			Expect(db.Exec(ctx, `UPDATE outbox SET available_at = '2000-01-01 00:00:00'`, nil)).Should(Succeed())
+			publisher.fail = nil
+			Expect(relay(outboxRelay)).Should(Equal(1))
+
+			Expect(publisher.attempts).Should(Equal([]int64{1, 2}))
+			Expect(publisher.published[0].Payload["attempt_number"]).Should(BeEquivalentTo(1))
+		})
+
+		It("marks record failed after max attempts and unblocks aggregate", func() {
+			dispatch(&hotelTask{HotelId: "1", Action: "create"}, &hotelTask{HotelId: "1", Action: "update"})
+			publisher.fail = func(payload map[string]any) error {
+				if payload["action"] == "create" {
+					return errors.New("rejected")
+				}
+				return nil
+			}
+			outboxRelay := events.NewOutboxRelay(db, publisher, events.WithOutboxMaxAttempts(1))
+
+			Expect(relay(outboxRelay)).Should(Equal(1))
+			Expect(rows()[0].FailedAt).ShouldNot(BeNil())
+
+			Expect(relay(outboxRelay)).Should(Equal(1))
+			Expect(publisher.actions()).Should(Equal([]string{"update"}))
+		})
+
+		It("claims records with SKIP LOCKED", func() {
+			tx := &queryTx{}
+			mockPool := pgxpoolmock.NewMockPgxPool(gomock.NewController(GinkgoT()))
+			mockPool.EXPECT().Begin(gomock.Any()).Return(tx, nil)
+
+			relayed, err := events.NewOutboxRelay(database.NewDBService(mockPool), publisher, events.WithOutboxBatchSize(10)).RelayBatch(ctx)
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(relayed).Should(BeZero())
+			Expect(tx.queries).Should(HaveLen(1))
+			Expect(tx.queries[0]).Should(HaveSuffix(`ORDER BY "o"."id" ASC LIMIT 10 FOR UPDATE SKIP LOCKED`))
+			Expect(tx.queries[0]).Should(ContainSubstring(`NOT EXISTS (SELECT 1 FROM "outbox" AS "e" WHERE (("e"."aggregate_id" = "o"."aggregate_id") AND ("e"."sequence" < "o"."sequence")`))
+		})
+
+		It("deletes delivered records after retention", func() {
+			dispatch(&plainTask{Action: "old"}, &plainTask{Action: "new"}, &plainTask{Action: "pending"})
+			Expect(db.Exec(ctx, `UPDATE outbox SET delivered_at = ? WHERE id = 1`, []any{time.Now().Add(-2 * time.Hour).UTC().Format("2006-01-02 15:04:05")})).Should(Succeed())
+			Expect(db.Exec(ctx, `UPDATE outbox SET delivered_at = ? WHERE id = 2`, []any{time.Now().UTC().Format("2006-01-02 15:04:05")})).Should(Succeed())
+
+			deleted, err := events.NewOutboxRelay(db, publisher, events.WithOutboxRetention(time.Hour)).Cleanup(ctx)
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(deleted).Should(Equal(int64(1)))
+
+			var payloads []string
+			for _, row := range rows() {
+				payloads = append(payloads, row.Payload)
+			}
+			Expect(strings.Join(payloads, ",")).ShouldNot(ContainSubstring("old"))
+			Expect(payloads).Should(HaveLen(2))
+		})
+	})
+})
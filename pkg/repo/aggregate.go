@@ -0,0 +1,38 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/doug-martin/goqu/v9/exp"
+)
+
+// aggregator репозиторий, который умеет выполнять агрегирующие запросы со сканированием в dest
+// Обертки над BaseRepo передают вызов во вложенный репозиторий через aggregateInto
+type aggregator interface {
+	aggregate(ctx context.Context, criteria exp.ExpressionList, dest any, options ...ListOption) error
+}
+
+// Aggregate выполняет агрегирующий запрос по таблице репозитория r и возвращает строки результата типа R
+// Колонки задаются WithColumns, например goqu.COUNT(goqu.Star()).As("bookings"), группировка - WithGroupBy и WithHaving.
+// Поля R сопоставляются с колонками по тегу db. Связи, soft удаление, сортировка и пагинация учитываются так же,
+// как в ListByExpression. Для репозиториев без поддержки агрегации возвращается ErrAggregateNotSupported
+//
+//	stats, err := repo.Aggregate[hotelStats](ctx, hotels, goqu.And(), repo.WithColumns("city_id", goqu.COUNT(goqu.Star()).As("hotels")), repo.WithGroupBy("city_id"))
+func Aggregate[R any, T any, ID int64 | string](ctx context.Context, r BaseRepo[T, ID], criteria exp.ExpressionList, options ...ListOption) ([]R, error) {
+	var res []R
+	if err := aggregateInto(ctx, r, criteria, &res, options...); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// aggregateInto выполняет агрегирующий запрос репозитория r, если он его поддерживает
+func aggregateInto[T any, ID int64 | string](ctx context.Context, r BaseRepo[T, ID], criteria exp.ExpressionList, dest any, options ...ListOption) error {
+	a, ok := r.(aggregator)
+	if !ok {
+		return ErrAggregateNotSupported
+	}
+
+	return a.aggregate(ctx, criteria, dest, options...)
+}
@@ -0,0 +1,134 @@
+package repo_test
+
+import (
+	"context"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgx/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/EveryHotel/core-tools/pkg/database"
+	"github.com/EveryHotel/core-tools/pkg/repo"
+)
+
+type hotelStars struct {
+	Stars  int64 `db:"stars"`
+	Hotels int64 `db:"hotels"`
+}
+
+var _ = Describe("Aggregate", func() {
+	var (
+		ctx    context.Context
+		svc    database.DBService
+		hotels repo.BaseRepo[hotel, int64]
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		svc = openDB()
+		hotels = repo.NewRepository[hotel, int64](svc, "hotels", "h", "id")
+
+		_, err := hotels.CreateMultiple(ctx, []hotel{
+			{Name: "Alfa", Stars: 3},
+			{Name: "Beta", Stars: 5},
+			{Name: "Gamma", Stars: 3},
+			{Name: "Delta", Stars: 4},
+			{Name: "Omega", Stars: 3},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	byStars := []repo.ListOption{
+		repo.WithColumns("stars", goqu.COUNT(goqu.Star()).As("hotels")),
+		repo.WithGroupBy("stars"),
+		repo.WithSort([]exp.OrderedExpression{goqu.I("h.stars").Asc()}),
+	}
+
+	It("returns typed rows", func() {
+		stats, err := repo.Aggregate[hotelStars](ctx, hotels, goqu.And(), byStars...)
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(stats).Should(Equal([]hotelStars{{Stars: 3, Hotels: 3}, {Stars: 4, Hotels: 1}, {Stars: 5, Hotels: 1}}))
+	})
+
+	It("applies criteria and having", func() {
+		stats, err := repo.Aggregate[hotelStars](ctx, hotels, goqu.And(goqu.I("h.name").Neq("Alfa")),
+			append(byStars, repo.WithHaving(goqu.COUNT(goqu.Star()).Gt(1)))...)
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(stats).Should(Equal([]hotelStars{{Stars: 3, Hotels: 2}}))
+	})
+
+	It("requires columns", func() {
+		_, err := repo.Aggregate[hotelStars](ctx, hotels, goqu.And(), repo.WithGroupBy("stars"))
+
+		Expect(err).Should(MatchError(repo.ErrNoColumns))
+	})
+
+	It("passes through decorators", func() {
+		indexed := repo.NewIndexableRepository[roomIndex, room, int64](svc, &fakeMeili{documents: map[string]any{}}, "rooms", "rooms", "r", "id",
+			func(ptr *room, id int64) { ptr.Id = id }, nil, nil, nil)
+		_, err := indexed.CreateMultiple(ctx, []room{{HotelId: 1, Name: "Suite"}, {HotelId: 1, Name: "Double"}, {HotelId: 2, Name: "Single"}})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		type roomsByHotel struct {
+			HotelId int64 `db:"hotel_id"`
+			Rooms   int64 `db:"rooms"`
+		}
+		stats, err := repo.Aggregate[roomsByHotel](ctx, indexed, goqu.And(),
+			repo.WithColumns("hotel_id", goqu.COUNT(goqu.Star()).As("rooms")),
+			repo.WithGroupBy("hotel_id"),
+			repo.WithSort([]exp.OrderedExpression{goqu.I("r.hotel_id").Asc()}),
+		)
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(stats).Should(Equal([]roomsByHotel{{HotelId: 1, Rooms: 2}, {HotelId: 2, Rooms: 1}}))
+	})
+
+	It("fails for repository without aggregate", func() {
+		_, err := repo.Aggregate[hotelStars](ctx, struct{ repo.BaseRepo[hotel, int64] }{hotels}, goqu.And(), byStars...)
+
+		Expect(err).Should(MatchError(repo.ErrAggregateNotSupported))
+	})
+
+	Describe("CountByExpression", func() {
+		It("counts groups", func() {
+			total, err := hotels.CountByExpression(ctx, goqu.And(), repo.WithGroupBy("stars"))
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(total).Should(Equal(int64(3)))
+		})
+
+		It("counts groups matching having for list page", func() {
+			items, total, err := hotels.ListPage(ctx, goqu.And(),
+				repo.WithGroupBy("stars"),
+				repo.WithHaving(goqu.COUNT(goqu.Star()).Eq(1)),
+				repo.WithLimit(1),
+			)
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(items).Should(HaveLen(1))
+			Expect(total).Should(Equal(int64(2)))
+		})
+
+		It("counts distinct rows over subquery", func() {
+			var query string
+			mockPool := pgxpoolmock.NewMockPgxPool(gomock.NewController(GinkgoT()))
+			mockPool.EXPECT().QueryRow(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, sql string, _ ...any) pgx.Row {
+				query = sql
+				return pgxpoolmock.NewRow([]string{"count"}, int64(2))
+			})
+			pgHotels := repo.NewRepository[hotel, int64](database.NewDBService(mockPool), "hotels", "h", "id")
+
+			total, err := pgHotels.CountByExpression(ctx, goqu.And(goqu.I("h.stars").Gt(3)), repo.WithDistinctOn("city_id"))
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(total).Should(Equal(int64(2)))
+			Expect(query).Should(Equal(`SELECT COUNT(*) FROM (SELECT DISTINCT ON ("h"."city_id") 1 FROM "hotels" AS "h" WHERE ("h"."stars" > 3)) AS "counted"`))
+		})
+	})
+})
@@ -0,0 +1,578 @@
+package repo
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+
+	"github.com/EveryHotel/core-tools/pkg/database"
+)
+
+// Ключи контекста с автором изменений для журнала аудита, значения - string или int64
+const (
+	CtxAuditUserId    = "audit_user_id"
+	CtxAuditService   = "audit_service"
+	CtxAuditRequestId = "audit_request_id"
+)
+
+// AuditSchema таблица журнала аудита по умолчанию, ее нужно добавить в миграции сервиса
+const AuditSchema = `CREATE TABLE IF NOT EXISTS audit_log (
+	id bigserial PRIMARY KEY,
+	table_name text NOT NULL,
+	entity_id text NOT NULL,
+	action text NOT NULL,
+	changes jsonb NOT NULL,
+	user_id text,
+	service text,
+	request_id text,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS audit_log_entity_idx ON audit_log (table_name, entity_id, id);`
+
+type AuditAction string
+
+const (
+	AuditActionCreate  AuditAction = "create"
+	AuditActionUpdate  AuditAction = "update"
+	AuditActionDelete  AuditAction = "delete"
+	AuditActionRestore AuditAction = "restore"
+)
+
+// ErrAuditWithoutCriteria массовое изменение без условия в репозитории с аудитом
+var ErrAuditWithoutCriteria = errors.New("audited bulk change requires criteria")
+
+// AuditChange значение колонки до и после изменения, null - значения нет (создание или удаление записи)
+type AuditChange struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// AuditChanges изменившиеся колонки записи
+type AuditChanges map[string]AuditChange
+
+func (c AuditChanges) Value() (driver.Value, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+func (c *AuditChanges) Scan(src any) error {
+	switch data := src.(type) {
+	case nil:
+		*c = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(data, c)
+	case string:
+		return json.Unmarshal([]byte(data), c)
+	}
+
+	return fmt.Errorf("cannot scan %T into AuditChanges", src)
+}
+
+// AuditEntry запись журнала аудита об изменении одной записи таблицы
+type AuditEntry struct {
+	Id        int64        `db:"id" primary:"1"`
+	TableName string       `db:"table_name"`
+	EntityId  string       `db:"entity_id"`
+	Action    AuditAction  `db:"action"`
+	Changes   AuditChanges `db:"changes"`
+	UserId    *string      `db:"user_id"`
+	Service   *string      `db:"service"`
+	RequestId *string      `db:"request_id"`
+	CreatedAt time.Time    `db:"created_at"`
+}
+
+// AuditLog журнал аудита изменений сущностей
+type AuditLog interface {
+	// Write сохраняет записи журнала, автор изменений берется из контекста
+	Write(ctx context.Context, entries []AuditEntry) error
+	// History возвращает историю изменений записи table с первичным ключом id в порядке их внесения
+	History(ctx context.Context, table string, id any) ([]AuditEntry, error)
+}
+
+type AuditOption func(*auditLog)
+
+// WithAuditTable задает таблицу журнала, по умолчанию audit_log
+func WithAuditTable(table string) AuditOption {
+	return func(l *auditLog) {
+		l.table = table
+	}
+}
+
+// WithAuditService задает имя сервиса для записей без CtxAuditService в контексте
+func WithAuditService(service string) AuditOption {
+	return func(l *auditLog) {
+		l.service = service
+	}
+}
+
+type auditLog struct {
+	table   string
+	service string
+	repo    BaseRepo[AuditEntry, int64]
+}
+
+// NewAuditLog возвращает журнал аудита в таблице БД
+func NewAuditLog(db database.DBService, opts ...AuditOption) AuditLog {
+	l := &auditLog{
+		table: "audit_log",
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	l.repo = NewRepository[AuditEntry, int64](db, l.table, "al", "id")
+
+	return l
+}
+
+func (l *auditLog) Write(ctx context.Context, entries []AuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	userId := auditCtxValue(ctx, CtxAuditUserId)
+	service := auditCtxValue(ctx, CtxAuditService)
+	if service == nil && l.service != "" {
+		service = &l.service
+	}
+	requestId := auditCtxValue(ctx, CtxAuditRequestId)
+
+	for i := range entries {
+		entries[i].UserId = userId
+		entries[i].Service = service
+		entries[i].RequestId = requestId
+	}
+
+	_, err := l.repo.CreateMultiple(ctx, entries)
+	return err
+}
+
+func (l *auditLog) History(ctx context.Context, table string, id any) ([]AuditEntry, error) {
+	return l.repo.ListBy(ctx, map[string]any{
+		"table_name": table,
+		"entity_id":  fmt.Sprint(id),
+	}, WithSort([]exp.OrderedExpression{goqu.I("al.id").Asc()}))
+}
+
+func auditCtxValue(ctx context.Context, key string) *string {
+	var value string
+	switch v := ctx.Value(key).(type) {
+	case string:
+		value = v
+	case int64:
+		value = fmt.Sprint(v)
+	default:
+		return nil
+	}
+
+	return &value
+}
+
+type auditRepo[T any, ID int64 | string] struct {
+	BaseRepo[T, ID]
+	db        database.DBService
+	log       AuditLog
+	tableName string
+	idColumn  string
+}
+
+// NewAuditRepository оборачивает repo журналом аудита: методы создания, изменения, удаления и восстановления записей
+// в одной транзакции с изменением записывают в log значения изменившихся колонок db до и после изменения.
+// Записи читаются до изменения с блокировкой FOR UPDATE и перечитываются после, поэтому в журнал попадают
+// и значения, проставленные базой или хуками. BulkInsert и методы чтения передаются в repo без аудита.
+// T без поля с тегом primary вызывает панику при создании репозитория
+func NewAuditRepository[T any, ID int64 | string](repo BaseRepo[T, ID], db database.DBService, log AuditLog, tableName string) BaseRepo[T, ID] {
+	r := &auditRepo[T, ID]{
+		BaseRepo:  repo,
+		db:        db,
+		log:       log,
+		tableName: tableName,
+	}
+
+	for _, field := range database.StructFields(reflect.TypeFor[T]()) {
+		if field.Primary && !field.Embedded {
+			r.idColumn = field.Column
+		}
+	}
+	// без первичного ключа записи журнала нельзя связать с сущностями, это ошибка описания T
+	if r.idColumn == "" {
+		panic(fmt.Sprintf("audit repository %s: %s has no primary field", tableName, reflect.TypeFor[T]()))
+	}
+
+	return r
+}
+
+// aggregate передает агрегирующий запрос во вложенный репозиторий без аудита
+func (r *auditRepo[T, ID]) aggregate(ctx context.Context, criteria exp.ExpressionList, dest any, options ...ListOption) error {
+	return aggregateInto(ctx, r.BaseRepo, criteria, dest, options...)
+}
+
+func (r *auditRepo[T, ID]) Create(ctx context.Context, entity T, options ...SqlQueryOption) (ID, error) {
+	var id ID
+	err := r.db.RunInTx(ctx, func(ctx context.Context) error {
+		var err error
+		if id, err = r.BaseRepo.Create(ctx, entity, options...); err != nil {
+			return err
+		}
+
+		after, err := r.list(ctx, map[string]any{r.idColumn: id}, false)
+		if err != nil {
+			return err
+		}
+
+		return r.write(ctx, AuditActionCreate, nil, after)
+	})
+
+	return id, err
+}
+
+func (r *auditRepo[T, ID]) CreateMultiple(ctx context.Context, entities []T, options ...SqlQueryOption) ([]ID, error) {
+	var ids []ID
+	err := r.db.RunInTx(ctx, func(ctx context.Context) error {
+		var err error
+		if ids, err = r.BaseRepo.CreateMultiple(ctx, entities, options...); err != nil || len(ids) == 0 {
+			return err
+		}
+
+		after, err := r.list(ctx, map[string]any{r.idColumn: ids}, false)
+		if err != nil {
+			return err
+		}
+
+		return r.write(ctx, AuditActionCreate, nil, after)
+	})
+
+	return ids, err
+}
+
+func (r *auditRepo[T, ID]) Update(ctx context.Context, entity T, options ...SqlQueryOption) error {
+	id, _ := SanitizeRows[ID](entity)
+
+	return r.audit(ctx, AuditActionUpdate, map[string]any{r.idColumn: id}, func(ctx context.Context) error {
+		return r.BaseRepo.Update(ctx, entity, options...)
+	})
+}
+
+func (r *auditRepo[T, ID]) Patch(ctx context.Context, id ID, patch any, options ...SqlQueryOption) error {
+	return r.audit(ctx, AuditActionUpdate, map[string]any{r.idColumn: id}, func(ctx context.Context) error {
+		return r.BaseRepo.Patch(ctx, id, patch, options...)
+	})
+}
+
+// UpdateMultiple записи ищутся по conflict_target, как и при upsert, новые записи журналируются как созданные
+func (r *auditRepo[T, ID]) UpdateMultiple(ctx context.Context, entities []T, options ...SqlQueryOption) error {
+	if len(entities) == 0 {
+		return nil
+	}
+
+	conflictTarget, _ := BuildConflictUpdate(entities[0])
+	keys := make([]any, 0, len(entities))
+	for _, entity := range entities {
+		keys = append(keys, columnValue(entity, conflictTarget))
+	}
+
+	return r.audit(ctx, AuditActionUpdate, map[string]any{conflictTarget: keys}, func(ctx context.Context) error {
+		return r.BaseRepo.UpdateMultiple(ctx, entities, options...)
+	})
+}
+
+// BulkUpdate без условия where обновил бы всю таблицу, которую пришлось бы целиком читать для журнала,
+// поэтому пустое условие возвращает ErrAuditWithoutCriteria
+func (r *auditRepo[T, ID]) BulkUpdate(ctx context.Context, updateFields, where map[string]any, options ...SqlQueryOption) error {
+	return r.auditBy(ctx, AuditActionUpdate, where, func(ctx context.Context) error {
+		return r.BaseRepo.BulkUpdate(ctx, updateFields, where, options...)
+	})
+}
+
+func (r *auditRepo[T, ID]) Delete(ctx context.Context, id ID, options ...SqlQueryOption) error {
+	return r.audit(ctx, AuditActionDelete, map[string]any{r.idColumn: id}, func(ctx context.Context) error {
+		return r.BaseRepo.Delete(ctx, id, options...)
+	})
+}
+
+func (r *auditRepo[T, ID]) SoftDelete(ctx context.Context, id ID, options ...SqlQueryOption) error {
+	return r.audit(ctx, AuditActionDelete, map[string]any{r.idColumn: id}, func(ctx context.Context) error {
+		return r.BaseRepo.SoftDelete(ctx, id, options...)
+	})
+}
+
+func (r *auditRepo[T, ID]) ForceDelete(ctx context.Context, id ID, options ...SqlQueryOption) error {
+	return r.audit(ctx, AuditActionDelete, map[string]any{r.idColumn: id}, func(ctx context.Context) error {
+		return r.BaseRepo.ForceDelete(ctx, id, options...)
+	})
+}
+
+// DeleteAndMoveReferences журналирует только удаление записи id, перенос ссылок в других таблицах не журналируется
+func (r *auditRepo[T, ID]) DeleteAndMoveReferences(ctx context.Context, id ID, newId ID) error {
+	return r.audit(ctx, AuditActionDelete, map[string]any{r.idColumn: id}, func(ctx context.Context) error {
+		return r.BaseRepo.DeleteAndMoveReferences(ctx, id, newId)
+	})
+}
+
+func (r *auditRepo[T, ID]) SoftDeleteMultiple(ctx context.Context, ids []ID) error {
+	if len(ids) == 0 {
+		return r.BaseRepo.SoftDeleteMultiple(ctx, ids)
+	}
+
+	return r.audit(ctx, AuditActionDelete, map[string]any{r.idColumn: ids}, func(ctx context.Context) error {
+		return r.BaseRepo.SoftDeleteMultiple(ctx, ids)
+	})
+}
+
+func (r *auditRepo[T, ID]) ForceDeleteMultiple(ctx context.Context, ids []ID) error {
+	if len(ids) == 0 {
+		return r.BaseRepo.ForceDeleteMultiple(ctx, ids)
+	}
+
+	return r.audit(ctx, AuditActionDelete, map[string]any{r.idColumn: ids}, func(ctx context.Context) error {
+		return r.BaseRepo.ForceDeleteMultiple(ctx, ids)
+	})
+}
+
+// DeleteBy с пустым criteria возвращает ErrAuditWithoutCriteria
+func (r *auditRepo[T, ID]) DeleteBy(ctx context.Context, criteria map[string]any, options ...SqlQueryOption) error {
+	return r.auditBy(ctx, AuditActionDelete, criteria, func(ctx context.Context) error {
+		return r.BaseRepo.DeleteBy(ctx, criteria, options...)
+	})
+}
+
+// ForceDeleteBy с пустым criteria возвращает ErrAuditWithoutCriteria
+func (r *auditRepo[T, ID]) ForceDeleteBy(ctx context.Context, criteria map[string]any, options ...SqlQueryOption) error {
+	return r.auditBy(ctx, AuditActionDelete, criteria, func(ctx context.Context) error {
+		return r.BaseRepo.ForceDeleteBy(ctx, criteria, options...)
+	})
+}
+
+func (r *auditRepo[T, ID]) Restore(ctx context.Context, id ID, options ...SqlQueryOption) error {
+	return r.audit(ctx, AuditActionRestore, map[string]any{r.idColumn: id}, func(ctx context.Context) error {
+		return r.BaseRepo.Restore(ctx, id, options...)
+	})
+}
+
+// RestoreBy с пустым criteria возвращает ErrAuditWithoutCriteria
+func (r *auditRepo[T, ID]) RestoreBy(ctx context.Context, criteria map[string]any, options ...SqlQueryOption) error {
+	return r.auditBy(ctx, AuditActionRestore, criteria, func(ctx context.Context) error {
+		return r.BaseRepo.RestoreBy(ctx, criteria, options...)
+	})
+}
+
+// audit выполняет fn в транзакции и журналирует записи, подходящие под criteria, до и после изменения
+func (r *auditRepo[T, ID]) audit(ctx context.Context, action AuditAction, criteria map[string]any, fn func(ctx context.Context) error) error {
+	return r.db.RunInTx(ctx, func(ctx context.Context) error {
+		before, err := r.list(ctx, criteria, true)
+		if err != nil {
+			return err
+		}
+
+		if err = fn(ctx); err != nil {
+			return err
+		}
+
+		after, err := r.list(ctx, criteria, false)
+		if err != nil {
+			return err
+		}
+
+		return r.write(ctx, action, before, after)
+	})
+}
+
+// auditBy как audit, но после изменения записи перечитываются по первичному ключу,
+// так как могут уже не подходить под criteria. Пустой criteria возвращает ErrAuditWithoutCriteria
+func (r *auditRepo[T, ID]) auditBy(ctx context.Context, action AuditAction, criteria map[string]any, fn func(ctx context.Context) error) error {
+	if len(criteria) == 0 {
+		return ErrAuditWithoutCriteria
+	}
+
+	return r.db.RunInTx(ctx, func(ctx context.Context) error {
+		before, err := r.list(ctx, criteria, true)
+		if err != nil {
+			return err
+		}
+
+		if err = fn(ctx); err != nil {
+			return err
+		}
+		if len(before) == 0 {
+			return nil
+		}
+
+		ids := make([]ID, 0, len(before))
+		for _, snapshot := range before {
+			var id ID
+			if err = json.Unmarshal(snapshot[r.idColumn], &id); err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		after, err := r.list(ctx, map[string]any{r.idColumn: ids}, false)
+		if err != nil {
+			return err
+		}
+
+		return r.write(ctx, action, before, after)
+	})
+}
+
+// auditSnapshot значения колонок записи в JSON
+type auditSnapshot map[string]json.RawMessage
+
+// list возвращает снимки записей по criteria, включая soft удаленные, с ключом по первичному ключу
+func (r *auditRepo[T, ID]) list(ctx context.Context, criteria map[string]any, lock bool) (map[string]auditSnapshot, error) {
+	options := []ListOption{WithTrashed()}
+	if lock {
+		options = append(options, ForUpdate())
+	}
+
+	// читаем с primary, изменения в транзакции на репликах не видны
+	ctx = context.WithValue(ctx, database.CtxDbForcePrimaryKey, true)
+	entities, err := r.BaseRepo.ListBy(ctx, criteria, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make(map[string]auditSnapshot, len(entities))
+	for _, entity := range entities {
+		id, snapshot, err := r.snapshot(entity)
+		if err != nil {
+			return nil, err
+		}
+		snapshots[id] = snapshot
+	}
+
+	return snapshots, nil
+}
+
+func (r *auditRepo[T, ID]) snapshot(entity T) (string, auditSnapshot, error) {
+	vEntity := reflect.ValueOf(entity)
+
+	var id string
+	snapshot := make(auditSnapshot)
+	for _, field := range database.StructFields(vEntity.Type()) {
+		// primary из embedded_struct сохраняется в таблицу только с not_serial
+		if field.Embedded && field.Primary && !field.NotSerial {
+			continue
+		}
+
+		value := vEntity.FieldByIndex(field.Index).Interface()
+		data, err := json.Marshal(value)
+		if err != nil {
+			return "", nil, fmt.Errorf("marshal column %s for audit: %w", field.Column, err)
+		}
+		snapshot[field.Column] = data
+
+		if field.Primary && !field.Embedded {
+			id = fmt.Sprint(value)
+		}
+	}
+
+	return id, snapshot, nil
+}
+
+// write журналирует разницу между снимками записей до и после изменения
+// Запись без снимка до изменения журналируется как созданная, записи без изменений пропускаются
+// Записи журналируются по возрастанию первичного ключа, чтобы одна операция всегда давала одинаковую историю
+func (r *auditRepo[T, ID]) write(ctx context.Context, action AuditAction, before, after map[string]auditSnapshot) error {
+	ids, err := r.sortedIds(before, after)
+	if err != nil {
+		return err
+	}
+
+	var entries []AuditEntry
+	for _, id := range ids {
+		afterSnapshot, ok := after[id]
+		// записи, которых нет после изменения, удалены
+		if !ok {
+			entries = append(entries, AuditEntry{TableName: r.tableName, EntityId: id, Action: AuditActionDelete, Changes: diffSnapshots(before[id], nil)})
+			continue
+		}
+
+		entryAction := action
+		if _, ok := before[id]; !ok {
+			entryAction = AuditActionCreate
+		}
+
+		if changes := diffSnapshots(before[id], afterSnapshot); len(changes) > 0 {
+			entries = append(entries, AuditEntry{TableName: r.tableName, EntityId: id, Action: entryAction, Changes: changes})
+		}
+	}
+
+	if err := r.log.Write(ctx, entries); err != nil {
+		slog.ErrorContext(ctx, "Error during write audit",
+			slog.Any("error", err),
+			slog.String("table", r.tableName),
+		)
+		return err
+	}
+
+	return nil
+}
+
+// sortedIds возвращает первичные ключи записей из всех снимков, упорядоченные по значению ключа ID
+func (r *auditRepo[T, ID]) sortedIds(snapshots ...map[string]auditSnapshot) ([]string, error) {
+	values := make(map[string]ID)
+	for _, items := range snapshots {
+		for id, snapshot := range items {
+			if _, ok := values[id]; ok {
+				continue
+			}
+
+			var value ID
+			if err := json.Unmarshal(snapshot[r.idColumn], &value); err != nil {
+				return nil, err
+			}
+			values[id] = value
+		}
+	}
+
+	ids := slices.Collect(maps.Keys(values))
+	slices.SortFunc(ids, func(a, b string) int {
+		return cmp.Compare(values[a], values[b])
+	})
+
+	return ids, nil
+}
+
+func diffSnapshots(before, after auditSnapshot) AuditChanges {
+	changes := make(AuditChanges)
+	for column, value := range after {
+		if prev, ok := before[column]; !ok || !bytes.Equal(prev, value) {
+			changes[column] = AuditChange{Before: before[column], After: value}
+		}
+	}
+	for column, value := range before {
+		if _, ok := after[column]; !ok {
+			changes[column] = AuditChange{Before: value}
+		}
+	}
+
+	return changes
+}
+
+// columnValue возвращает значение поля сущности по имени колонки
+func columnValue(entity any, column string) any {
+	vEntity := reflect.ValueOf(entity)
+	for _, field := range database.StructFields(vEntity.Type()) {
+		if field.Column == column && !field.Embedded {
+			return vEntity.FieldByIndex(field.Index).Interface()
+		}
+	}
+
+	return nil
+}
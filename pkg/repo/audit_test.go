@@ -0,0 +1,241 @@
+package repo_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/EveryHotel/core-tools/pkg/database"
+	"github.com/EveryHotel/core-tools/pkg/repo"
+	"github.com/EveryHotel/core-tools/pkg/types"
+)
+
+// auditSchema repo.AuditSchema в типах SQLite
+const auditSchema = `CREATE TABLE audit_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	table_name TEXT NOT NULL,
+	entity_id TEXT NOT NULL,
+	action TEXT NOT NULL,
+	changes TEXT NOT NULL,
+	user_id TEXT,
+	service TEXT,
+	request_id TEXT,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`
+
+type roomPatch struct {
+	Name types.Omitempty[string] `db:"name"`
+}
+
+// cityName сущность без поля primary
+type cityName struct {
+	Name string `db:"name"`
+}
+
+var _ = Describe("Audit", func() {
+	var (
+		ctx      context.Context
+		db       database.DBService
+		auditLog repo.AuditLog
+		rooms    repo.BaseRepo[room, int64]
+		hotels   repo.BaseRepo[hotel, int64]
+	)
+
+	BeforeEach(func() {
+		db = openDB()
+		exec(db, auditSchema)
+		ctx = context.WithValue(context.Background(), repo.CtxAuditUserId, int64(7))
+		ctx = context.WithValue(ctx, repo.CtxAuditRequestId, "req-1")
+
+		auditLog = repo.NewAuditLog(db, repo.WithAuditService("content"))
+		rooms = repo.NewAuditRepository(repo.NewRepository[room, int64](db, "rooms", "r", "id"), db, auditLog, "rooms")
+		hotels = repo.NewAuditRepository(repo.NewRepository[hotel, int64](db, "hotels", "h", "id"), db, auditLog, "hotels")
+	})
+
+	history := func(table string, id int64) []repo.AuditEntry {
+		entries, err := auditLog.History(ctx, table, id)
+		Expect(err).ShouldNot(HaveOccurred())
+		return entries
+	}
+
+	// changed возвращает изменившиеся колонки записи журнала
+	changed := func(entry repo.AuditEntry) []string {
+		var columns []string
+		for column := range entry.Changes {
+			columns = append(columns, column)
+		}
+		return columns
+	}
+
+	value := func(data json.RawMessage) any {
+		var v any
+		Expect(json.Unmarshal(data, &v)).Should(Succeed())
+		return v
+	}
+
+	auditCount := func() int64 {
+		count, err := db.Count(ctx, `SELECT COUNT(*) FROM audit_log`, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		return count
+	}
+
+	createRoom := func(name string) int64 {
+		id, err := rooms.Create(ctx, room{HotelId: 1, Name: name})
+		Expect(err).ShouldNot(HaveOccurred())
+		return id
+	}
+
+	It("writes created entity with actor from context", func() {
+		id := createRoom("Lux")
+
+		entries := history("rooms", id)
+		Expect(entries).Should(HaveLen(1))
+		Expect(entries[0].Action).Should(Equal(repo.AuditActionCreate))
+		Expect(entries[0].EntityId).Should(Equal(fmt.Sprint(id)))
+		Expect(*entries[0].UserId).Should(Equal("7"))
+		Expect(*entries[0].Service).Should(Equal("content"))
+		Expect(*entries[0].RequestId).Should(Equal("req-1"))
+		Expect(changed(entries[0])).Should(ConsistOf("id", "hotel_id", "name", "deleted_at"))
+		Expect(entries[0].Changes["name"].Before).Should(Equal(json.RawMessage("null")))
+		Expect(value(entries[0].Changes["name"].After)).Should(Equal("Lux"))
+	})
+
+	It("writes each entity created with CreateMultiple", func() {
+		ids, err := rooms.CreateMultiple(ctx, []room{{Name: "Lux"}, {Name: "Suite"}})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		for _, id := range ids {
+			entries := history("rooms", id)
+			Expect(entries).Should(HaveLen(1))
+			Expect(entries[0].Action).Should(Equal(repo.AuditActionCreate))
+		}
+	})
+
+	It("writes only changed columns on Update and Patch", func() {
+		id := createRoom("Lux")
+
+		Expect(rooms.Update(ctx, room{Id: id, HotelId: 2, Name: "Lux"})).Should(Succeed())
+		Expect(rooms.Patch(ctx, id, roomPatch{Name: types.Omitempty[string]{Value: "Suite", Valid: true}})).Should(Succeed())
+
+		entries := history("rooms", id)
+		Expect(entries).Should(HaveLen(3))
+		Expect(entries[1].Action).Should(Equal(repo.AuditActionUpdate))
+		Expect(changed(entries[1])).Should(ConsistOf("hotel_id"))
+		Expect(entries[2].Action).Should(Equal(repo.AuditActionUpdate))
+		Expect(changed(entries[2])).Should(ConsistOf("name"))
+		Expect(value(entries[2].Changes["name"].Before)).Should(Equal("Lux"))
+		Expect(value(entries[2].Changes["name"].After)).Should(Equal("Suite"))
+	})
+
+	It("skips entities without changes", func() {
+		id := createRoom("Lux")
+
+		Expect(rooms.Patch(ctx, id, roomPatch{Name: types.Omitempty[string]{Value: "Lux", Valid: true}})).Should(Succeed())
+
+		Expect(history("rooms", id)).Should(HaveLen(1))
+	})
+
+	It("writes entities which no longer match BulkUpdate criteria", func() {
+		lux := createRoom("Lux")
+		suite := createRoom("Suite")
+		other, err := rooms.Create(ctx, room{HotelId: 2, Name: "Other"})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(rooms.BulkUpdate(ctx, map[string]any{"hotel_id": 3}, map[string]any{"hotel_id": 1})).Should(Succeed())
+
+		for _, id := range []int64{lux, suite} {
+			entries := history("rooms", id)
+			Expect(entries).Should(HaveLen(2))
+			Expect(value(entries[1].Changes["hotel_id"].After)).Should(BeEquivalentTo(3))
+		}
+		Expect(history("rooms", other)).Should(HaveLen(1))
+	})
+
+	It("writes entries of one change in order of entity id", func() {
+		var ids []int64
+		for i := 0; i < 12; i++ {
+			ids = append(ids, createRoom(fmt.Sprintf("Room %d", i)))
+		}
+
+		Expect(rooms.BulkUpdate(ctx, map[string]any{"hotel_id": 3}, map[string]any{"hotel_id": 1})).Should(Succeed())
+
+		var written []int64
+		Expect(db.Select(ctx, `SELECT CAST(entity_id AS INTEGER) FROM audit_log WHERE action = 'update' ORDER BY id`, nil, &written)).Should(Succeed())
+		Expect(written).Should(Equal(ids))
+	})
+
+	It("requires primary field of audited entity", func() {
+		Expect(func() {
+			repo.NewAuditRepository(repo.NewRepository[cityName, int64](db, "cities", "c", "id"), db, auditLog, "cities")
+		}).Should(PanicWith(ContainSubstring("has no primary field")))
+	})
+
+	It("rejects bulk changes without criteria", func() {
+		id := createRoom("Lux")
+
+		Expect(rooms.BulkUpdate(ctx, map[string]any{"hotel_id": 3}, nil)).Should(MatchError(repo.ErrAuditWithoutCriteria))
+		Expect(rooms.BulkUpdate(ctx, map[string]any{"hotel_id": 3}, map[string]any{})).Should(MatchError(repo.ErrAuditWithoutCriteria))
+		Expect(rooms.DeleteBy(ctx, nil)).Should(MatchError(repo.ErrAuditWithoutCriteria))
+		Expect(rooms.ForceDeleteBy(ctx, map[string]any{})).Should(MatchError(repo.ErrAuditWithoutCriteria))
+		Expect(rooms.RestoreBy(ctx, nil)).Should(MatchError(repo.ErrAuditWithoutCriteria))
+
+		entity, err := rooms.Get(ctx, id)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(entity.HotelId).Should(Equal(int64(1)))
+		Expect(auditCount()).Should(Equal(int64(1)))
+	})
+
+	It("writes soft deleted and restored entities", func() {
+		id := createRoom("Lux")
+
+		Expect(rooms.DeleteBy(ctx, map[string]any{"name": "Lux"})).Should(Succeed())
+		Expect(rooms.Restore(ctx, id)).Should(Succeed())
+
+		entries := history("rooms", id)
+		Expect(entries).Should(HaveLen(3))
+		Expect(entries[1].Action).Should(Equal(repo.AuditActionDelete))
+		Expect(changed(entries[1])).Should(ConsistOf("deleted_at"))
+		Expect(entries[1].Changes["deleted_at"].After).ShouldNot(Equal(json.RawMessage("null")))
+		Expect(entries[2].Action).Should(Equal(repo.AuditActionRestore))
+		Expect(entries[2].Changes["deleted_at"].After).Should(Equal(json.RawMessage("null")))
+	})
+
+	It("writes all columns of force deleted entity", func() {
+		id := createRoom("Lux")
+
+		Expect(rooms.ForceDelete(ctx, id)).Should(Succeed())
+
+		entries := history("rooms", id)
+		Expect(entries).Should(HaveLen(2))
+		Expect(entries[1].Action).Should(Equal(repo.AuditActionDelete))
+		Expect(changed(entries[1])).Should(ConsistOf("id", "hotel_id", "name", "deleted_at"))
+		Expect(value(entries[1].Changes["name"].Before)).Should(Equal("Lux"))
+		Expect(entries[1].Changes["name"].After).Should(Equal(json.RawMessage("null")))
+	})
+
+	It("writes entities deleted by ids", func() {
+		lux := createRoom("Lux")
+		suite := createRoom("Suite")
+
+		Expect(rooms.SoftDeleteMultiple(ctx, []int64{lux})).Should(Succeed())
+		Expect(rooms.ForceDeleteMultiple(ctx, []int64{suite})).Should(Succeed())
+
+		Expect(history("rooms", lux)[1].Action).Should(Equal(repo.AuditActionDelete))
+		Expect(history("rooms", suite)[1].Action).Should(Equal(repo.AuditActionDelete))
+	})
+
+	It("rolls back audit with failed change", func() {
+		_, err := hotels.Create(ctx, hotel{Name: "Alfa"})
+		Expect(err).ShouldNot(HaveOccurred())
+		id, err := hotels.Create(ctx, hotel{Name: "Beta"})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(hotels.Patch(ctx, id, hotelPatch{Name: types.Omitempty[string]{Value: "Alfa", Valid: true}})).ShouldNot(Succeed())
+
+		Expect(history("hotels", id)).Should(HaveLen(1))
+		Expect(auditCount()).Should(Equal(int64(2)))
+	})
+})
@@ -3,7 +3,12 @@ package repo
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
+	"maps"
+	"reflect"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
@@ -18,22 +23,52 @@ import (
 //  Здесь было много всего в основном из-за SqlQueryOption
 //  Они у нас используются при сохранении и обновлении логов, там надо обязательно указывать WithPrepared и WithDialect
 
+// CtxTrashedScope ключ контекста с TrashedScope для чтения soft удаленных сущностей,
+// используется в Get/GetOneBy/List и в списках без явной опции WithTrashed/OnlyTrashed
+const CtxTrashedScope = "trashed_scope"
+
+// maxQueryParams ограничение postgres на количество параметров в одном запросе
+const maxQueryParams = 65535
+
+var (
+	ErrNotSoftDeletingEntity = errors.New("entity is not soft deleting")
+	// ErrStaleEntity сущность была изменена другим запросом, версия в базе не совпадает с версией сущности
+	ErrStaleEntity = errors.New("stale entity")
+	// ErrNoColumns для Aggregate не заданы колонки через WithColumns
+	ErrNoColumns = errors.New("no columns to select")
+	// ErrAggregateNotSupported репозиторий не выполняет агрегирующие запросы
+	ErrAggregateNotSupported = errors.New("repository does not support aggregate")
+	// ErrRowLockOutsideTx блокировка строк ForUpdate/ForNoKeyUpdate/ForShare запрошена без транзакции в контексте
+	ErrRowLockOutsideTx = errors.New("row lock requires transaction in context")
+	// ErrMoveReferencesToSelf ссылки переносятся на ту же сущность, которая удаляется
+	ErrMoveReferencesToSelf = errors.New("references cannot be moved to the deleted entity itself")
+)
+
 type BaseRepo[T any, ID int64 | string] interface {
 	BulkUpdate(context.Context, map[string]any, map[string]any, ...SqlQueryOption) error
 	Create(context.Context, T, ...SqlQueryOption) (ID, error)
 	Delete(context.Context, ID, ...SqlQueryOption) error
 	DeleteBy(context.Context, map[string]any, ...SqlQueryOption) error
+	Restore(context.Context, ID, ...SqlQueryOption) error
+	RestoreBy(context.Context, map[string]any, ...SqlQueryOption) error
 	Get(context.Context, ID, ...ListOptionRelation) (T, error)
 	GetOneBy(context.Context, map[string]any, ...ListOptionRelation) (T, error)
+	GetOneByExpression(context.Context, exp.ExpressionList, ...ListOption) (T, error)
 	ForceDelete(context.Context, ID, ...SqlQueryOption) error
 	ForceDeleteBy(context.Context, map[string]any, ...SqlQueryOption) error
 	List(context.Context, ...SqlQueryOption) ([]T, error)
 	ListBy(context.Context, map[string]any, ...ListOption) ([]T, error)
 	ListByExpression(context.Context, exp.ExpressionList, ...ListOption) ([]T, error)
+	Preload(context.Context, []T, ...ListOptionPreload) error
+	Iterate(context.Context, map[string]any, func(T) error, ...ListOption) error
+	ListPage(context.Context, exp.ExpressionList, ...ListOption) ([]T, int64, error)
+	CountByExpression(context.Context, exp.ExpressionList, ...ListOption) (int64, error)
 	SoftDelete(context.Context, ID, ...SqlQueryOption) error
 	SoftDeleteMultiple(context.Context, []ID) error
 	Update(context.Context, T, ...SqlQueryOption) error
+	Patch(context.Context, ID, any, ...SqlQueryOption) error
 	CreateMultiple(context.Context, []T, ...SqlQueryOption) ([]ID, error)
+	BulkInsert(context.Context, []T) (int64, error)
 	UpdateMultiple(context.Context, []T, ...SqlQueryOption) error
 	ForceDeleteMultiple(context.Context, []ID) error
 	DeleteAndMoveReferences(ctx context.Context, id ID, newId ID) error
@@ -65,9 +100,24 @@ func (r baseRepo[T, ID]) BulkUpdate(ctx context.Context, updateFields, where map
 		opt(optHandler)
 	}
 
-	ds := goqu.Dialect(optHandler.Dialect).
+	// арендатор добавляется в копию, nil карта обновляемых полей тоже допустима
+	updateFields = maps.Clone(updateFields)
+	if updateFields == nil {
+		updateFields = map[string]any{}
+	}
+	if err := r.setTenant(ctx, updateFields); err != nil {
+		return err
+	}
+
+	tenantWhere, err := r.tenantWhere(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	ds := r.dialect(optHandler).
 		Update(r.tableName).
-		Set(goqu.Record(updateFields))
+		Set(goqu.Record(updateFields)).
+		Where(tenantWhere...)
 
 	// возможность обновлять всю таблицу без условий
 	if where != nil {
@@ -102,20 +152,46 @@ func (r baseRepo[T, ID]) BulkUpdate(ctx context.Context, updateFields, where map
 
 // Create создает новую сущность
 func (r baseRepo[T, ID]) Create(ctx context.Context, entity T, options ...SqlQueryOption) (ID, error) {
+	if !hasCreateHooks[T, ID]() {
+		return r.create(ctx, entity, options...)
+	}
+
+	var id ID
+	err := r.db.RunInTx(ctx, func(ctx context.Context) error {
+		entity := entity
+		if err := BeforeCreate(ctx, &entity); err != nil {
+			return err
+		}
+
+		var err error
+		if id, err = r.create(ctx, entity, options...); err != nil {
+			return err
+		}
+
+		return AfterCreate(ctx, &entity, id)
+	})
+
+	return id, err
+}
+
+func (r baseRepo[T, ID]) create(ctx context.Context, entity T, options ...SqlQueryOption) (ID, error) {
+	var id ID
+
 	_, rows := SanitizeRowsForInsert[ID](entity)
+	if err := r.setTenant(ctx, rows); err != nil {
+		return id, err
+	}
 
 	optHandler := NewSqlQueryOptionHandler()
 	for _, opt := range options {
 		opt(optHandler)
 	}
 
-	ds := goqu.Dialect(optHandler.Dialect).Insert(r.tableName).
+	ds := r.dialect(optHandler).Insert(r.tableName).
 		Returning(goqu.C(r.idColumn)).
 		Rows(rows).Prepared(true).
 		Prepared(optHandler.Prepared)
 
-	var id ID
-
 	sql, args, err := ds.ToSQL()
 	if err != nil {
 		slog.ErrorContext(ctx, "Cannot build SQL query for insert",
@@ -141,18 +217,51 @@ func (r baseRepo[T, ID]) Create(ctx context.Context, entity T, options ...SqlQue
 
 // Update обновляет сущность
 func (r baseRepo[T, ID]) Update(ctx context.Context, entity T, options ...SqlQueryOption) error {
+	if !hasUpdateHooks[T]() {
+		return r.update(ctx, entity, options...)
+	}
+
+	return r.db.RunInTx(ctx, func(ctx context.Context) error {
+		entity := entity
+		if err := BeforeUpdate(ctx, &entity); err != nil {
+			return err
+		}
+
+		if err := r.update(ctx, entity, options...); err != nil {
+			return err
+		}
+
+		return AfterUpdate(ctx, &entity)
+	})
+}
+
+func (r baseRepo[T, ID]) update(ctx context.Context, entity T, options ...SqlQueryOption) error {
 	id, rows := SanitizeRowsForUpdate[ID](entity)
+	if err := r.setTenant(ctx, rows); err != nil {
+		return err
+	}
+
+	tenantWhere, err := r.tenantWhere(ctx, "")
+	if err != nil {
+		return err
+	}
 
 	optHandler := NewSqlQueryOptionHandler()
 	for _, opt := range options {
 		opt(optHandler)
 	}
 
-	ds := goqu.Dialect(optHandler.Dialect).Update(r.tableName).
+	ds := r.dialect(optHandler).Update(r.tableName).
 		Where(goqu.C(r.idColumn).Eq(id)).
+		Where(tenantWhere...).
 		Set(rows).
 		Prepared(optHandler.Prepared)
 
+	versionColumn, version, versioned := GetEntityVersion(entity)
+	if versioned {
+		ds = ds.Where(goqu.C(versionColumn).Eq(version))
+	}
+
 	sql, args, err := ds.ToSQL()
 	if err != nil {
 		slog.ErrorContext(ctx, "Cannot build SQL query for update",
@@ -164,7 +273,21 @@ func (r baseRepo[T, ID]) Update(ctx context.Context, entity T, options ...SqlQue
 		return err
 	}
 
-	err = r.db.Exec(ctx, sql, args)
+	if !versioned && len(tenantWhere) == 0 {
+		err = r.db.Exec(ctx, sql, args)
+	} else {
+		var affected int64
+		affected, err = r.db.ExecAffected(ctx, sql, args)
+		if err == nil && affected == 0 {
+			// запись без версии, которой нет ни у одного арендатора, обновляется без ошибки, как и без арендатора
+			var notFound error
+			if versioned {
+				notFound = fmt.Errorf("%w: table %s, id %v, version %d", ErrStaleEntity, r.tableName, id, version)
+			}
+			return r.missingRowError(ctx, id, notFound)
+		}
+	}
+
 	if err != nil {
 		slog.ErrorContext(ctx, "Error during exec update",
 			slog.Any("error", err),
@@ -178,32 +301,164 @@ func (r baseRepo[T, ID]) Update(ctx context.Context, entity T, options ...SqlQue
 	return nil
 }
 
+// Patch частично обновляет сущность по id
+// patch - структура с полями types.Omitempty и тегом db, обновляются только переданные поля
+// Как и в Update, проставляется updated_at и увеличивается версия сущности. Если в patch передана версия,
+// запись обновляется только при ее совпадении, иначе возвращается ErrStaleEntity.
+// Если записи нет, возвращается pgx.ErrNoRows, если она принадлежит другому арендатору - ErrTenantMismatch
+func (r baseRepo[T, ID]) Patch(ctx context.Context, id ID, patch any, options ...SqlQueryOption) error {
+	rows, err := SanitizePatchRows(patch)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	// проставляем updated_at и версию, если сущность их содержит, так же как при Update
+	_, entityRows := SanitizeRows[ID](*new(T), WithDefaultTimestamps("updated_at"), WithVersionIncrement())
+	if updatedAt, ok := entityRows["updated_at"]; ok {
+		rows["updated_at"] = updatedAt
+	}
+
+	versionColumn, _, versioned := GetEntityVersion(*new(T))
+	var expectedVersion any
+	var versionChecked bool
+	if versioned {
+		expectedVersion, versionChecked = rows[versionColumn]
+		rows[versionColumn] = entityRows[versionColumn]
+	}
+
+	if err = r.setTenant(ctx, rows); err != nil {
+		return err
+	}
+
+	tenantWhere, err := r.tenantWhere(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	optHandler := NewSqlQueryOptionHandler()
+	for _, opt := range options {
+		opt(optHandler)
+	}
+
+	ds := r.dialect(optHandler).Update(r.tableName).
+		Where(goqu.C(r.idColumn).Eq(id)).
+		Where(tenantWhere...).
+		Set(rows).
+		Prepared(optHandler.Prepared)
+
+	if versionChecked {
+		ds = ds.Where(goqu.C(versionColumn).Eq(expectedVersion))
+	}
+
+	sql, args, err := ds.ToSQL()
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot build SQL query for patch",
+			slog.Any("error", err),
+			slog.String("table", r.tableName),
+			slog.Any("id", id),
+			slog.String("sql", sql),
+		)
+		return err
+	}
+
+	affected, err := r.db.ExecAffected(ctx, sql, args)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error during exec patch",
+			slog.Any("error", err),
+			slog.String("table", r.tableName),
+			slog.Any("id", id),
+			slog.Any("data", rows),
+		)
+		return err
+	}
+
+	if affected == 0 {
+		notFound := fmt.Errorf("%w: table %s, id %v", pgx.ErrNoRows, r.tableName, id)
+		if versionChecked {
+			notFound = fmt.Errorf("%w: table %s, id %v, version %v", ErrStaleEntity, r.tableName, id, expectedVersion)
+		}
+		return r.missingRowError(ctx, id, notFound)
+	}
+
+	return nil
+}
+
+// missingRowError возвращает причину, по которой запрос не изменил запись id:
+// ErrTenantMismatch, если запись есть у другого арендатора, иначе notFound
+func (r baseRepo[T, ID]) missingRowError(ctx context.Context, id ID, notFound error) error {
+	column, tenant, scoped, err := TenantScope(ctx, reflect.TypeFor[T]())
+	if err != nil {
+		return err
+	}
+	if !scoped {
+		return notFound
+	}
+
+	// запись только что не изменилась на primary, на репликах ее состояние может отставать
+	ctx = context.WithValue(ctx, database.CtxDbForcePrimaryKey, true)
+	ctx = context.WithValue(ctx, CtxTenantBypass, true)
+	count, err := r.CountByExpression(ctx, goqu.And(
+		goqu.I(r.aliasedIdColumn()).Eq(id),
+		goqu.C(column).Table(r.alias).Neq(tenant),
+	), WithTrashed())
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: table %s, id %v", ErrTenantMismatch, r.tableName, id)
+	}
+
+	return notFound
+}
+
 // Get возвращает сущность по id
 func (r *baseRepo[T, ID]) Get(ctx context.Context, id ID, relations ...ListOptionRelation) (T, error) {
-	idCondKey := r.idColumn
-	// если в idColumn только наименование колонки, то добавляем префикс
-	if !strings.Contains(idCondKey, ".") {
-		idCondKey = r.alias + "." + idCondKey
-	}
 	return r.GetOneBy(ctx, map[string]any{
-		idCondKey: id,
+		r.aliasedIdColumn(): id,
 	}, relations...)
 }
 
+// dialect возвращает диалект из WithDialect, без него - диалект базы репозитория
+func (r *baseRepo[T, ID]) dialect(optHandler *SqlQueryOptionHandler) goqu.DialectWrapper {
+	if optHandler.Dialect != "" {
+		return goqu.Dialect(optHandler.Dialect)
+	}
+
+	return r.db.Dialect()
+}
+
+// aliasedIdColumn возвращает колонку id для запросов по алиасу таблицы
+func (r *baseRepo[T, ID]) aliasedIdColumn() string {
+	// если в idColumn только наименование колонки, то добавляем префикс
+	if !strings.Contains(r.idColumn, ".") {
+		return r.alias + "." + r.idColumn
+	}
+	return r.idColumn
+}
+
 // GetOneBy возвращает сущность по указанным параметрам
 func (r *baseRepo[T, ID]) GetOneBy(ctx context.Context, conditions map[string]any, relations ...ListOptionRelation) (T, error) {
+	return r.GetOneByExpression(ctx, goqu.And(goqu.Ex(conditions)), WithRelations(relations))
+}
+
+// GetOneByExpression возвращает сущность по выражению, в отличие от GetOneBy принимает опции списка,
+// например ForUpdate для блокировки строки или WithPreloads. Колонки из WithColumns не учитываются
+func (r *baseRepo[T, ID]) GetOneByExpression(ctx context.Context, criteria exp.ExpressionList, options ...ListOption) (T, error) {
 	var entity T
 
-	var relationAliases []string
-	for _, relation := range relations {
-		relationAliases = append(relationAliases, relation.Alias)
+	optHandler := NewListOptionHandler()
+	for _, opt := range options {
+		opt(optHandler)
 	}
+	optHandler.Columns = nil
 
-	ds := goqu.Select(database.Sanitize(entity, database.WithPrefix(r.alias), database.WithRelations(relationAliases...))...).
-		From(database.GetTableName(r.tableName).As(r.alias)).
-		Where(goqu.Ex(conditions))
-
-	ds = applyRelations(ds, relations)
+	ds, relations, err := r.newListDataset(ctx, criteria, optHandler)
+	if err != nil {
+		return entity, err
+	}
 
 	sql, args, err := ds.ToSQL()
 	if err != nil {
@@ -215,17 +470,25 @@ func (r *baseRepo[T, ID]) GetOneBy(ctx context.Context, conditions map[string]an
 		return entity, err
 	}
 
-	if err = r.db.SelectOne(ctx, sql, args, &entity, relationAliases...); err != nil {
+	if err = r.db.SelectOne(ctx, sql, args, &entity, relations...); err != nil {
 		if !errors.Is(err, pgx.ErrNoRows) {
 			slog.ErrorContext(ctx, "Error during exec select",
 				slog.Any("error", err),
 				slog.String("table", r.tableName),
-				slog.Any("conditions", conditions),
+				slog.Any("criteria", criteria),
 			)
 		}
 		return entity, err
 	}
 
+	if len(optHandler.Preloads) > 0 {
+		entities := []T{entity}
+		if err = r.Preload(ctx, entities, optHandler.Preloads...); err != nil {
+			return entity, err
+		}
+		entity = entities[0]
+	}
+
 	return entity, nil
 }
 
@@ -233,15 +496,14 @@ func (r *baseRepo[T, ID]) GetOneBy(ctx context.Context, conditions map[string]an
 func (r baseRepo[T, ID]) List(ctx context.Context, options ...SqlQueryOption) ([]T, error) {
 	var res []T
 
-	optHandler := NewSqlQueryOptionHandler()
-	for _, opt := range options {
-		opt(optHandler)
-	}
+	optHandler := NewListOptionHandler()
+	optHandler.SqlOptions = options
 
-	ds := goqu.Dialect(optHandler.Dialect).
-		Select(database.Sanitize(*new(T), database.WithPrefix(r.alias))...).
-		From(database.GetTableName(r.tableName).As(r.alias)).
-		Prepared(optHandler.Prepared)
+	ds, err := r.newSelectDataset(ctx, optHandler)
+	if err != nil {
+		return res, err
+	}
+	ds = ds.Select(database.Sanitize(*new(T), database.WithPrefix(r.alias))...)
 
 	sql, args, err := ds.ToSQL()
 	if err != nil {
@@ -279,38 +541,17 @@ func (r *baseRepo[T, ID]) ListByExpression(ctx context.Context, criteria exp.Exp
 		opt(optHandler)
 	}
 
-	sqlOptHandler := NewSqlQueryOptionHandler()
-	for _, opt := range optHandler.SqlOptions {
-		opt(sqlOptHandler)
+	ds, relations, err := r.newListDataset(ctx, criteria, optHandler)
+	if err != nil {
+		return res, err
 	}
 
-	var relations []string
-	if len(optHandler.Relations) > 0 {
-		for _, r := range optHandler.Relations {
-			relations = append(relations, r.Alias)
+	var proj *projection
+	if len(optHandler.Columns) > 0 {
+		if proj, err = newProjection[T](r.alias, optHandler.Columns); err != nil {
+			return res, err
 		}
-	}
-
-	ds := goqu.Dialect(sqlOptHandler.Dialect).Select(database.Sanitize(*new(T), database.WithPrefix(r.alias), database.WithRelations(relations...))...).
-		From(database.GetTableName(r.tableName).As(r.alias)).
-		Prepared(sqlOptHandler.Prepared)
-
-	ds = applyRelations(ds, optHandler.Relations)
-
-	if !criteria.IsEmpty() {
-		ds = ds.Where(criteria)
-	}
-
-	if len(optHandler.Sort) > 0 {
-		ds = ds.Order(optHandler.Sort...)
-	}
-
-	if optHandler.Limit > 0 {
-		ds = ds.Limit(uint(optHandler.Limit))
-	}
-
-	if optHandler.Offset > 0 {
-		ds = ds.Offset(uint(optHandler.Offset))
+		ds = ds.Select(proj.columns...)
 	}
 
 	sql, args, err := ds.ToSQL()
@@ -323,7 +564,12 @@ func (r *baseRepo[T, ID]) ListByExpression(ctx context.Context, criteria exp.Exp
 		return res, err
 	}
 
-	if err = r.db.Select(ctx, sql, args, &res, relations...); err != nil {
+	if proj != nil {
+		res, err = selectProjection[T](ctx, r.db, proj, sql, args)
+	} else {
+		err = r.db.Select(ctx, sql, args, &res, relations...)
+	}
+	if err != nil {
 		slog.ErrorContext(ctx, "Error during exec select",
 			slog.Any("error", err),
 			slog.String("table", r.tableName),
@@ -332,48 +578,72 @@ func (r *baseRepo[T, ID]) ListByExpression(ctx context.Context, criteria exp.Exp
 		return res, err
 	}
 
+	if err = r.Preload(ctx, res, optHandler.Preloads...); err != nil {
+		return res, err
+	}
+
 	return res, nil
 }
 
-// Delete удаление записи из таблицы
-func (r baseRepo[T, ID]) Delete(ctx context.Context, id ID, options ...SqlQueryOption) error {
-	isSoftDeleting := IsSoftDeletingEntity(*new(T))
-
-	if isSoftDeleting {
-		return r.SoftDelete(ctx, id, options...)
+// Preload загружает has-many и many-to-many связи для уже полученных сущностей
+// Для одной сущности после Get передается слайс из одного элемента
+func (r *baseRepo[T, ID]) Preload(ctx context.Context, entities []T, preloads ...ListOptionPreload) error {
+	if len(entities) == 0 || len(preloads) == 0 {
+		return nil
 	}
 
-	return r.ForceDelete(ctx, id, options...)
+	return applyPreloads(ctx, r.db, reflect.ValueOf(entities), preloads)
 }
 
-// ForceDelete прямое удаление из базы элемента
-func (r baseRepo[T, ID]) ForceDelete(ctx context.Context, id ID, options ...SqlQueryOption) error {
-
-	optHandler := NewSqlQueryOptionHandler()
+// Iterate последовательно передает в fn сущности по критерию, не загружая всю выборку в память
+// Preloads не применяются. Внутри fn нельзя выполнять запросы в той же транзакции, что и ctx
+func (r *baseRepo[T, ID]) Iterate(ctx context.Context, criteria map[string]any, fn func(T) error, options ...ListOption) error {
+	optHandler := NewListOptionHandler()
 	for _, opt := range options {
 		opt(optHandler)
 	}
-	ds := goqu.Dialect(optHandler.Dialect).Delete(r.tableName).
-		Where(goqu.C(r.idColumn).Eq(id)).
-		Prepared(optHandler.Prepared)
+
+	ds, relations, err := r.newListDataset(ctx, goqu.And(goqu.Ex(criteria)), optHandler)
+	if err != nil {
+		return err
+	}
+
+	var proj *projection
+	if len(optHandler.Columns) > 0 {
+		if proj, err = newProjection[T](r.alias, optHandler.Columns); err != nil {
+			return err
+		}
+		ds = ds.Select(proj.columns...)
+	}
 
 	sql, args, err := ds.ToSQL()
 	if err != nil {
-		slog.ErrorContext(ctx, "Cannot build SQL query for delete",
+		slog.ErrorContext(ctx, "Cannot build SQL query for iterate",
 			slog.Any("error", err),
 			slog.String("table", r.tableName),
 			slog.String("sql", sql),
 		)
-
 		return err
 	}
 
-	err = r.db.Exec(ctx, sql, args)
+	if proj != nil {
+		item := proj.newItem()
+		err = r.db.Stream(ctx, sql, args, item.Interface(), func() error {
+			var entity T
+			proj.copyTo(item.Elem(), reflect.ValueOf(&entity).Elem())
+			return fn(entity)
+		})
+	} else {
+		var entity T
+		err = r.db.Stream(ctx, sql, args, &entity, func() error {
+			return fn(entity)
+		}, relations...)
+	}
 	if err != nil {
-		slog.ErrorContext(ctx, "Error during exec delete",
+		slog.ErrorContext(ctx, "Error during exec iterate",
 			slog.Any("error", err),
 			slog.String("table", r.tableName),
-			slog.Any("id", id),
+			slog.Any("criteria", criteria),
 		)
 		return err
 	}
@@ -381,37 +651,38 @@ func (r baseRepo[T, ID]) ForceDelete(ctx context.Context, id ID, options ...SqlQ
 	return nil
 }
 
-// SoftDelete помечает сущность, как удаленную
-func (r baseRepo[T, ID]) SoftDelete(ctx context.Context, id ID, options ...SqlQueryOption) error {
-	optHandler := NewSqlQueryOptionHandler()
+// aggregate выполняет агрегирующий запрос по таблице репозитория и сканирует результат в dest - указатель на слайс структур
+func (r *baseRepo[T, ID]) aggregate(ctx context.Context, criteria exp.ExpressionList, dest any, options ...ListOption) error {
+	optHandler := NewListOptionHandler()
 	for _, opt := range options {
 		opt(optHandler)
 	}
 
-	ds := goqu.Dialect(optHandler.Dialect).Update(r.tableName).
-		Where(goqu.C(r.idColumn).Eq(id)).
-		Set(goqu.Record{
-			"deleted_at": time.Now(),
-		}).
-		Prepared(optHandler.Prepared)
+	if len(optHandler.Columns) == 0 {
+		return ErrNoColumns
+	}
+
+	ds, err := r.newSelectDataset(ctx, optHandler)
+	if err != nil {
+		return err
+	}
+	ds = r.applyListOptions(ds.Select(columnExpressions(r.alias, optHandler.Columns)...), criteria, optHandler)
 
 	sql, args, err := ds.ToSQL()
 	if err != nil {
-		slog.ErrorContext(ctx, "Cannot build SQL query for soft delete",
+		slog.ErrorContext(ctx, "Cannot build SQL query for aggregate",
 			slog.Any("error", err),
 			slog.String("table", r.tableName),
 			slog.String("sql", sql),
-			slog.Any("id", id),
 		)
 		return err
 	}
 
-	err = r.db.Exec(ctx, sql, args)
-	if err != nil {
-		slog.ErrorContext(ctx, "Error during exec soft delete",
+	if err = r.db.Select(ctx, sql, args, dest); err != nil {
+		slog.ErrorContext(ctx, "Error during exec aggregate",
 			slog.Any("error", err),
 			slog.String("table", r.tableName),
-			slog.Any("id", id),
+			slog.Any("criteria", criteria),
 		)
 		return err
 	}
@@ -419,46 +690,447 @@ func (r baseRepo[T, ID]) SoftDelete(ctx context.Context, id ID, options ...SqlQu
 	return nil
 }
 
-// SoftDeleteMultiple помечает пачку сущностей, как удаленные
-func (r *baseRepo[T, ID]) SoftDeleteMultiple(ctx context.Context, ids []ID) error {
-	ds := goqu.Update(r.tableName).
-		Where(goqu.C(r.idColumn).In(ids)).
-		Set(goqu.Record{
-			"deleted_at": time.Now(),
-		})
-
-	sql, args, err := ds.ToSQL()
+// ListPage возвращает страницу сущностей по выражению и общее количество записей, подходящих под выражение
+// Limit, Offset и Cursor влияют только на список, общее количество считается без них
+func (r *baseRepo[T, ID]) ListPage(ctx context.Context, criteria exp.ExpressionList, options ...ListOption) ([]T, int64, error) {
+	items, err := r.ListByExpression(ctx, criteria, options...)
 	if err != nil {
-		slog.ErrorContext(ctx, "Cannot build SQL query for multiple soft delete",
-			slog.Any("error", err),
-			slog.String("table", r.tableName),
-			slog.String("sql", sql),
-			slog.Any("ids", ids),
-		)
-		return err
+		return nil, 0, err
 	}
 
-	err = r.db.Exec(ctx, sql, args)
+	total, err := r.CountByExpression(ctx, criteria, options...)
 	if err != nil {
-		slog.ErrorContext(ctx, "Error during exec multiple soft delete",
-			slog.Any("error", err),
-			slog.String("table", r.tableName),
-			slog.Any("ids", ids),
-		)
-		return err
+		return nil, 0, err
 	}
 
-	return nil
+	return items, total, nil
 }
 
-// DeleteBy удаление записей из таблицы по заданному критерию
-func (r baseRepo[T, ID]) DeleteBy(ctx context.Context, criteria map[string]any, options ...SqlQueryOption) error {
-	isSoftDeleting := IsSoftDeletingEntity(*new(T))
-
-	if isSoftDeleting {
-		return r.BulkUpdate(ctx, map[string]any{
+// CountByExpression возвращает количество сущностей по выражению
+// Учитываются связи, sql опции, DistinctOn, GroupBy и Having, сортировка, курсор и пагинация игнорируются
+func (r *baseRepo[T, ID]) CountByExpression(ctx context.Context, criteria exp.ExpressionList, options ...ListOption) (int64, error) {
+	optHandler := NewListOptionHandler()
+	for _, opt := range options {
+		opt(optHandler)
+	}
+
+	ds, err := r.newSelectDataset(ctx, optHandler)
+	if err != nil {
+		return 0, err
+	}
+
+	if !criteria.IsEmpty() {
+		ds = ds.Where(criteria)
+	}
+
+	if len(optHandler.DistinctOn) > 0 || len(optHandler.GroupBy) > 0 || len(optHandler.Having) > 0 {
+		// строк в списке столько же, сколько групп, поэтому считаем строки подзапроса, а не таблицы
+		inner := ds.Select(goqu.L("1"))
+		if len(optHandler.DistinctOn) > 0 {
+			inner = inner.Distinct(columnExpressions(r.alias, optHandler.DistinctOn)...)
+		}
+		if len(optHandler.GroupBy) > 0 {
+			inner = inner.GroupBy(columnExpressions(r.alias, optHandler.GroupBy)...)
+		}
+		if len(optHandler.Having) > 0 {
+			inner = inner.Having(optHandler.Having...)
+		}
+
+		ds = goqu.From(inner.As("counted")).SetDialect(ds.Dialect()).Prepared(ds.IsPrepared())
+	}
+	ds = ds.Select(goqu.COUNT(goqu.Star()))
+
+	sql, args, err := ds.ToSQL()
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot build SQL query for count",
+			slog.Any("error", err),
+			slog.String("table", r.tableName),
+			slog.String("sql", sql),
+		)
+		return 0, err
+	}
+
+	count, err := r.db.Count(ctx, sql, args)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error during exec count",
+			slog.Any("error", err),
+			slog.String("table", r.tableName),
+			slog.Any("criteria", criteria),
+		)
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// newListDataset возвращает SELECT запрос списка сущностей по выражению с учетом всех опций и алиасы связей для сканирования
+func (r *baseRepo[T, ID]) newListDataset(ctx context.Context, criteria exp.ExpressionList, optHandler *ListOptionHandler) (*goqu.SelectDataset, []string, error) {
+	var relations []string
+	if len(optHandler.Relations) > 0 {
+		for _, r := range optHandler.Relations {
+			relations = append(relations, r.Alias)
+		}
+	}
+
+	ds, err := r.newSelectDataset(ctx, optHandler)
+	if err != nil {
+		return nil, nil, err
+	}
+	ds = ds.Select(database.Sanitize(*new(T), database.WithPrefix(r.alias), database.WithRelations(relations...))...)
+
+	ds, err = r.applyLock(ctx, r.applyListOptions(ds, criteria, optHandler), optHandler)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ds, relations, nil
+}
+
+// applyLock добавляет к запросу блокировку строк, если она задана опциями и поддерживается диалектом базы
+// Блокировка без транзакции в контексте снялась бы сразу после запроса, поэтому в этом случае возвращается ошибка
+func (r *baseRepo[T, ID]) applyLock(ctx context.Context, ds *goqu.SelectDataset, optHandler *ListOptionHandler) (*goqu.SelectDataset, error) {
+	if optHandler.Lock == exp.ForNolock {
+		return ds, nil
+	}
+
+	if _, ok := ctx.Value(database.CtxDbTxKey).(pgx.Tx); !ok {
+		return nil, ErrRowLockOutsideTx
+	}
+
+	// в SQLite блокировок строк нет, транзакция там и так блокирует всю базу
+	if !database.SupportsRowLocks(r.db) {
+		return ds, nil
+	}
+
+	// строки присоединенных через LEFT JOIN связей postgres блокировать не дает
+	var of []exp.IdentifierExpression
+	if len(optHandler.Relations) > 0 {
+		of = append(of, goqu.T(r.alias))
+	}
+
+	switch optHandler.Lock {
+	case exp.ForNoKeyUpdate:
+		return ds.ForNoKeyUpdate(optHandler.LockWait, of...), nil
+	case exp.ForShare:
+		return ds.ForShare(optHandler.LockWait, of...), nil
+	default:
+		return ds.ForUpdate(optHandler.LockWait, of...), nil
+	}
+}
+
+// applyListOptions добавляет к запросу критерий, группировку, курсор, сортировку и пагинацию
+func (r *baseRepo[T, ID]) applyListOptions(ds *goqu.SelectDataset, criteria exp.ExpressionList, optHandler *ListOptionHandler) *goqu.SelectDataset {
+	if !criteria.IsEmpty() {
+		ds = ds.Where(criteria)
+	}
+
+	if len(optHandler.DistinctOn) > 0 {
+		ds = ds.Distinct(columnExpressions(r.alias, optHandler.DistinctOn)...)
+	}
+
+	if len(optHandler.GroupBy) > 0 {
+		ds = ds.GroupBy(columnExpressions(r.alias, optHandler.GroupBy)...)
+	}
+
+	if len(optHandler.Having) > 0 {
+		ds = ds.Having(optHandler.Having...)
+	}
+
+	if cursor := optHandler.Cursor; cursor != nil {
+		// колонка без алиаса относится к таблице репозитория, иначе при связях она неоднозначна
+		col := columnIdentifier(r.alias, cursor.Column)
+		if cursor.Value != nil {
+			if cursor.Desc {
+				ds = ds.Where(col.Lt(cursor.Value))
+			} else {
+				ds = ds.Where(col.Gt(cursor.Value))
+			}
+		}
+
+		if cursor.Desc {
+			ds = ds.Order(col.Desc())
+		} else {
+			ds = ds.Order(col.Asc())
+		}
+	}
+
+	if len(optHandler.Sort) > 0 {
+		ds = ds.OrderAppend(optHandler.Sort...)
+	}
+
+	if optHandler.Limit > 0 {
+		ds = ds.Limit(uint(optHandler.Limit))
+	}
+
+	if optHandler.Offset > 0 {
+		ds = ds.Offset(uint(optHandler.Offset))
+	}
+
+	return ds
+}
+
+// newSelectDataset возвращает SELECT запрос по таблице репозитория с примененными связями и sql опциями
+// Для soft удаляемых сущностей добавляется условие по deleted_at в зависимости от TrashedScope,
+// для сущностей с арендатором - условие по арендатору из контекста
+func (r *baseRepo[T, ID]) newSelectDataset(ctx context.Context, optHandler *ListOptionHandler) (*goqu.SelectDataset, error) {
+	sqlOptHandler := NewSqlQueryOptionHandler()
+	for _, opt := range optHandler.SqlOptions {
+		opt(sqlOptHandler)
+	}
+
+	tenantWhere, err := r.tenantWhere(ctx, r.alias)
+	if err != nil {
+		return nil, err
+	}
+
+	ds := r.dialect(sqlOptHandler).
+		From(database.GetTableName(r.tableName).As(r.alias)).
+		Where(tenantWhere...).
+		Prepared(sqlOptHandler.Prepared)
+
+	if IsSoftDeletingEntity(*new(T)) {
+		scope := optHandler.Trashed
+		if scope == TrashedExclude {
+			if ctxScope, ok := ctx.Value(CtxTrashedScope).(TrashedScope); ok {
+				scope = ctxScope
+			}
+		}
+
+		deletedAt := goqu.I(r.alias + ".deleted_at")
+		switch scope {
+		case TrashedExclude:
+			ds = ds.Where(deletedAt.IsNull())
+		case TrashedOnly:
+			ds = ds.Where(deletedAt.IsNotNull())
+		}
+	}
+
+	return applyRelations(ds, optHandler.Relations), nil
+}
+
+// Delete удаление записи из таблицы
+func (r baseRepo[T, ID]) Delete(ctx context.Context, id ID, options ...SqlQueryOption) error {
+	isSoftDeleting := IsSoftDeletingEntity(*new(T))
+
+	if isSoftDeleting {
+		return r.SoftDelete(ctx, id, options...)
+	}
+
+	return r.ForceDelete(ctx, id, options...)
+}
+
+// ForceDelete прямое удаление из базы элемента
+func (r baseRepo[T, ID]) ForceDelete(ctx context.Context, id ID, options ...SqlQueryOption) error {
+	return r.withDeleteHooks(ctx, goqu.And(goqu.I(r.aliasedIdColumn()).Eq(id)), func(ctx context.Context) error {
+		return r.forceDelete(ctx, id, options...)
+	})
+}
+
+func (r baseRepo[T, ID]) forceDelete(ctx context.Context, id ID, options ...SqlQueryOption) error {
+	optHandler := NewSqlQueryOptionHandler()
+	for _, opt := range options {
+		opt(optHandler)
+	}
+	tenantWhere, err := r.tenantWhere(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	ds := r.dialect(optHandler).Delete(r.tableName).
+		Where(goqu.C(r.idColumn).Eq(id)).
+		Where(tenantWhere...).
+		Prepared(optHandler.Prepared)
+
+	sql, args, err := ds.ToSQL()
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot build SQL query for delete",
+			slog.Any("error", err),
+			slog.String("table", r.tableName),
+			slog.String("sql", sql),
+		)
+
+		return err
+	}
+
+	err = r.db.Exec(ctx, sql, args)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error during exec delete",
+			slog.Any("error", err),
+			slog.String("table", r.tableName),
+			slog.Any("id", id),
+		)
+		return err
+	}
+
+	return nil
+}
+
+// SoftDelete помечает сущность, как удаленную
+func (r baseRepo[T, ID]) SoftDelete(ctx context.Context, id ID, options ...SqlQueryOption) error {
+	return r.withDeleteHooks(ctx, goqu.And(goqu.I(r.aliasedIdColumn()).Eq(id)), func(ctx context.Context) error {
+		return r.softDelete(ctx, id, options...)
+	})
+}
+
+func (r baseRepo[T, ID]) softDelete(ctx context.Context, id ID, options ...SqlQueryOption) error {
+	optHandler := NewSqlQueryOptionHandler()
+	for _, opt := range options {
+		opt(optHandler)
+	}
+
+	tenantWhere, err := r.tenantWhere(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	ds := r.dialect(optHandler).Update(r.tableName).
+		Where(goqu.C(r.idColumn).Eq(id)).
+		Where(tenantWhere...).
+		Set(goqu.Record{
 			"deleted_at": time.Now(),
-		}, criteria, options...)
+		}).
+		Prepared(optHandler.Prepared)
+
+	sql, args, err := ds.ToSQL()
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot build SQL query for soft delete",
+			slog.Any("error", err),
+			slog.String("table", r.tableName),
+			slog.String("sql", sql),
+			slog.Any("id", id),
+		)
+		return err
+	}
+
+	err = r.db.Exec(ctx, sql, args)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error during exec soft delete",
+			slog.Any("error", err),
+			slog.String("table", r.tableName),
+			slog.Any("id", id),
+		)
+		return err
+	}
+
+	return nil
+}
+
+// SoftDeleteMultiple помечает пачку сущностей, как удаленные
+func (r *baseRepo[T, ID]) SoftDeleteMultiple(ctx context.Context, ids []ID) error {
+	return r.withDeleteHooks(ctx, goqu.And(goqu.I(r.aliasedIdColumn()).In(ids)), func(ctx context.Context) error {
+		return r.softDeleteMultiple(ctx, ids)
+	})
+}
+
+func (r *baseRepo[T, ID]) softDeleteMultiple(ctx context.Context, ids []ID) error {
+	tenantWhere, err := r.tenantWhere(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	ds := r.db.Dialect().Update(r.tableName).
+		Where(goqu.C(r.idColumn).In(ids)).
+		Where(tenantWhere...).
+		Set(goqu.Record{
+			"deleted_at": time.Now(),
+		})
+
+	sql, args, err := ds.ToSQL()
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot build SQL query for multiple soft delete",
+			slog.Any("error", err),
+			slog.String("table", r.tableName),
+			slog.String("sql", sql),
+			slog.Any("ids", ids),
+		)
+		return err
+	}
+
+	err = r.db.Exec(ctx, sql, args)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error during exec multiple soft delete",
+			slog.Any("error", err),
+			slog.String("table", r.tableName),
+			slog.Any("ids", ids),
+		)
+		return err
+	}
+
+	return nil
+}
+
+// Restore восстанавливает soft удаленную сущность, для отсутствующей сущности возвращает pgx.ErrNoRows
+func (r baseRepo[T, ID]) Restore(ctx context.Context, id ID, options ...SqlQueryOption) error {
+	if !IsSoftDeletingEntity(*new(T)) {
+		return ErrNotSoftDeletingEntity
+	}
+
+	optHandler := NewSqlQueryOptionHandler()
+	for _, opt := range options {
+		opt(optHandler)
+	}
+
+	tenantWhere, err := r.tenantWhere(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	ds := r.dialect(optHandler).Update(r.tableName).
+		Where(goqu.C(r.idColumn).Eq(id)).
+		Where(tenantWhere...).
+		Set(goqu.Record{
+			"deleted_at": nil,
+		}).
+		Prepared(optHandler.Prepared)
+
+	sql, args, err := ds.ToSQL()
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot build SQL query for restore",
+			slog.Any("error", err),
+			slog.String("table", r.tableName),
+			slog.String("sql", sql),
+			slog.Any("id", id),
+		)
+		return err
+	}
+
+	affected, err := r.db.ExecAffected(ctx, sql, args)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error during exec restore",
+			slog.Any("error", err),
+			slog.String("table", r.tableName),
+			slog.Any("id", id),
+		)
+		return err
+	}
+	if affected == 0 {
+		return r.missingRowError(ctx, id, fmt.Errorf("%w: table %s, id %v", pgx.ErrNoRows, r.tableName, id))
+	}
+
+	return nil
+}
+
+// RestoreBy восстанавливает soft удаленные записи по заданному критерию
+func (r baseRepo[T, ID]) RestoreBy(ctx context.Context, criteria map[string]any, options ...SqlQueryOption) error {
+	if !IsSoftDeletingEntity(*new(T)) {
+		return ErrNotSoftDeletingEntity
+	}
+
+	return r.BulkUpdate(ctx, map[string]any{
+		"deleted_at": nil,
+	}, criteria, options...)
+}
+
+// DeleteBy удаление записей из таблицы по заданному критерию
+func (r baseRepo[T, ID]) DeleteBy(ctx context.Context, criteria map[string]any, options ...SqlQueryOption) error {
+	isSoftDeleting := IsSoftDeletingEntity(*new(T))
+
+	if isSoftDeleting {
+		return r.withDeleteHooks(ctx, goqu.And(goqu.Ex(criteria)), func(ctx context.Context) error {
+			return r.BulkUpdate(ctx, map[string]any{
+				"deleted_at": time.Now(),
+			}, criteria, options...)
+		})
 	}
 
 	return r.ForceDeleteBy(ctx, criteria, options...)
@@ -466,12 +1138,24 @@ func (r baseRepo[T, ID]) DeleteBy(ctx context.Context, criteria map[string]any,
 
 // ForceDeleteBy прямое удаление из базы записей по заданному критерию
 func (r baseRepo[T, ID]) ForceDeleteBy(ctx context.Context, criteria map[string]any, options ...SqlQueryOption) error {
+	return r.withDeleteHooks(ctx, goqu.And(goqu.Ex(criteria)), func(ctx context.Context) error {
+		return r.forceDeleteBy(ctx, criteria, options...)
+	})
+}
+
+func (r baseRepo[T, ID]) forceDeleteBy(ctx context.Context, criteria map[string]any, options ...SqlQueryOption) error {
 	optHandler := NewSqlQueryOptionHandler()
 	for _, opt := range options {
 		opt(optHandler)
 	}
 
-	ds := goqu.Dialect(optHandler.Dialect).Delete(r.tableName).
+	tenantWhere, err := r.tenantWhere(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	ds := r.dialect(optHandler).Delete(r.tableName).
+		Where(tenantWhere...).
 		Prepared(optHandler.Prepared)
 
 	if len(criteria) > 0 {
@@ -506,6 +1190,40 @@ func (r baseRepo[T, ID]) CreateMultiple(ctx context.Context, entities []T, optio
 		return nil, nil
 	}
 
+	if !hasCreateHooks[T, ID]() {
+		return r.createMultiple(ctx, entities, options...)
+	}
+
+	var res []ID
+	err := r.db.RunInTx(ctx, func(ctx context.Context) error {
+		entities := slices.Clone(entities)
+		for i := range entities {
+			if err := BeforeCreate(ctx, &entities[i]); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		if res, err = r.createMultiple(ctx, entities, options...); err != nil {
+			return err
+		}
+
+		for i := range entities {
+			if err = AfterCreate(ctx, &entities[i], res[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (r baseRepo[T, ID]) createMultiple(ctx context.Context, entities []T, options ...SqlQueryOption) ([]ID, error) {
 	optHandler := NewSqlQueryOptionHandler()
 	for _, opt := range options {
 		opt(optHandler)
@@ -514,9 +1232,49 @@ func (r baseRepo[T, ID]) CreateMultiple(ctx context.Context, entities []T, optio
 	var records []any
 	for _, entity := range entities {
 		_, rows := SanitizeRowsForInsert[ID](entity)
+		if err := r.setTenant(ctx, rows); err != nil {
+			return nil, err
+		}
 		records = append(records, rows)
 	}
-	ds := goqu.Dialect(optHandler.Dialect).Insert(r.tableName).
+
+	// в prepared запросе postgres ограничивает количество параметров, поэтому разбиваем вставку на части
+	chunkSize := len(records)
+	if optHandler.Prepared {
+		if cols := len(records[0].(map[string]any)); cols > 0 && chunkSize*cols > maxQueryParams {
+			chunkSize = maxQueryParams / cols
+		}
+	}
+
+	if chunkSize == len(records) {
+		return r.createChunk(ctx, records, optHandler)
+	}
+
+	var res []ID
+	err := r.db.RunInTx(ctx, func(ctx context.Context) error {
+		for start := 0; start < len(records); start += chunkSize {
+			end := min(start+chunkSize, len(records))
+
+			ids, err := r.createChunk(ctx, records[start:end], optHandler)
+			if err != nil {
+				return err
+			}
+
+			res = append(res, ids...)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// createChunk вставляет одну часть записей одним запросом
+func (r baseRepo[T, ID]) createChunk(ctx context.Context, records []any, optHandler *SqlQueryOptionHandler) ([]ID, error) {
+	ds := r.dialect(optHandler).Insert(r.tableName).
 		Returning(goqu.C(r.idColumn)).
 		Rows(records...).
 		Prepared(optHandler.Prepared)
@@ -545,12 +1303,110 @@ func (r baseRepo[T, ID]) CreateMultiple(ctx context.Context, entities []T, optio
 	return res, nil
 }
 
+// BulkInsert загружает сущности в таблицу через COPY и возвращает количество добавленных строк
+// Подходит для больших импортов, id добавленных записей не возвращаются, поэтому AfterCreate не вызывается
+func (r baseRepo[T, ID]) BulkInsert(ctx context.Context, entities []T) (int64, error) {
+	if len(entities) == 0 {
+		return 0, nil
+	}
+
+	if _, ok := any(new(T)).(BeforeCreateHook); !ok {
+		return r.bulkInsert(ctx, entities)
+	}
+
+	var count int64
+	err := r.db.RunInTx(ctx, func(ctx context.Context) error {
+		entities := slices.Clone(entities)
+		for i := range entities {
+			if err := BeforeCreate(ctx, &entities[i]); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		count, err = r.bulkInsert(ctx, entities)
+		return err
+	})
+
+	return count, err
+}
+
+func (r baseRepo[T, ID]) bulkInsert(ctx context.Context, entities []T) (int64, error) {
+	now := time.Now()
+
+	var columns []string
+	values := make([][]any, 0, len(entities))
+	for _, entity := range entities {
+		_, rows := SanitizeRows[ID](entity)
+		if err := r.setTenant(ctx, rows); err != nil {
+			return 0, err
+		}
+		for _, tsField := range []string{"created_at", "updated_at"} {
+			if _, ok := rows[tsField]; ok {
+				rows[tsField] = now
+			}
+		}
+
+		if columns == nil {
+			for column := range rows {
+				columns = append(columns, column)
+			}
+			sort.Strings(columns)
+		}
+
+		row := make([]any, len(columns))
+		for i, column := range columns {
+			row[i] = rows[column]
+		}
+		values = append(values, row)
+	}
+
+	count, err := r.db.CopyFrom(ctx, r.tableName, columns, values)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error during exec bulk insert",
+			slog.Any("error", err),
+			slog.String("table", r.tableName),
+			slog.Int("count", len(values)),
+		)
+		return count, err
+	}
+
+	return count, nil
+}
+
 // UpdateMultiple обновляет несколько сущностей
 func (r *baseRepo[T, ID]) UpdateMultiple(ctx context.Context, entities []T, options ...SqlQueryOption) error {
 	if len(entities) == 0 {
 		return nil
 	}
 
+	if !hasUpdateHooks[T]() {
+		return r.updateMultiple(ctx, entities, options...)
+	}
+
+	return r.db.RunInTx(ctx, func(ctx context.Context) error {
+		entities := slices.Clone(entities)
+		for i := range entities {
+			if err := BeforeUpdate(ctx, &entities[i]); err != nil {
+				return err
+			}
+		}
+
+		if err := r.updateMultiple(ctx, entities, options...); err != nil {
+			return err
+		}
+
+		for i := range entities {
+			if err := AfterUpdate(ctx, &entities[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (r *baseRepo[T, ID]) updateMultiple(ctx context.Context, entities []T, options ...SqlQueryOption) error {
 	var records []any
 
 	optHandler := NewSqlQueryOptionHandler()
@@ -559,9 +1415,33 @@ func (r *baseRepo[T, ID]) UpdateMultiple(ctx context.Context, entities []T, opti
 	}
 
 	conflictTarget, updateFields := BuildConflictUpdate(entities[0])
+	table := database.GetTableName(r.tableName).GetTable()
+
+	// строку другого арендатора с тем же conflict_target не обновляем
+	tenantWhere, err := r.tenantWhere(ctx, table)
+	if err != nil {
+		return err
+	}
+	tenantScoped := len(tenantWhere) > 0
+
+	// при оптимистичной блокировке обновляем только строки с совпадающей версией
+	versionColumn, _, versioned := GetEntityVersion(entities[0])
+	if versioned {
+		updateFields[versionColumn] = goqu.L("? + 1", goqu.C(versionColumn).Table(table))
+	}
+	conflictExpression := goqu.DoUpdate(conflictTarget, updateFields)
+	if versioned {
+		conflictExpression = conflictExpression.Where(goqu.C(versionColumn).Table(table).Eq(goqu.C(versionColumn).Table("excluded")))
+	}
+	if tenantScoped {
+		conflictExpression = conflictExpression.Where(tenantWhere...)
+	}
 
 	for _, entity := range entities {
 		id, rows := SanitizeRowsForInsert[ID](entity)
+		if err = r.setTenant(ctx, rows); err != nil {
+			return err
+		}
 
 		// оставляем ID колонку только в случае, когда она является целью конфликта
 		if conflictTarget == r.idColumn {
@@ -572,9 +1452,9 @@ func (r *baseRepo[T, ID]) UpdateMultiple(ctx context.Context, entities []T, opti
 	}
 
 	//т.к. goqu не поддерживает postgresql update from values юзаем insert on conflict update
-	ds := goqu.Dialect(optHandler.Dialect).Insert(r.tableName).
+	ds := r.dialect(optHandler).Insert(r.tableName).
 		Rows(records...).
-		OnConflict(goqu.DoUpdate(conflictTarget, updateFields)).
+		OnConflict(conflictExpression).
 		Prepared(optHandler.Prepared)
 
 	sql, args, err := ds.ToSQL()
@@ -588,7 +1468,16 @@ func (r *baseRepo[T, ID]) UpdateMultiple(ctx context.Context, entities []T, opti
 		return err
 	}
 
-	err = r.db.Exec(ctx, sql, args)
+	if !versioned && !tenantScoped {
+		err = r.db.Exec(ctx, sql, args)
+	} else {
+		var affected int64
+		affected, err = r.db.ExecAffected(ctx, sql, args)
+		if err == nil && affected < int64(len(records)) {
+			return r.multipleUpdateError(ctx, conflictTarget, records, affected, versioned, optHandler)
+		}
+	}
+
 	if err != nil {
 		slog.ErrorContext(ctx, "Error during exec multiple update",
 			slog.Any("error", err),
@@ -601,10 +1490,80 @@ func (r *baseRepo[T, ID]) UpdateMultiple(ctx context.Context, entities []T, opti
 	return nil
 }
 
+// multipleUpdateError возвращает причину, по которой обновлены не все записи: ErrTenantMismatch,
+// если запись с тем же conflict_target принадлежит другому арендатору, иначе ErrStaleEntity
+func (r *baseRepo[T, ID]) multipleUpdateError(ctx context.Context, conflictTarget string, records []any, affected int64, versioned bool, optHandler *SqlQueryOptionHandler) error {
+	stale := fmt.Errorf("%w: table %s, %d of %d rows updated", ErrStaleEntity, r.tableName, affected, len(records))
+	mismatch := fmt.Errorf("%w: table %s, %d of %d rows updated", ErrTenantMismatch, r.tableName, affected, len(records))
+
+	column, tenant, scoped, err := TenantScope(ctx, reflect.TypeFor[T]())
+	if err != nil {
+		return err
+	}
+	if !scoped {
+		return stale
+	}
+	// без версии запись не обновляется только из-за другого арендатора
+	if !versioned {
+		return mismatch
+	}
+
+	keys := make([]any, 0, len(records))
+	for _, record := range records {
+		keys = append(keys, record.(map[string]any)[conflictTarget])
+	}
+
+	ds := r.dialect(optHandler).From(r.tableName).
+		Select(goqu.COUNT(goqu.Star())).
+		Where(
+			goqu.C(conflictTarget).In(keys),
+			goqu.Or(goqu.C(column).Neq(tenant), goqu.C(column).IsNull()),
+		).
+		Prepared(optHandler.Prepared)
+
+	sql, args, err := ds.ToSQL()
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot build SQL query for multiple update tenant check",
+			slog.Any("error", err),
+			slog.String("table", r.tableName),
+			slog.String("sql", sql),
+		)
+		return err
+	}
+
+	// записи только что изменены, на репликах их может еще не быть
+	ctx = context.WithValue(ctx, database.CtxDbForcePrimaryKey, true)
+	foreign, err := r.db.Count(ctx, sql, args)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error during exec multiple update tenant check",
+			slog.Any("error", err),
+			slog.String("table", r.tableName),
+		)
+		return err
+	}
+	if foreign > 0 {
+		return mismatch
+	}
+
+	return stale
+}
+
 // ForceDeleteMultiple прямое удаление множества сущностей по ids
 func (r *baseRepo[T, ID]) ForceDeleteMultiple(ctx context.Context, ids []ID) error {
-	ds := goqu.Delete(r.tableName).
-		Where(goqu.C(r.idColumn).In(ids))
+	return r.withDeleteHooks(ctx, goqu.And(goqu.I(r.aliasedIdColumn()).In(ids)), func(ctx context.Context) error {
+		return r.forceDeleteMultiple(ctx, ids)
+	})
+}
+
+func (r *baseRepo[T, ID]) forceDeleteMultiple(ctx context.Context, ids []ID) error {
+	tenantWhere, err := r.tenantWhere(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	ds := r.db.Dialect().Delete(r.tableName).
+		Where(goqu.C(r.idColumn).In(ids)).
+		Where(tenantWhere...)
 
 	sql, args, err := ds.ToSQL()
 	if err != nil {
@@ -629,9 +1588,107 @@ func (r *baseRepo[T, ID]) ForceDeleteMultiple(ctx context.Context, ids []ID) err
 	return nil
 }
 
-// DeleteAndMoveReferences необходимо переопределить в репозитории, если необходимо перемещать ссылки
+// DeleteAndMoveReferences переносит все ссылки внешних ключей с id на newId и удаляет сущность id
+// Ссылающиеся таблицы определяются по pg_constraint. Строки, которые после переноса нарушили бы
+// уникальный индекс, удаляются как дубли. Все выполняется в одной транзакции
 func (r *baseRepo[T, ID]) DeleteAndMoveReferences(ctx context.Context, id ID, newId ID) error {
-	return r.Delete(ctx, id)
+	if id == newId {
+		return fmt.Errorf("%w: table %s, id %v", ErrMoveReferencesToSelf, r.tableName, id)
+	}
+
+	return r.db.RunInTx(ctx, func(ctx context.Context) error {
+		// ссылающиеся таблицы не ограничены арендатором, поэтому обе сущности должны принадлежать арендатору из контекста
+		_, _, scoped, err := TenantScope(ctx, reflect.TypeFor[T]())
+		if err != nil {
+			return err
+		}
+		if scoped {
+			count, err := r.CountByExpression(ctx, goqu.And(goqu.I(r.aliasedIdColumn()).In([]ID{id, newId})), WithTrashed())
+			if err != nil {
+				return err
+			}
+			if count < 2 {
+				return fmt.Errorf("%w: table %s, id %v or %v", ErrTenantMismatch, r.tableName, id, newId)
+			}
+		}
+
+		// переносятся только ссылки на id, ключи на другие уникальные колонки таблицы не затрагиваются
+		idColumn := r.idColumn[strings.LastIndex(r.idColumn, ".")+1:]
+		refs, err := database.GetReferencingForeignKeys(ctx, r.db, r.tableName, idColumn)
+		if err != nil {
+			slog.ErrorContext(ctx, "Cannot get referencing foreign keys",
+				slog.Any("error", err),
+				slog.String("table", r.tableName),
+			)
+			return err
+		}
+
+		for _, ref := range refs {
+			if err = r.moveReference(ctx, ref, id, newId); err != nil {
+				slog.ErrorContext(ctx, "Error during move references",
+					slog.Any("error", err),
+					slog.String("table", r.tableName),
+					slog.String("ref_table", ref.Schema+"."+ref.Table),
+					slog.String("ref_column", ref.Column),
+					slog.Any("id", id),
+					slog.Any("new_id", newId),
+				)
+				return err
+			}
+		}
+
+		return r.Delete(ctx, id)
+	})
+}
+
+// moveReference переносит ссылки одного внешнего ключа с id на newId, предварительно удаляя будущие дубли
+func (r *baseRepo[T, ID]) moveReference(ctx context.Context, ref database.ForeignKeyReference, id ID, newId ID) error {
+	refTable := goqu.T(ref.Table).Schema(ref.Schema)
+
+	indexes, err := database.GetUniqueIndexes(ctx, r.db, pgx.Identifier{ref.Schema, ref.Table}.Sanitize(), ref.Column)
+	if err != nil {
+		return err
+	}
+
+	for _, index := range indexes {
+		exists := goqu.From(refTable.As("dst")).
+			Select(goqu.L("1")).
+			Where(goqu.I("dst." + ref.Column).Eq(newId))
+
+		for _, column := range index.Columns {
+			if column == ref.Column {
+				continue
+			}
+			// уникальный индекс считает NULL различными, поэтому строки с NULL дублями не являются
+			exists = exists.Where(goqu.I("dst." + column).Eq(goqu.C(column).Table(ref.Table)))
+		}
+
+		ds := goqu.Dialect("postgres").Delete(refTable).
+			Where(
+				goqu.C(ref.Column).Table(ref.Table).Eq(id),
+				goqu.L("EXISTS ?", exists),
+			)
+
+		sql, args, err := ds.ToSQL()
+		if err != nil {
+			return err
+		}
+
+		if err = r.db.Exec(ctx, sql, args); err != nil {
+			return err
+		}
+	}
+
+	ds := goqu.Dialect("postgres").Update(refTable).
+		Set(goqu.Record{ref.Column: newId}).
+		Where(goqu.C(ref.Column).Eq(id))
+
+	sql, args, err := ds.ToSQL()
+	if err != nil {
+		return err
+	}
+
+	return r.db.Exec(ctx, sql, args)
 }
 
 func applyRelations(ds *goqu.SelectDataset, relations []ListOptionRelation) *goqu.SelectDataset {
@@ -2,7 +2,6 @@ package repo
 
 import (
 	"reflect"
-	"time"
 
 	"github.com/doug-martin/goqu/v9"
 
@@ -42,8 +41,9 @@ func BuildConflictUpdate(entity any) (string, map[string]any) {
 			continue
 		}
 
+		// время берется из базы, как в WithDefaultTimestamps
 		if dbFieldName == "updated_at" {
-			updateFields[dbFieldName] = time.Now()
+			updateFields[dbFieldName] = goqu.L("now()")
 			continue
 		}
 
@@ -1,16 +1,15 @@
 package repo
 
 import (
+	"errors"
+	"fmt"
 	"reflect"
 
 	"github.com/doug-martin/goqu/v9"
-)
 
-// TODO everyHotel
-//  Здесь поддерживаем два вараинта обработки вложенных структур
-//  - через inner_struct
-//  - через embedded_struct
-//  Желательно потом привести это все к одному знаменателю
+	"github.com/EveryHotel/core-tools/pkg/database"
+	"github.com/EveryHotel/core-tools/pkg/types"
+)
 
 // SanitizeRowsForInsert возвращает объект с полями для добавления сущности
 func SanitizeRowsForInsert[ID int64 | string](entity any) (ID, map[string]any) {
@@ -26,6 +25,7 @@ func SanitizeRowsForUpdate[ID int64 | string](entity any) (ID, map[string]any) {
 	opts := []SanitizeRowsOption{
 		WithSkippingFields("created_at"),
 		WithDefaultTimestamps("updated_at"),
+		WithVersionIncrement(),
 	}
 
 	return SanitizeRows[ID](entity, opts...)
@@ -40,6 +40,9 @@ func SanitizeRowsForUpdateMultiple[ID int64 | string](entity interface{}) (ID, m
 	return SanitizeRows[ID](entity, opts...)
 }
 
+// ErrInvalidPatch patch для Patch не является структурой
+var ErrInvalidPatch = errors.New("patch must be a struct")
+
 type SanitizeRowsOption func(*sanitizeRowsHandler)
 
 // SanitizeRows возвращает объект с полями для добавления сущности
@@ -52,49 +55,27 @@ func SanitizeRows[ID int64 | string](entity any, opts ...SanitizeRowsOption) (ID
 	vEntity := reflect.ValueOf(entity)
 
 	var primary ID
-	rows := map[string]any{}
-	for i := 0; i < vEntity.NumField(); i++ {
-		tag := vEntity.Type().Field(i).Tag
-
-		embeddedStruct := tag.Get("embedded_struct")
-		if embeddedStruct == "1" {
-			embeddedEntity := vEntity.Field(i).Interface()
-			_, embeddedRows := SanitizeRows[ID](embeddedEntity, opts...)
-			for key, val := range embeddedRows {
-				rows[key] = val
-			}
-
-			continue
-		}
-
-		// если это вложенная структура, мы собираем отдельно все ее поля и добавляем в общий список
-		if innerStruct := tag.Get("inner_struct"); innerStruct != "" {
-			innerPrimary, innerRows := SanitizeRows[ID](vEntity.Field(i).Interface(), opts...)
-			for k, v := range innerRows {
-				rows[k] = v
+	fields := database.StructFields(vEntity.Type())
+	rows := make(map[string]any, len(fields))
+	for _, field := range fields {
+		if field.Primary {
+			// primary из embedded_struct не является первичным ключом сущности
+			if !field.Embedded {
+				primary = vEntity.FieldByIndex(field.Index).Interface().(ID)
 			}
 
-			primary = innerPrimary
-
-			continue
-		}
-
-		dbFieldName := tag.Get("db")
-		if dbFieldName == "" {
-			continue
-		}
-
-		if pkTag := tag.Get("primary"); pkTag != "" {
-			reflect.TypeOf(primary)
-			primary = vEntity.Field(i).Interface().(ID)
 			// если поле помечено как НЕ автоинкрементное, оставляем его в списке
-			if nsTag := tag.Get("not_serial"); nsTag == "" {
+			if !field.NotSerial {
 				continue
 			}
 		}
 
-		if _, ok := handler.SkippingFields[dbFieldName]; !ok {
-			rows[dbFieldName] = vEntity.Field(i).Interface()
+		if _, ok := handler.SkippingFields[field.Column]; !ok {
+			rows[field.Column] = vEntity.FieldByIndex(field.Index).Interface()
+
+			if handler.IncrementVersion && isVersionField(field.Tag) {
+				rows[field.Column] = goqu.L("? + 1", goqu.C(field.Column))
+			}
 		}
 	}
 
@@ -126,9 +107,17 @@ func WithDefaultTimestamps(fields ...string) SanitizeRowsOption {
 	}
 }
 
+// WithVersionIncrement увеличить на единицу колонку версии, помеченную тегом version:"1" или lock:"version"
+func WithVersionIncrement() SanitizeRowsOption {
+	return func(handler *sanitizeRowsHandler) {
+		handler.IncrementVersion = true
+	}
+}
+
 type sanitizeRowsHandler struct {
 	SkippingFields    map[string]bool
 	DefaultTimestamps []string
+	IncrementVersion  bool
 }
 
 func (h *sanitizeRowsHandler) SetSkippingFields(val map[string]bool) {
@@ -150,3 +139,106 @@ func IsSoftDeletingEntity(entity any) bool {
 
 	return fieldValue.Tag.Get("db") != ""
 }
+
+// GetEntityVersion возвращает колонку и текущее значение версии сущности для оптимистичной блокировки
+// Колонка версии помечается тегом version:"1" или lock:"version" на поле целого типа или указателе на него,
+// nil указатель считается версией 0
+func GetEntityVersion(entity any) (string, int64, bool) {
+	vEntity := reflect.ValueOf(entity)
+
+	for _, field := range database.StructFields(vEntity.Type()) {
+		if !isVersionField(field.Tag) {
+			continue
+		}
+
+		version, ok := versionValue(vEntity.FieldByIndex(field.Index))
+		if !ok {
+			return "", 0, false
+		}
+
+		return field.Column, version, true
+	}
+
+	return "", 0, false
+}
+
+// versionValue возвращает значение поля версии, ok - false для полей не целого типа
+func versionValue(v reflect.Value) (int64, bool) {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v = reflect.Zero(v.Type().Elem())
+		} else {
+			v = v.Elem()
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), true
+	}
+
+	return 0, false
+}
+
+func isVersionField(tag reflect.StructTag) bool {
+	return tag.Get("version") == "1" || tag.Get("lock") == "version"
+}
+
+// SanitizePatchRows возвращает поля для частичного обновления сущности
+// Поля типа types.Omitempty попадают в результат, только если они переданы в запросе,
+// остальные поля с тегом db попадают всегда. patch - структура или указатель на нее
+func SanitizePatchRows(patch any) (map[string]any, error) {
+	vPatch := reflect.ValueOf(patch)
+	for vPatch.Kind() == reflect.Pointer {
+		if vPatch.IsNil() {
+			return nil, fmt.Errorf("%w: nil %T", ErrInvalidPatch, patch)
+		}
+		vPatch = vPatch.Elem()
+	}
+
+	if vPatch.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w: %T", ErrInvalidPatch, patch)
+	}
+
+	rows := map[string]any{}
+	sanitizePatchStruct(vPatch, rows)
+
+	return rows, nil
+}
+
+// sanitizePatchStruct добавляет в rows поля структуры patch, в том числе из вложенных структур
+// Вложенная структура по nil указателю пропускается
+func sanitizePatchStruct(vPatch reflect.Value, rows map[string]any) {
+	for i := 0; i < vPatch.NumField(); i++ {
+		tag := vPatch.Type().Field(i).Tag
+
+		if tag.Get("embedded_struct") == "1" || tag.Get("inner_struct") != "" {
+			inner := vPatch.Field(i)
+			for inner.Kind() == reflect.Pointer && !inner.IsNil() {
+				inner = inner.Elem()
+			}
+			if inner.Kind() == reflect.Struct {
+				sanitizePatchStruct(inner, rows)
+			}
+
+			continue
+		}
+
+		dbFieldName := tag.Get("db")
+		if dbFieldName == "" || tag.Get("primary") != "" {
+			continue
+		}
+
+		if field, ok := vPatch.Field(i).Interface().(types.OmitemptyValue); ok {
+			if field.IsPresent() {
+				rows[dbFieldName] = field.GetValue()
+			}
+
+			continue
+		}
+
+		rows[dbFieldName] = vPatch.Field(i).Interface()
+	}
+}
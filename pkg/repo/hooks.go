@@ -0,0 +1,120 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/doug-martin/goqu/v9/exp"
+)
+
+// Хуки жизненного цикла, которые может реализовать сущность (методы с получателем-указателем).
+// Репозиторий вызывает их в одной транзакции с запросом: ошибка хука откатывает операцию.
+// Before хуки вызываются для копии сущности, поэтому изменения в них (slug, нормализация)
+// попадают в запрос, но не в переданную в репозиторий переменную.
+// Patch, BulkUpdate и RestoreBy работают без сущностей и хуки не вызывают
+
+// BeforeCreateHook вызывается перед вставкой в Create, CreateMultiple и BulkInsert
+type BeforeCreateHook interface {
+	BeforeCreate(ctx context.Context) error
+}
+
+// AfterCreateHook вызывается после вставки в Create и CreateMultiple с id новой записи
+type AfterCreateHook[ID int64 | string] interface {
+	AfterCreate(ctx context.Context, id ID) error
+}
+
+// BeforeUpdateHook вызывается перед обновлением в Update и UpdateMultiple
+type BeforeUpdateHook interface {
+	BeforeUpdate(ctx context.Context) error
+}
+
+// AfterUpdateHook вызывается после обновления в Update и UpdateMultiple
+type AfterUpdateHook interface {
+	AfterUpdate(ctx context.Context) error
+}
+
+// BeforeDeleteHook вызывается перед soft и обычным удалением.
+// Удаляемые сущности для него предварительно выбираются с блокировкой FOR UPDATE, если диалект базы ее поддерживает
+type BeforeDeleteHook interface {
+	BeforeDelete(ctx context.Context) error
+}
+
+func hasCreateHooks[T any, ID int64 | string]() bool {
+	_, before := any(new(T)).(BeforeCreateHook)
+	_, after := any(new(T)).(AfterCreateHook[ID])
+	return before || after
+}
+
+func hasUpdateHooks[T any]() bool {
+	_, before := any(new(T)).(BeforeUpdateHook)
+	_, after := any(new(T)).(AfterUpdateHook)
+	return before || after
+}
+
+func hasDeleteHooks[T any]() bool {
+	_, ok := any(new(T)).(BeforeDeleteHook)
+	return ok
+}
+
+// BeforeCreate вызывает BeforeCreateHook сущности, если она его реализует
+// Хелперы хуков экспортируются для реализаций BaseRepo вне пакета, например memory
+func BeforeCreate[T any](ctx context.Context, entity *T) error {
+	if hook, ok := any(entity).(BeforeCreateHook); ok {
+		return hook.BeforeCreate(ctx)
+	}
+	return nil
+}
+
+// AfterCreate вызывает AfterCreateHook сущности с id новой записи, если она его реализует
+func AfterCreate[T any, ID int64 | string](ctx context.Context, entity *T, id ID) error {
+	if hook, ok := any(entity).(AfterCreateHook[ID]); ok {
+		return hook.AfterCreate(ctx, id)
+	}
+	return nil
+}
+
+// BeforeUpdate вызывает BeforeUpdateHook сущности, если она его реализует
+func BeforeUpdate[T any](ctx context.Context, entity *T) error {
+	if hook, ok := any(entity).(BeforeUpdateHook); ok {
+		return hook.BeforeUpdate(ctx)
+	}
+	return nil
+}
+
+// AfterUpdate вызывает AfterUpdateHook сущности, если она его реализует
+func AfterUpdate[T any](ctx context.Context, entity *T) error {
+	if hook, ok := any(entity).(AfterUpdateHook); ok {
+		return hook.AfterUpdate(ctx)
+	}
+	return nil
+}
+
+// BeforeDelete вызывает BeforeDeleteHook сущности, если она его реализует
+func BeforeDelete[T any](ctx context.Context, entity *T) error {
+	if hook, ok := any(entity).(BeforeDeleteHook); ok {
+		return hook.BeforeDelete(ctx)
+	}
+	return nil
+}
+
+// withDeleteHooks выполняет удаление fn, предварительно вызвав BeforeDelete для удаляемых по criteria сущностей
+// Если сущность хук не реализует, fn выполняется без транзакции и лишней выборки
+func (r *baseRepo[T, ID]) withDeleteHooks(ctx context.Context, criteria exp.ExpressionList, fn func(ctx context.Context) error) error {
+	if !hasDeleteHooks[T]() {
+		return fn(ctx)
+	}
+
+	return r.db.RunInTx(ctx, func(ctx context.Context) error {
+		entities, err := r.ListByExpression(ctx, criteria, WithTrashed(), ForUpdate())
+		if err != nil {
+			return err
+		}
+
+		for i := range entities {
+			if err = BeforeDelete(ctx, &entities[i]); err != nil {
+				return err
+			}
+		}
+
+		return fn(ctx)
+	})
+}
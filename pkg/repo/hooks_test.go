@@ -0,0 +1,231 @@
+package repo_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jackc/pgx/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/EveryHotel/core-tools/pkg/database"
+	"github.com/EveryHotel/core-tools/pkg/repo"
+)
+
+var errHook = errors.New("hook failed")
+
+// hookRecorder журнал вызовов хуков и ошибки, которые хуки должны вернуть
+type hookRecorder struct {
+	db    database.DBService
+	calls []string
+	fail  map[string]bool
+}
+
+var hooks *hookRecorder
+
+// call записывает вызов хука и проверяет, что он выполняется в транзакции репозитория
+func (h *hookRecorder) call(ctx context.Context, name string) error {
+	if _, ok := ctx.Value(database.CtxDbTxKey).(pgx.Tx); !ok {
+		return fmt.Errorf("%s called outside tx", name)
+	}
+
+	h.calls = append(h.calls, name)
+	if h.fail[strings.Fields(name)[0]] {
+		return errHook
+	}
+
+	return nil
+}
+
+type hookedHotel struct {
+	Id        int64     `db:"id" primary:"1"`
+	Name      string    `db:"name"`
+	Stars     int64     `db:"stars"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+func (h *hookedHotel) BeforeCreate(ctx context.Context) error {
+	h.Name = strings.TrimSpace(h.Name)
+	return hooks.call(ctx, "BeforeCreate "+h.Name)
+}
+
+func (h *hookedHotel) AfterCreate(ctx context.Context, id int64) error {
+	// запись уже видна в транзакции хука, в нее же можно писать связанные данные
+	count, err := hooks.db.Count(ctx, `SELECT COUNT(*) FROM hotels WHERE id = ?`, []any{id})
+	if err != nil {
+		return err
+	}
+	if err = hooks.db.Exec(ctx, `INSERT INTO cities (name) VALUES (?)`, []any{h.Name}); err != nil {
+		return err
+	}
+
+	return hooks.call(ctx, fmt.Sprintf("AfterCreate %s %d", h.Name, count))
+}
+
+func (h *hookedHotel) BeforeUpdate(ctx context.Context) error {
+	return hooks.call(ctx, "BeforeUpdate "+h.Name)
+}
+
+func (h *hookedHotel) AfterUpdate(ctx context.Context) error {
+	return hooks.call(ctx, "AfterUpdate "+h.Name)
+}
+
+func (h *hookedHotel) BeforeDelete(ctx context.Context) error {
+	return hooks.call(ctx, "BeforeDelete "+h.Name)
+}
+
+var _ = Describe("Hooks", func() {
+	var (
+		ctx    context.Context
+		svc    database.DBService
+		hotels repo.BaseRepo[hookedHotel, int64]
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		svc = openDB()
+		hooks = &hookRecorder{db: svc, fail: map[string]bool{}}
+		hotels = repo.NewRepository[hookedHotel, int64](svc, "hotels", "h", "id")
+	})
+
+	count := func(table string) int64 {
+		res, err := svc.Count(ctx, "SELECT COUNT(*) FROM "+table, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		return res
+	}
+
+	It("calls create hooks around insert in one tx", func() {
+		id, err := hotels.Create(ctx, hookedHotel{Name: "  Grand "})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(hooks.calls).Should(Equal([]string{"BeforeCreate Grand", "AfterCreate Grand 1"}))
+
+		stored, err := hotels.Get(ctx, id)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(stored.Name).Should(Equal("Grand"))
+		Expect(count("cities")).Should(Equal(int64(1)))
+	})
+
+	It("calls hooks for every created entity in order", func() {
+		_, err := hotels.CreateMultiple(ctx, []hookedHotel{{Name: "Alfa"}, {Name: "Beta"}})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(hooks.calls).Should(Equal([]string{
+			"BeforeCreate Alfa", "BeforeCreate Beta",
+			"AfterCreate Alfa 1", "AfterCreate Beta 1",
+		}))
+	})
+
+	It("calls update hooks around update", func() {
+		id, err := hotels.Create(ctx, hookedHotel{Name: "Grand"})
+		Expect(err).ShouldNot(HaveOccurred())
+		hooks.calls = nil
+
+		Expect(hotels.Update(ctx, hookedHotel{Id: id, Name: "Grand", Stars: 5})).Should(Succeed())
+
+		Expect(hooks.calls).Should(Equal([]string{"BeforeUpdate Grand", "AfterUpdate Grand"}))
+	})
+
+	It("calls delete hook with stored entities", func() {
+		_, err := hotels.CreateMultiple(ctx, []hookedHotel{{Name: "Alfa"}, {Name: "Beta"}, {Name: "Gamma"}})
+		Expect(err).ShouldNot(HaveOccurred())
+		hooks.calls = nil
+
+		Expect(hotels.DeleteBy(ctx, map[string]any{"name": []string{"Alfa", "Gamma"}})).Should(Succeed())
+
+		Expect(hooks.calls).Should(ConsistOf("BeforeDelete Alfa", "BeforeDelete Gamma"))
+		Expect(count("hotels")).Should(Equal(int64(1)))
+	})
+
+	Describe("hook error", func() {
+		It("rolls back create", func() {
+			hooks.fail["AfterCreate"] = true
+
+			_, err := hotels.Create(ctx, hookedHotel{Name: "Grand"})
+
+			Expect(err).Should(MatchError(errHook))
+			Expect(count("hotels")).Should(BeZero())
+			Expect(count("cities")).Should(BeZero())
+		})
+
+		It("rolls back every entity of multiple create", func() {
+			hooks.fail["AfterCreate"] = true
+
+			_, err := hotels.CreateMultiple(ctx, []hookedHotel{{Name: "Alfa"}, {Name: "Beta"}})
+
+			Expect(err).Should(MatchError(errHook))
+			Expect(count("hotels")).Should(BeZero())
+		})
+
+		It("rolls back update", func() {
+			id, err := hotels.Create(ctx, hookedHotel{Name: "Grand", Stars: 3})
+			Expect(err).ShouldNot(HaveOccurred())
+			hooks.fail["AfterUpdate"] = true
+
+			Expect(hotels.Update(ctx, hookedHotel{Id: id, Name: "Grand", Stars: 5})).Should(MatchError(errHook))
+
+			stored, err := hotels.Get(ctx, id)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(stored.Stars).Should(Equal(int64(3)))
+		})
+
+		It("prevents delete", func() {
+			id, err := hotels.Create(ctx, hookedHotel{Name: "Grand"})
+			Expect(err).ShouldNot(HaveOccurred())
+			hooks.fail["BeforeDelete"] = true
+
+			Expect(hotels.Delete(ctx, id)).Should(MatchError(errHook))
+			Expect(count("hotels")).Should(Equal(int64(1)))
+		})
+	})
+
+	Describe("inside RunInTx", func() {
+		It("runs hooks in outer tx and commits with it", func() {
+			err := svc.RunInTx(ctx, func(ctx context.Context) error {
+				_, err := hotels.Create(ctx, hookedHotel{Name: "Grand"})
+				return err
+			})
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(count("hotels")).Should(Equal(int64(1)))
+			Expect(count("cities")).Should(Equal(int64(1)))
+		})
+
+		It("rolls back hook writes with outer tx", func() {
+			err := svc.RunInTx(ctx, func(ctx context.Context) error {
+				if _, err := hotels.Create(ctx, hookedHotel{Name: "Grand"}); err != nil {
+					return err
+				}
+				return errors.New("outer failed")
+			})
+
+			Expect(err).Should(MatchError("outer failed"))
+			Expect(count("hotels")).Should(BeZero())
+			Expect(count("cities")).Should(BeZero())
+		})
+
+		It("keeps outer tx usable after hook error", func() {
+			err := svc.RunInTx(ctx, func(ctx context.Context) error {
+				if _, err := hotels.Create(ctx, hookedHotel{Name: "Alfa"}); err != nil {
+					return err
+				}
+
+				hooks.fail["AfterCreate"] = true
+				_, err := hotels.Create(ctx, hookedHotel{Name: "Beta"})
+				Expect(err).Should(MatchError(errHook))
+				return nil
+			})
+
+			Expect(err).ShouldNot(HaveOccurred())
+			names, err := hotels.ListByExpression(ctx, goqu.And())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(names).Should(HaveLen(1))
+			Expect(names[0].Name).Should(Equal("Alfa"))
+		})
+	})
+})
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"strings"
 
 	"github.com/doug-martin/goqu/v9"
 	"github.com/doug-martin/goqu/v9/exp"
@@ -40,6 +41,7 @@ type indexableBaseRepo[I Index[ID], E IndexableModel[I], ID int64 | string] stru
 	meili                meilisearch.MeiliService
 	indexName            string
 	alias                string
+	idColumn             string
 	setId                func(ptr *E, id ID)
 	extendIndexableItems func([]E) ([]E, error)
 	indexRelations       []ListOptionRelation
@@ -55,11 +57,20 @@ func NewIndexableRepository[I Index[ID], E IndexableModel[I], ID int64 | string]
 	indexRelations []ListOptionRelation,
 	meiSettings *meili.Settings,
 ) IndexableBaseRepo[I, E, ID] {
+	base := NewRepository[E, ID](db, tableName, alias, idColumn)
+	// колонка id с алиасом таблицы для keyset батчей Reindex, как в baseRepo
+	if idColumn == "" {
+		idColumn = alias + ".id"
+	} else if !strings.Contains(idColumn, ".") {
+		idColumn = alias + "." + idColumn
+	}
+
 	return &indexableBaseRepo[I, E, ID]{
-		BaseRepo:             NewRepository[E, ID](db, tableName, alias, idColumn),
+		BaseRepo:             base,
 		meili:                meili,
 		indexName:            indexName,
 		alias:                alias,
+		idColumn:             idColumn,
 		setId:                setId,
 		extendIndexableItems: extendIndexableItems,
 		indexRelations:       indexRelations,
@@ -67,6 +78,11 @@ func NewIndexableRepository[I Index[ID], E IndexableModel[I], ID int64 | string]
 	}
 }
 
+// aggregate передает агрегирующий запрос во вложенный репозиторий
+func (r *indexableBaseRepo[I, E, ID]) aggregate(ctx context.Context, criteria exp.ExpressionList, dest any, options ...ListOption) error {
+	return aggregateInto(ctx, r.BaseRepo, criteria, dest, options...)
+}
+
 // List возвращает список сущностей
 func (r *indexableBaseRepo[I, E, ID]) List(ctx context.Context, options ...SqlQueryOption) ([]E, error) {
 	res, err := r.BaseRepo.List(ctx, options...)
@@ -105,6 +121,14 @@ func (r *indexableBaseRepo[I, E, ID]) Create(ctx context.Context, entity E, opti
 	}
 
 	r.setId(&entity, id)
+
+	// BeforeCreate мог изменить сохраненную сущность, поэтому в индекс отправляем ее из базы
+	if _, ok := any(&entity).(BeforeCreateHook); ok {
+		if entity, err = r.getStored(ctx, id); err != nil {
+			return id, nil
+		}
+	}
+
 	_ = r.UpdateIndex(ctx, entity)
 
 	return id, nil
@@ -116,11 +140,35 @@ func (r *indexableBaseRepo[I, E, ID]) Update(ctx context.Context, entity E, opti
 		return err
 	}
 
+	// BeforeUpdate мог изменить сохраненную сущность, поэтому в индекс отправляем ее из базы
+	if _, ok := any(&entity).(BeforeUpdateHook); ok {
+		id, _ := SanitizeRows[ID](entity)
+		stored, err := r.getStored(ctx, id)
+		if err != nil {
+			return nil
+		}
+		entity = stored
+	}
+
 	_ = r.UpdateIndex(ctx, entity)
 
 	return nil
 }
 
+// getStored читает сущность для индексации с primary, так как реплика может еще не получить изменения
+func (r *indexableBaseRepo[I, E, ID]) getStored(ctx context.Context, id ID) (E, error) {
+	entity, err := r.Get(context.WithValue(ctx, database.CtxDbForcePrimaryKey, true), id, r.indexRelations...)
+	if err != nil {
+		slog.ErrorContext(ctx, "can't get entity for search index",
+			slog.Any("error", err),
+			slog.String("index", r.indexName),
+			slog.Any("id", id),
+		)
+	}
+
+	return entity, err
+}
+
 func (r *indexableBaseRepo[I, E, ID]) SearchByTerm(term string, filters map[string]any, opts ...meilisearch.OptHandler) ([]I, error) {
 
 	items, err := r.meili.SearchDocuments(r.indexName, term, filters, opts...)
@@ -210,25 +258,13 @@ func (r *indexableBaseRepo[I, E, ID]) UpdateIndex(ctx context.Context, entity E)
 
 // Reindex переиндексация всех сущностей
 func (r *indexableBaseRepo[I, E, ID]) Reindex(ctx context.Context) error {
-	var (
-		err           error
-		limit, offset int64
-	)
+	const batchSize = 500
 
-	err = r.meili.Clear(r.indexName)
+	err := r.meili.Clear(r.indexName)
 	if err != nil {
 		return err
 	}
 
-	limit = 500
-	offset = 0
-	criteria := make(map[string]any)
-
-	if IsSoftDeletingEntity(*new(E)) {
-		criteria[r.alias+".deleted_at"] = nil
-	}
-	sortRule := WithSort([]exp.OrderedExpression{goqu.I(r.alias + ".id").Asc()})
-
 	if r.meiliSettings != nil {
 		err = r.meili.UpdateSettings(r.indexName, r.meiliSettings)
 		if err != nil {
@@ -236,25 +272,30 @@ func (r *indexableBaseRepo[I, E, ID]) Reindex(ctx context.Context) error {
 		}
 	}
 
-	for {
-		opts := []ListOption{
-			WithLimit(limit),
-			WithOffset(offset),
-			sortRule,
-		}
-		if r.indexRelations != nil {
-			opts = append(opts, WithRelations(r.indexRelations))
-		}
+	// сущности читаются keyset батчами, а курсор закрывается до extendIndexableItems: колбэк обычно
+	// делает запросы, которые в транзакции или на единственном соединении нельзя выполнять при открытом курсоре
+	opts := []ListOption{
+		WithSort([]exp.OrderedExpression{goqu.I(r.idColumn).Asc()}),
+		WithLimit(batchSize),
+	}
+	if r.indexRelations != nil {
+		opts = append(opts, WithRelations(r.indexRelations))
+	}
 
-		items, err := r.ListBy(ctx, criteria, opts...)
+	criteria := goqu.And()
+	for {
+		items, err := r.ListByExpression(ctx, criteria, opts...)
 		if err != nil {
 			return err
 		}
-
-		if len(items) <= 0 {
-			break
+		if len(items) == 0 {
+			return nil
 		}
 
+		last, _ := SanitizeRows[ID](items[len(items)-1])
+		criteria = goqu.And(goqu.I(r.idColumn).Gt(last))
+		full := len(items) == batchSize
+
 		if r.extendIndexableItems != nil {
 			items, err = r.extendIndexableItems(items)
 			if err != nil {
@@ -271,10 +312,10 @@ func (r *indexableBaseRepo[I, E, ID]) Reindex(ctx context.Context) error {
 			return err
 		}
 
-		offset += limit
+		if !full {
+			return nil
+		}
 	}
-
-	return nil
 }
 
 // Delete удаляет сущность
@@ -296,6 +337,38 @@ func (r *indexableBaseRepo[I, E, ID]) Delete(ctx context.Context, id ID, options
 	return nil
 }
 
+// Restore восстанавливает soft удаленную сущность и возвращает ее в индекс
+func (r *indexableBaseRepo[I, E, ID]) Restore(ctx context.Context, id ID, options ...SqlQueryOption) error {
+	if err := r.BaseRepo.Restore(ctx, id, options...); err != nil {
+		return err
+	}
+
+	// восстановление уже выполнено, ошибка чтения для индекса только логируется
+	entity, err := r.getStored(ctx, id)
+	if err != nil {
+		return nil
+	}
+
+	_ = r.UpdateIndex(ctx, entity)
+
+	return nil
+}
+
+// DeleteAndMoveReferences переносит ссылки на newId, удаляет сущность и ее документ из индекса
 func (r *indexableBaseRepo[I, E, ID]) DeleteAndMoveReferences(ctx context.Context, id ID, newId ID) error {
-	return r.Delete(ctx, id)
+	if err := r.BaseRepo.DeleteAndMoveReferences(ctx, id, newId); err != nil {
+		return err
+	}
+
+	sId := fmt.Sprintf("%v", id)
+
+	if err := r.meili.DeleteDocument(r.indexName, sId); err != nil {
+		slog.ErrorContext(ctx, "can't delete entity search index",
+			slog.Any("error", err),
+			slog.String("index", r.indexName),
+			slog.String("id", sId),
+		)
+	}
+
+	return nil
 }
@@ -25,12 +25,116 @@ func WithSort(sort []exp.OrderedExpression) ListOption {
 	}
 }
 
+// WithCursor keyset пагинация по колонке column
+// Выбираются записи, у которых column больше value (меньше при desc), с сортировкой по column.
+// Для первой страницы value передается nil. Колонка должна быть уникальной, например id.
+// Колонка без точки относится к таблице репозитория (к ней добавляется алиас)
+func WithCursor(column string, value any, desc bool) ListOption {
+	return func(handler *ListOptionHandler) {
+		handler.Cursor = &ListOptionCursor{
+			Column: column,
+			Value:  value,
+			Desc:   desc,
+		}
+	}
+}
+
+// WithTrashed включает в выборку soft удаленные записи
+func WithTrashed() ListOption {
+	return func(handler *ListOptionHandler) {
+		handler.Trashed = TrashedInclude
+	}
+}
+
+// OnlyTrashed выбирает только soft удаленные записи
+func OnlyTrashed() ListOption {
+	return func(handler *ListOptionHandler) {
+		handler.Trashed = TrashedOnly
+	}
+}
+
 func WithRelations(relations []ListOptionRelation) ListOption {
 	return func(handler *ListOptionHandler) {
 		handler.Relations = relations
 	}
 }
 
+// WithPreloads загружает has-many и many-to-many связи отдельными запросами после основной выборки
+func WithPreloads(preloads []ListOptionPreload) ListOption {
+	return func(handler *ListOptionHandler) {
+		handler.Preloads = preloads
+	}
+}
+
+// WithColumns выбирает только перечисленные колонки сущности вместо всех полей из database.Sanitize,
+// остальные поля остаются нулевыми, связи не сканируются.
+// Строка - имя колонки таблицы репозитория (без точки к ней добавляется алиас), выражение должно иметь алиас (As),
+// совпадающий с колонкой сущности. В Aggregate колонки и выражения передаются в SELECT как есть
+func WithColumns(columns ...any) ListOption {
+	return func(handler *ListOptionHandler) {
+		handler.Columns = columns
+	}
+}
+
+// WithDistinctOn добавляет DISTINCT ON по колонкам, сортировка должна начинаться с них же
+func WithDistinctOn(columns ...any) ListOption {
+	return func(handler *ListOptionHandler) {
+		handler.DistinctOn = columns
+	}
+}
+
+// WithGroupBy добавляет GROUP BY по колонкам, используется вместе с Aggregate
+// В CountByExpression и ListPage считается количество групп
+func WithGroupBy(columns ...any) ListOption {
+	return func(handler *ListOptionHandler) {
+		handler.GroupBy = columns
+	}
+}
+
+// WithHaving добавляет условие HAVING для сгруппированной выборки
+func WithHaving(expressions ...exp.Expression) ListOption {
+	return func(handler *ListOptionHandler) {
+		handler.Having = expressions
+	}
+}
+
+// ForUpdate блокирует выбранные строки FOR UPDATE до конца транзакции из контекста
+// При связях блокируются только строки основной таблицы (FOR UPDATE OF алиас),
+// так как postgres не позволяет блокировать nullable сторону LEFT JOIN
+func ForUpdate() ListOption {
+	return func(handler *ListOptionHandler) {
+		handler.Lock = exp.ForUpdate
+	}
+}
+
+// ForNoKeyUpdate блокирует выбранные строки FOR NO KEY UPDATE, не мешая вставке ссылающихся на них записей
+func ForNoKeyUpdate() ListOption {
+	return func(handler *ListOptionHandler) {
+		handler.Lock = exp.ForNoKeyUpdate
+	}
+}
+
+// ForShare блокирует выбранные строки FOR SHARE от изменения другими транзакциями
+func ForShare() ListOption {
+	return func(handler *ListOptionHandler) {
+		handler.Lock = exp.ForShare
+	}
+}
+
+// NoWait возвращает ошибку вместо ожидания уже заблокированных строк, используется вместе с ForUpdate/ForNoKeyUpdate/ForShare
+func NoWait() ListOption {
+	return func(handler *ListOptionHandler) {
+		handler.LockWait = exp.NoWait
+	}
+}
+
+// SkipLocked пропускает уже заблокированные строки, используется вместе с ForUpdate/ForNoKeyUpdate/ForShare
+func SkipLocked() ListOption {
+	return func(handler *ListOptionHandler) {
+		handler.LockWait = exp.SkipLocked
+	}
+}
+
 func WithSqlOptions(sqlOptions []SqlQueryOption) ListOption {
 	return func(handler *ListOptionHandler) {
 		handler.SqlOptions = sqlOptions
@@ -70,6 +174,30 @@ type ListOptionHandler struct {
 	Offset     int64
 	Sort       []exp.OrderedExpression
 	Relations  []ListOptionRelation
+	Preloads   []ListOptionPreload
+	Cursor     *ListOptionCursor
+	Trashed    TrashedScope
+	Columns    []any
+	DistinctOn []any
+	GroupBy    []any
+	Having     []exp.Expression
+	Lock       exp.LockStrength
+	LockWait   exp.WaitOption
+}
+
+// TrashedScope определяет, как выборка обрабатывает soft удаленные записи
+type TrashedScope int
+
+const (
+	TrashedExclude TrashedScope = iota
+	TrashedInclude
+	TrashedOnly
+)
+
+type ListOptionCursor struct {
+	Column string
+	Value  any
+	Desc   bool
 }
 
 type ListOptionRelation struct {
@@ -0,0 +1,106 @@
+package repo_test
+
+import (
+	"context"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/EveryHotel/core-tools/pkg/database"
+	"github.com/EveryHotel/core-tools/pkg/repo"
+)
+
+var _ = Describe("ListPage", func() {
+	var (
+		ctx    context.Context
+		svc    database.DBService
+		hotels repo.BaseRepo[hotel, int64]
+		moscow int64
+	)
+
+	names := func(items []hotel) []string {
+		res := make([]string, len(items))
+		for i, item := range items {
+			res[i] = item.Name
+		}
+		return res
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		svc = openDB()
+		hotels = repo.NewRepository[hotel, int64](svc, "hotels", "h", "id")
+
+		moscow = createCity(svc, "Moscow")
+		kazan := createCity(svc, "Kazan")
+		_, err := hotels.CreateMultiple(ctx, []hotel{
+			{Name: "Alfa", CityId: &moscow},
+			{Name: "Beta", CityId: &moscow},
+			{Name: "Gamma", CityId: &kazan},
+			{Name: "Delta", CityId: &moscow},
+			{Name: "Omega"},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	It("returns page and total count without limit and offset", func() {
+		items, total, err := hotels.ListPage(ctx, goqu.And(goqu.I("h.city_id").Eq(moscow)),
+			repo.WithSort([]exp.OrderedExpression{goqu.I("h.name").Asc()}),
+			repo.WithLimit(2),
+			repo.WithOffset(1),
+		)
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(names(items)).Should(Equal([]string{"Beta", "Delta"}))
+		Expect(total).Should(Equal(int64(3)))
+	})
+
+	It("returns empty page after the last item", func() {
+		items, total, err := hotels.ListPage(ctx, goqu.And(), repo.WithLimit(2), repo.WithOffset(10))
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(items).Should(BeEmpty())
+		Expect(total).Should(Equal(int64(5)))
+	})
+
+	Describe("WithCursor", func() {
+		It("pages forward by cursor column", func() {
+			first, err := hotels.ListByExpression(ctx, goqu.And(), repo.WithCursor("id", nil, false), repo.WithLimit(2))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(names(first)).Should(Equal([]string{"Alfa", "Beta"}))
+
+			second, err := hotels.ListByExpression(ctx, goqu.And(), repo.WithCursor("id", first[1].Id, false), repo.WithLimit(2))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(names(second)).Should(Equal([]string{"Gamma", "Delta"}))
+
+			last, err := hotels.ListByExpression(ctx, goqu.And(), repo.WithCursor("id", second[1].Id, false), repo.WithLimit(2))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(names(last)).Should(Equal([]string{"Omega"}))
+		})
+
+		It("pages backward by cursor column", func() {
+			first, err := hotels.ListByExpression(ctx, goqu.And(), repo.WithCursor("id", nil, true), repo.WithLimit(3))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(names(first)).Should(Equal([]string{"Omega", "Delta", "Gamma"}))
+
+			second, err := hotels.ListByExpression(ctx, goqu.And(), repo.WithCursor("id", first[2].Id, true), repo.WithLimit(3))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(names(second)).Should(Equal([]string{"Beta", "Alfa"}))
+		})
+
+		It("qualifies cursor column with alias when relations are joined", func() {
+			items, total, err := hotels.ListPage(ctx, goqu.And(goqu.I("c.name").Eq("Moscow")),
+				repo.WithRelations([]repo.ListOptionRelation{cityRelation}),
+				repo.WithCursor("id", int64(1), false),
+				repo.WithLimit(1),
+			)
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(names(items)).Should(Equal([]string{"Beta"}))
+			Expect(items[0].City).Should(Equal(&city{Id: moscow, Name: "Moscow"}))
+			Expect(total).Should(Equal(int64(3)))
+		})
+	})
+})
@@ -0,0 +1,345 @@
+package memory
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/shopspring/decimal"
+)
+
+// ErrUnsupportedExpression выражение, которое репозиторий в памяти не умеет вычислять
+var ErrUnsupportedExpression = errors.New("expression is not supported by memory repository")
+
+// match проверяет, подходит ли сущность под выражение
+// Поддерживаются goqu.Ex, goqu.ExOr, goqu.And/goqu.Or и сравнения колонки со значением:
+// =, !=, IS, IS NOT, >, >=, <, <=, IN, NOT IN, LIKE и ILIKE
+func (r *repository[T, ID]) match(entity reflect.Value, expression exp.Expression) (bool, error) {
+	switch e := expression.(type) {
+	case nil:
+		return true, nil
+	case exp.Ex:
+		list, err := e.ToExpressions()
+		if err != nil {
+			return false, err
+		}
+		return r.match(entity, list)
+	case exp.ExOr:
+		list, err := e.ToExpressions()
+		if err != nil {
+			return false, err
+		}
+		return r.match(entity, list)
+	case exp.ExpressionList:
+		and := e.Type() == exp.AndType
+		for _, item := range e.Expressions() {
+			ok, err := r.match(entity, item)
+			if err != nil {
+				return false, err
+			}
+			if ok != and {
+				return ok, nil
+			}
+		}
+		return and, nil
+	case exp.BooleanExpression:
+		return r.matchBoolean(entity, e)
+	}
+
+	return false, fmt.Errorf("%w: %T", ErrUnsupportedExpression, expression)
+}
+
+func (r *repository[T, ID]) matchBoolean(entity reflect.Value, e exp.BooleanExpression) (bool, error) {
+	column, err := columnName(e.LHS())
+	if err != nil {
+		return false, err
+	}
+
+	field, err := r.field(entity, column)
+	if err != nil {
+		return false, err
+	}
+
+	value := normalize(field.Interface())
+	rhs := e.RHS()
+	if _, ok := rhs.(exp.Expression); ok {
+		return false, fmt.Errorf("%w: %T on the right side of %s", ErrUnsupportedExpression, rhs, column)
+	}
+
+	switch e.Op() {
+	case exp.EqOp, exp.IsOp:
+		return equal(value, normalize(rhs)), nil
+	case exp.IsNotOp:
+		return !equal(value, normalize(rhs)), nil
+	case exp.NeqOp:
+		// сравнение с NULL в postgres не истинно
+		return value != nil && normalize(rhs) != nil && !equal(value, normalize(rhs)), nil
+	case exp.GtOp, exp.GteOp, exp.LtOp, exp.LteOp:
+		cmp, ok := compare(value, normalize(rhs))
+		if !ok {
+			return false, nil
+		}
+		switch e.Op() {
+		case exp.GtOp:
+			return cmp > 0, nil
+		case exp.GteOp:
+			return cmp >= 0, nil
+		case exp.LtOp:
+			return cmp < 0, nil
+		default:
+			return cmp <= 0, nil
+		}
+	case exp.InOp, exp.NotInOp:
+		in, err := contains(rhs, value)
+		if err != nil || value == nil {
+			return false, err
+		}
+		return in == (e.Op() == exp.InOp), nil
+	case exp.LikeOp, exp.NotLikeOp, exp.ILikeOp, exp.NotILikeOp:
+		str, ok := value.(string)
+		pattern, patternOk := normalize(rhs).(string)
+		if !ok || !patternOk {
+			return false, nil
+		}
+		insensitive := e.Op() == exp.ILikeOp || e.Op() == exp.NotILikeOp
+		like := likePattern(pattern, insensitive).MatchString(str)
+		return like == (e.Op() == exp.LikeOp || e.Op() == exp.ILikeOp), nil
+	}
+
+	return false, fmt.Errorf("%w: operation %d on %s", ErrUnsupportedExpression, e.Op(), column)
+}
+
+// columnName возвращает имя колонки без алиаса таблицы
+func columnName(expression exp.Expression) (string, error) {
+	if ident, ok := expression.(exp.IdentifierExpression); ok {
+		if col, ok := ident.GetCol().(string); ok {
+			return col, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %T is not a column", ErrUnsupportedExpression, expression)
+}
+
+func contains(list any, value any) (bool, error) {
+	vList := reflect.ValueOf(list)
+	if vList.Kind() != reflect.Slice && vList.Kind() != reflect.Array {
+		return false, fmt.Errorf("%w: IN with %T", ErrUnsupportedExpression, list)
+	}
+
+	for i := 0; i < vList.Len(); i++ {
+		if equal(value, normalize(vList.Index(i).Interface())) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func likePattern(pattern string, insensitive bool) *regexp.Regexp {
+	var b strings.Builder
+	if insensitive {
+		b.WriteString("(?i)")
+	}
+	b.WriteString("^")
+	for _, c := range pattern {
+		switch c {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+
+	return regexp.MustCompile(b.String())
+}
+
+// normalize приводит значение поля или критерия к виду для сравнения:
+// NULL (nil указатель, невалидный null тип) - nil, целые - int64, дробные и decimal - float64
+func normalize(value any) any {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil
+	}
+
+	switch d := v.Interface().(type) {
+	case decimal.Decimal:
+		f, _ := d.Float64()
+		return f
+	case decimal.NullDecimal:
+		if !d.Valid {
+			return nil
+		}
+		f, _ := d.Decimal.Float64()
+		return f
+	case time.Time:
+		return d
+	case driver.Valuer:
+		val, err := d.Value()
+		if err != nil || val == nil {
+			return nil
+		}
+		v = reflect.ValueOf(val)
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	}
+
+	return v.Interface()
+}
+
+func equal(a, b any) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	if cmp, ok := compare(a, b); ok {
+		return cmp == 0
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// compare сравнивает нормализованные значения, ok - false для NULL и несравнимых типов
+func compare(a, b any) (int, bool) {
+	switch av := a.(type) {
+	case int64:
+		switch bv := b.(type) {
+		case int64:
+			return cmpOrdered(av, bv), true
+		case float64:
+			return cmpOrdered(float64(av), bv), true
+		}
+	case float64:
+		switch bv := b.(type) {
+		case int64:
+			return cmpOrdered(av, float64(bv)), true
+		case float64:
+			return cmpOrdered(av, bv), true
+		}
+	case string:
+		if bv, ok := b.(string); ok {
+			return strings.Compare(av, bv), true
+		}
+	case time.Time:
+		if bv, ok := b.(time.Time); ok {
+			return av.Compare(bv), true
+		}
+	case bool:
+		if bv, ok := b.(bool); ok && av == bv {
+			return 0, true
+		}
+	}
+
+	return 0, false
+}
+
+func cmpOrdered[V int64 | float64](a, b V) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// setValue присваивает полю значение из критерия обновления, nil обнуляет поле
+func setValue(field reflect.Value, value any) error {
+	if value == nil {
+		field.SetZero()
+		return nil
+	}
+
+	if _, ok := value.(exp.Expression); ok {
+		return fmt.Errorf("%w: %T as value", ErrUnsupportedExpression, value)
+	}
+
+	v := reflect.ValueOf(value)
+	if v.Type().AssignableTo(field.Type()) {
+		field.Set(v)
+		return nil
+	}
+
+	if field.Kind() == reflect.Pointer && v.Kind() != reflect.Pointer {
+		ptr := reflect.New(field.Type().Elem())
+		if err := setValue(ptr.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(ptr)
+		return nil
+	}
+
+	if scanner, ok := field.Addr().Interface().(sql.Scanner); ok {
+		if valuer, ok := value.(driver.Valuer); ok {
+			var err error
+			if value, err = valuer.Value(); err != nil {
+				return err
+			}
+		}
+		return scanner.Scan(value)
+	}
+
+	if sameKindClass(v.Kind(), field.Kind()) && v.Type().ConvertibleTo(field.Type()) {
+		field.Set(v.Convert(field.Type()))
+		return nil
+	}
+
+	return fmt.Errorf("cannot assign %T to field of type %s", value, field.Type())
+}
+
+// setNow проставляет текущее время в поле времени, для прочих типов ничего не делает
+func setNow(field reflect.Value, now time.Time) {
+	switch {
+	case field.Type() == reflect.TypeOf(now):
+		field.Set(reflect.ValueOf(now))
+	case field.Type() == reflect.TypeOf(&now):
+		field.Set(reflect.ValueOf(&now))
+	default:
+		if scanner, ok := field.Addr().Interface().(sql.Scanner); ok {
+			_ = scanner.Scan(now)
+		}
+	}
+}
+
+func sameKindClass(a, b reflect.Kind) bool {
+	return kindClass(a) != 0 && kindClass(a) == kindClass(b)
+}
+
+func kindClass(kind reflect.Kind) int {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return 1
+	case reflect.String:
+		return 2
+	case reflect.Bool:
+		return 3
+	}
+	return 0
+}
@@ -0,0 +1,93 @@
+package memory_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/shopspring/decimal"
+
+	"github.com/EveryHotel/core-tools/pkg/repo"
+	"github.com/EveryHotel/core-tools/pkg/repo/memory"
+)
+
+var _ = Describe("Criteria", func() {
+	var (
+		ctx    context.Context
+		hotels repo.BaseRepo[hotel, int64]
+		since  time.Time
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		hotels = memory.NewRepository[hotel, int64](
+			hotel{Name: "Alfa", CityId: ptr(int64(1)), Stars: 3, Price: decimal.NewFromFloat(99.5)},
+			hotel{Name: "beta", CityId: ptr(int64(2)), Stars: 5, Price: decimal.NewFromInt(200)},
+			hotel{Name: "Gamma", Stars: 4, Price: decimal.NewFromInt(150)},
+		)
+		since = time.Now().Add(-time.Minute)
+	})
+
+	names := func(criteria exp.Expression) []string {
+		list, err := hotels.ListByExpression(ctx, goqu.And(criteria))
+		Expect(err).ShouldNot(HaveOccurred())
+
+		res := make([]string, len(list))
+		for i, item := range list {
+			res[i] = item.Name
+		}
+		return res
+	}
+
+	DescribeTable("matches column with value",
+		func(criteria exp.Expression, expected []string) {
+			Expect(names(criteria)).Should(Equal(expected))
+		},
+		Entry("equal", goqu.Ex{"stars": 5}, []string{"beta"}),
+		Entry("equal with alias and pointer column", goqu.Ex{"h.city_id": 1}, []string{"Alfa"}),
+		Entry("is null", goqu.Ex{"city_id": nil}, []string{"Gamma"}),
+		Entry("is not null", goqu.C("city_id").IsNotNull(), []string{"Alfa", "beta"}),
+		Entry("not equal skips null", goqu.C("city_id").Neq(1), []string{"beta"}),
+		Entry("greater", goqu.C("stars").Gt(3), []string{"beta", "Gamma"}),
+		Entry("greater or equal", goqu.C("stars").Gte(4), []string{"beta", "Gamma"}),
+		Entry("less", goqu.C("stars").Lt(4), []string{"Alfa"}),
+		Entry("less or equal", goqu.C("stars").Lte(4), []string{"Alfa", "Gamma"}),
+		Entry("decimal with float", goqu.C("price").Lt(100.0), []string{"Alfa"}),
+		Entry("decimal with decimal", goqu.C("price").Gte(decimal.NewFromInt(150)), []string{"beta", "Gamma"}),
+		Entry("in", goqu.Ex{"stars": []int{3, 4}}, []string{"Alfa", "Gamma"}),
+		Entry("not in skips null", goqu.C("city_id").NotIn(1), []string{"beta"}),
+		Entry("like", goqu.C("name").Like("%ma"), []string{"Gamma"}),
+		Entry("like with single char", goqu.C("name").Like("_lfa"), []string{"Alfa"}),
+		Entry("not like", goqu.C("name").NotLike("%ma"), []string{"Alfa", "beta"}),
+		Entry("ilike", goqu.C("name").ILike("B%"), []string{"beta"}),
+		Entry("not ilike", goqu.C("name").NotILike("%A"), []string{}),
+		Entry("or", goqu.Or(goqu.C("stars").Eq(3), goqu.C("stars").Eq(5)), []string{"Alfa", "beta"}),
+		Entry("ex or", goqu.ExOr{"stars": 3, "name": "Gamma"}, []string{"Alfa", "Gamma"}),
+		Entry("nested", goqu.And(goqu.C("stars").Gt(3), goqu.Or(goqu.Ex{"city_id": nil}, goqu.C("price").Gt(180))), []string{"beta", "Gamma"}),
+	)
+
+	It("compares time columns", func() {
+		Expect(names(goqu.C("created_at").Gt(since))).Should(HaveLen(3))
+		Expect(names(goqu.C("created_at").Lt(since))).Should(BeEmpty())
+	})
+
+	It("fails on unknown column", func() {
+		_, err := hotels.ListBy(ctx, map[string]any{"rating": 1})
+
+		Expect(err).Should(MatchError(repo.ErrUnknownColumn))
+	})
+
+	It("fails on expressions it cannot evaluate", func() {
+		_, err := hotels.ListByExpression(ctx, goqu.And(goqu.L("stars > 1")))
+		Expect(err).Should(MatchError(memory.ErrUnsupportedExpression))
+
+		_, err = hotels.ListByExpression(ctx, goqu.And(goqu.C("stars").Gt(goqu.C("version"))))
+		Expect(err).Should(MatchError(memory.ErrUnsupportedExpression))
+
+		_, err = hotels.ListByExpression(ctx, goqu.And(goqu.C("stars").Between(goqu.Range(1, 3))))
+		Expect(err).Should(MatchError(memory.ErrUnsupportedExpression))
+	})
+})
@@ -0,0 +1,70 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/shopspring/decimal"
+)
+
+func TestMemory(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Memory Suite")
+}
+
+type hotel struct {
+	Id        int64           `db:"id" primary:"1"`
+	Name      string          `db:"name" conflict_target:"1"`
+	CityId    *int64          `db:"city_id"`
+	Stars     int64           `db:"stars"`
+	Price     decimal.Decimal `db:"price"`
+	Version   int64           `db:"version" version:"1"`
+	CreatedAt time.Time       `db:"created_at"`
+	UpdatedAt time.Time       `db:"updated_at"`
+	DeletedAt *time.Time      `db:"deleted_at"`
+}
+
+type room struct {
+	Id   string `db:"id" primary:"1"`
+	Name string `db:"name"`
+}
+
+var errHook = errors.New("hook failed")
+
+// hookedRoom записывает вызовы хуков в hookCalls и возвращает ошибку для имени с префиксом fail
+type hookedRoom struct {
+	Id   int64  `db:"id" primary:"1"`
+	Name string `db:"name"`
+}
+
+var hookCalls []string
+
+func (r *hookedRoom) call(name string) error {
+	hookCalls = append(hookCalls, name+" "+r.Name)
+	if strings.HasPrefix(r.Name, "fail"+name) {
+		return errHook
+	}
+	return nil
+}
+
+func (r *hookedRoom) BeforeCreate(context.Context) error {
+	r.Name = strings.TrimSpace(r.Name)
+	return r.call("BeforeCreate")
+}
+
+func (r *hookedRoom) AfterCreate(context.Context, int64) error { return r.call("AfterCreate") }
+
+func (r *hookedRoom) BeforeUpdate(context.Context) error { return r.call("BeforeUpdate") }
+
+func (r *hookedRoom) AfterUpdate(context.Context) error { return r.call("AfterUpdate") }
+
+func (r *hookedRoom) BeforeDelete(context.Context) error { return r.call("BeforeDelete") }
+
+func ptr[V any](v V) *V {
+	return &v
+}
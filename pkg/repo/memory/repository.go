@@ -0,0 +1,968 @@
+// Package memory реализация repo.BaseRepo в памяти для быстрых unit тестов сервисов без базы
+package memory
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/EveryHotel/core-tools/pkg/database"
+	"github.com/EveryHotel/core-tools/pkg/repo"
+)
+
+// uniqueViolationCode код ошибки postgres при нарушении уникальности
+const uniqueViolationCode = "23505"
+
+type state[T any, ID int64 | string] struct {
+	items map[ID]T
+	// ids порядок вставки, в нем возвращаются сущности без явной сортировки
+	ids    []ID
+	lastId int64
+}
+
+func (s state[T, ID]) clone() state[T, ID] {
+	items := make(map[ID]T, len(s.items))
+	for id, item := range s.items {
+		items[id] = item
+	}
+
+	return state[T, ID]{items: items, ids: slices.Clone(s.ids), lastId: s.lastId}
+}
+
+type repository[T any, ID int64 | string] struct {
+	// write выполняет изменения последовательно, mu защищает подмену state
+	write sync.Mutex
+	mu    sync.RWMutex
+	state state[T, ID]
+
+	byColumn       map[string]database.StructField
+	primary        database.StructField
+	conflictTarget string
+	softDeleting   bool
+}
+
+// NewRepository создает репозиторий в памяти, заполненный entities
+//
+// Сущности хранятся по значению primary поля: int64 id без not_serial генерируется автоинкрементом,
+// string - uuid. Повтор primary или значения колонки conflict_target возвращает ошибку postgres 23505.
+// Soft удаление, версии, хуки сущности, TrashedScope и арендатор из контекста и критерии goqu.Ex работают как в repo.BaseRepo.
+// Связи не подгружаются (возвращаются так, как были сохранены), Preload ничего не делает,
+// блокировки строк игнорируются, DistinctOn и GroupBy возвращают ErrUnsupportedExpression,
+// а repo.Aggregate - repo.ErrAggregateNotSupported.
+//
+// Изменения выполняются последовательно над копией состояния и видны остальным только после успешного завершения,
+// как транзакции. Хуки сущности вызываются внутри изменения: читать из них репозиторий можно,
+// а изменять тот же репозиторий нельзя - это приведет к взаимной блокировке
+func NewRepository[T any, ID int64 | string](entities ...T) repo.BaseRepo[T, ID] {
+	r := &repository[T, ID]{
+		state:        state[T, ID]{items: map[ID]T{}},
+		byColumn:     map[string]database.StructField{},
+		softDeleting: repo.IsSoftDeletingEntity(*new(T)),
+	}
+
+	for _, field := range database.StructFields(reflect.TypeOf(*new(T))) {
+		if _, ok := r.byColumn[field.Column]; !ok {
+			r.byColumn[field.Column] = field
+		}
+		if field.Primary && !field.Embedded {
+			r.primary = field
+		}
+	}
+
+	if target, _ := repo.BuildConflictUpdate(*new(T)); target != r.primary.Column {
+		r.conflictTarget = target
+	}
+
+	for _, entity := range entities {
+		if _, err := r.insert(&r.state, entity); err != nil {
+			panic(err)
+		}
+	}
+
+	return r
+}
+
+func (r *repository[T, ID]) Create(ctx context.Context, entity T, _ ...repo.SqlQueryOption) (ID, error) {
+	var id ID
+	err := r.atomic(func(s *state[T, ID]) error {
+		if err := repo.BeforeCreate(ctx, &entity); err != nil {
+			return err
+		}
+		if err := r.setTenant(ctx, &entity); err != nil {
+			return err
+		}
+
+		var err error
+		if id, err = r.insert(s, entity); err != nil {
+			return err
+		}
+
+		return repo.AfterCreate(ctx, &entity, id)
+	})
+
+	return id, err
+}
+
+func (r *repository[T, ID]) CreateMultiple(ctx context.Context, entities []T, _ ...repo.SqlQueryOption) ([]ID, error) {
+	if len(entities) == 0 {
+		return nil, nil
+	}
+
+	entities = slices.Clone(entities)
+	ids := make([]ID, len(entities))
+	err := r.atomic(func(s *state[T, ID]) error {
+		for i := range entities {
+			if err := repo.BeforeCreate(ctx, &entities[i]); err != nil {
+				return err
+			}
+			if err := r.setTenant(ctx, &entities[i]); err != nil {
+				return err
+			}
+		}
+
+		for i, entity := range entities {
+			var err error
+			if ids[i], err = r.insert(s, entity); err != nil {
+				return err
+			}
+		}
+
+		for i := range entities {
+			if err := repo.AfterCreate(ctx, &entities[i], ids[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func (r *repository[T, ID]) BulkInsert(ctx context.Context, entities []T) (int64, error) {
+	entities = slices.Clone(entities)
+	err := r.atomic(func(s *state[T, ID]) error {
+		for i := range entities {
+			if err := repo.BeforeCreate(ctx, &entities[i]); err != nil {
+				return err
+			}
+			if err := r.setTenant(ctx, &entities[i]); err != nil {
+				return err
+			}
+		}
+
+		for _, entity := range entities {
+			if _, err := r.insert(s, entity); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(entities)), nil
+}
+
+func (r *repository[T, ID]) Update(ctx context.Context, entity T, _ ...repo.SqlQueryOption) error {
+	tenant, err := r.tenantCriteria(ctx, goqu.And())
+	if err != nil {
+		return err
+	}
+
+	return r.atomic(func(s *state[T, ID]) error {
+		if err := repo.BeforeUpdate(ctx, &entity); err != nil {
+			return err
+		}
+		if err := r.setTenant(ctx, &entity); err != nil {
+			return err
+		}
+
+		// запись другого арендатора не обновляется, как и в repo.BaseRepo
+		id := r.primaryValue(entity)
+		stored, ok := s.items[id]
+		if ok {
+			owned, err := r.match(reflect.ValueOf(stored), tenant)
+			if err != nil {
+				return err
+			}
+			if !owned {
+				return fmt.Errorf("%w: id %v", repo.ErrTenantMismatch, id)
+			}
+			if err := r.checkVersion(stored, entity); err != nil {
+				return err
+			}
+			s.items[id] = r.merge(stored, entity, nil)
+		} else if _, _, versioned := repo.GetEntityVersion(entity); versioned {
+			return fmt.Errorf("%w: id %v", repo.ErrStaleEntity, id)
+		}
+
+		return repo.AfterUpdate(ctx, &entity)
+	})
+}
+
+func (r *repository[T, ID]) UpdateMultiple(ctx context.Context, entities []T, _ ...repo.SqlQueryOption) error {
+	if len(entities) == 0 {
+		return nil
+	}
+
+	_, updateFields := repo.BuildConflictUpdate(entities[0])
+	columns := make([]string, 0, len(updateFields))
+	for column := range updateFields {
+		columns = append(columns, column)
+	}
+
+	tenant, err := r.tenantCriteria(ctx, goqu.And())
+	if err != nil {
+		return err
+	}
+
+	entities = slices.Clone(entities)
+	return r.atomic(func(s *state[T, ID]) error {
+		for i := range entities {
+			if err := repo.BeforeUpdate(ctx, &entities[i]); err != nil {
+				return err
+			}
+			if err := r.setTenant(ctx, &entities[i]); err != nil {
+				return err
+			}
+		}
+
+		stale, mismatch := 0, 0
+		for _, entity := range entities {
+			// как INSERT ... ON CONFLICT DO UPDATE: обновляем найденную по conflict_target запись или добавляем новую
+			id, found := r.findConflict(s, entity)
+			if !found {
+				if r.conflictTarget != "" && !r.primary.NotSerial {
+					r.setPrimary(&entity, *new(ID))
+				}
+				if _, err := r.insert(s, entity); err != nil {
+					return err
+				}
+				continue
+			}
+
+			stored := s.items[id]
+			owned, err := r.match(reflect.ValueOf(stored), tenant)
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case !owned:
+				mismatch++
+			case r.checkVersion(stored, entity) != nil:
+				stale++
+			default:
+				s.items[id] = r.merge(stored, entity, columns)
+			}
+		}
+
+		if mismatch > 0 {
+			return fmt.Errorf("%w: %d rows of another tenant", repo.ErrTenantMismatch, mismatch)
+		}
+		if stale > 0 {
+			return fmt.Errorf("%w: %d of %d rows updated", repo.ErrStaleEntity, len(entities)-stale, len(entities))
+		}
+
+		for i := range entities {
+			if err := repo.AfterUpdate(ctx, &entities[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (r *repository[T, ID]) Patch(ctx context.Context, id ID, patch any, _ ...repo.SqlQueryOption) error {
+	rows, err := repo.SanitizePatchRows(patch)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	if err = repo.SetTenant(ctx, reflect.TypeFor[T](), rows); err != nil {
+		return err
+	}
+	criteria, err := r.tenantCriteria(ctx, goqu.C(r.primary.Column).Eq(id))
+	if err != nil {
+		return err
+	}
+
+	return r.atomic(func(s *state[T, ID]) error {
+		ids, err := r.matching(s, criteria, repo.TrashedInclude)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return r.missing(s, id)
+		}
+
+		entity := s.items[id]
+		// переданная в patch версия должна совпадать с сохраненной, как в repo.BaseRepo
+		column, version, versioned := repo.GetEntityVersion(entity)
+		if expected, ok := rows[column]; versioned && ok && !equal(normalize(expected), normalize(version)) {
+			return fmt.Errorf("%w: id %v, version %v", repo.ErrStaleEntity, id, expected)
+		}
+
+		vEntity := reflect.ValueOf(&entity).Elem()
+		if err = r.setColumns(vEntity, rows); err != nil {
+			return err
+		}
+		if field, ok := r.byColumn["updated_at"]; ok {
+			setNow(vEntity.FieldByIndex(field.Index), time.Now())
+		}
+		if versioned {
+			_ = setValue(vEntity.FieldByIndex(r.byColumn[column].Index), version+1)
+		}
+		s.items[id] = entity
+
+		return nil
+	})
+}
+
+func (r *repository[T, ID]) BulkUpdate(ctx context.Context, updateFields, where map[string]any, _ ...repo.SqlQueryOption) error {
+	updateFields = maps.Clone(updateFields)
+	if updateFields == nil {
+		updateFields = map[string]any{}
+	}
+	if err := repo.SetTenant(ctx, reflect.TypeFor[T](), updateFields); err != nil {
+		return err
+	}
+	criteria, err := r.tenantCriteria(ctx, goqu.Ex(where))
+	if err != nil {
+		return err
+	}
+
+	return r.atomic(func(s *state[T, ID]) error {
+		ids, err := r.matching(s, criteria, repo.TrashedInclude)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			entity := s.items[id]
+			if err = r.setColumns(reflect.ValueOf(&entity).Elem(), updateFields); err != nil {
+				return err
+			}
+			s.items[id] = entity
+		}
+
+		return nil
+	})
+}
+
+func (r *repository[T, ID]) Get(ctx context.Context, id ID, _ ...repo.ListOptionRelation) (T, error) {
+	return r.GetOneByExpression(ctx, goqu.And(goqu.C(r.primary.Column).Eq(id)))
+}
+
+func (r *repository[T, ID]) GetOneBy(ctx context.Context, conditions map[string]any, _ ...repo.ListOptionRelation) (T, error) {
+	return r.GetOneByExpression(ctx, goqu.And(goqu.Ex(conditions)))
+}
+
+func (r *repository[T, ID]) GetOneByExpression(ctx context.Context, criteria exp.ExpressionList, options ...repo.ListOption) (T, error) {
+	res, err := r.ListByExpression(ctx, criteria, append(options, repo.WithLimit(1))...)
+	if err != nil {
+		return *new(T), err
+	}
+	if len(res) == 0 {
+		return *new(T), pgx.ErrNoRows
+	}
+
+	return res[0], nil
+}
+
+func (r *repository[T, ID]) List(ctx context.Context, _ ...repo.SqlQueryOption) ([]T, error) {
+	return r.ListByExpression(ctx, goqu.And())
+}
+
+func (r *repository[T, ID]) ListBy(ctx context.Context, criteria map[string]any, options ...repo.ListOption) ([]T, error) {
+	return r.ListByExpression(ctx, goqu.And(goqu.Ex(criteria)), options...)
+}
+
+func (r *repository[T, ID]) ListByExpression(ctx context.Context, criteria exp.ExpressionList, options ...repo.ListOption) ([]T, error) {
+	optHandler := repo.NewListOptionHandler()
+	for _, opt := range options {
+		opt(optHandler)
+	}
+
+	if len(optHandler.DistinctOn) > 0 || len(optHandler.GroupBy) > 0 || len(optHandler.Having) > 0 {
+		return nil, fmt.Errorf("%w: DISTINCT ON and GROUP BY", ErrUnsupportedExpression)
+	}
+
+	if optHandler.Cursor != nil && optHandler.Cursor.Value != nil {
+		column := goqu.I(optHandler.Cursor.Column)
+		if optHandler.Cursor.Desc {
+			criteria = criteria.Append(column.Lt(optHandler.Cursor.Value))
+		} else {
+			criteria = criteria.Append(column.Gt(optHandler.Cursor.Value))
+		}
+	}
+
+	scoped, err := r.tenantCriteria(ctx, criteria)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids, err := r.matching(&r.state, scoped, r.trashedScope(ctx, optHandler))
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]T, 0, len(ids))
+	for _, id := range ids {
+		res = append(res, r.state.items[id])
+	}
+
+	if err = r.sort(res, optHandler); err != nil {
+		return nil, err
+	}
+
+	if optHandler.Offset > 0 {
+		res = res[min(int(optHandler.Offset), len(res)):]
+	}
+	if optHandler.Limit > 0 {
+		res = res[:min(int(optHandler.Limit), len(res))]
+	}
+
+	if len(optHandler.Columns) > 0 {
+		if err = r.project(res, optHandler.Columns); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+func (r *repository[T, ID]) Preload(context.Context, []T, ...repo.ListOptionPreload) error {
+	return nil
+}
+
+func (r *repository[T, ID]) Iterate(ctx context.Context, criteria map[string]any, fn func(T) error, options ...repo.ListOption) error {
+	res, err := r.ListBy(ctx, criteria, options...)
+	if err != nil {
+		return err
+	}
+
+	for _, entity := range res {
+		if err = fn(entity); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *repository[T, ID]) ListPage(ctx context.Context, criteria exp.ExpressionList, options ...repo.ListOption) ([]T, int64, error) {
+	items, err := r.ListByExpression(ctx, criteria, options...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total, err := r.CountByExpression(ctx, criteria, options...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return items, total, nil
+}
+
+func (r *repository[T, ID]) CountByExpression(ctx context.Context, criteria exp.ExpressionList, options ...repo.ListOption) (int64, error) {
+	optHandler := repo.NewListOptionHandler()
+	for _, opt := range options {
+		opt(optHandler)
+	}
+
+	scoped, err := r.tenantCriteria(ctx, criteria)
+	if err != nil {
+		return 0, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids, err := r.matching(&r.state, scoped, r.trashedScope(ctx, optHandler))
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(ids)), nil
+}
+
+func (r *repository[T, ID]) Delete(ctx context.Context, id ID, _ ...repo.SqlQueryOption) error {
+	return r.delete(ctx, goqu.C(r.primary.Column).Eq(id), r.softDeleting)
+}
+
+func (r *repository[T, ID]) DeleteBy(ctx context.Context, criteria map[string]any, _ ...repo.SqlQueryOption) error {
+	return r.delete(ctx, goqu.Ex(criteria), r.softDeleting)
+}
+
+func (r *repository[T, ID]) ForceDelete(ctx context.Context, id ID, _ ...repo.SqlQueryOption) error {
+	return r.delete(ctx, goqu.C(r.primary.Column).Eq(id), false)
+}
+
+func (r *repository[T, ID]) ForceDeleteBy(ctx context.Context, criteria map[string]any, _ ...repo.SqlQueryOption) error {
+	return r.delete(ctx, goqu.Ex(criteria), false)
+}
+
+func (r *repository[T, ID]) ForceDeleteMultiple(ctx context.Context, ids []ID) error {
+	return r.delete(ctx, goqu.C(r.primary.Column).In(ids), false)
+}
+
+func (r *repository[T, ID]) SoftDelete(ctx context.Context, id ID, _ ...repo.SqlQueryOption) error {
+	if !r.softDeleting {
+		return repo.ErrNotSoftDeletingEntity
+	}
+
+	return r.delete(ctx, goqu.C(r.primary.Column).Eq(id), true)
+}
+
+func (r *repository[T, ID]) SoftDeleteMultiple(ctx context.Context, ids []ID) error {
+	if !r.softDeleting {
+		return repo.ErrNotSoftDeletingEntity
+	}
+
+	return r.delete(ctx, goqu.C(r.primary.Column).In(ids), true)
+}
+
+func (r *repository[T, ID]) Restore(ctx context.Context, id ID, _ ...repo.SqlQueryOption) error {
+	if !r.softDeleting {
+		return repo.ErrNotSoftDeletingEntity
+	}
+
+	criteria, err := r.tenantCriteria(ctx, goqu.C(r.primary.Column).Eq(id))
+	if err != nil {
+		return err
+	}
+
+	return r.atomic(func(s *state[T, ID]) error {
+		ids, err := r.matching(s, criteria, repo.TrashedInclude)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return r.missing(s, id)
+		}
+
+		entity := s.items[id]
+		if err = r.setColumns(reflect.ValueOf(&entity).Elem(), map[string]any{"deleted_at": nil}); err != nil {
+			return err
+		}
+		s.items[id] = entity
+
+		return nil
+	})
+}
+
+func (r *repository[T, ID]) RestoreBy(ctx context.Context, criteria map[string]any, _ ...repo.SqlQueryOption) error {
+	if !r.softDeleting {
+		return repo.ErrNotSoftDeletingEntity
+	}
+
+	return r.BulkUpdate(ctx, map[string]any{"deleted_at": nil}, criteria)
+}
+
+// DeleteAndMoveReferences удаляет сущность id, ссылок из других таблиц в памяти нет
+// Как и в repo.BaseRepo, обе сущности должны принадлежать арендатору из контекста
+func (r *repository[T, ID]) DeleteAndMoveReferences(ctx context.Context, id ID, newId ID) error {
+	if id == newId {
+		return fmt.Errorf("%w: id %v", repo.ErrMoveReferencesToSelf, id)
+	}
+
+	_, _, scoped, err := repo.TenantScope(ctx, reflect.TypeFor[T]())
+	if err != nil {
+		return err
+	}
+	if scoped {
+		count, err := r.CountByExpression(ctx, goqu.And(goqu.C(r.primary.Column).In([]ID{id, newId})), repo.WithTrashed())
+		if err != nil {
+			return err
+		}
+		if count < 2 {
+			return fmt.Errorf("%w: id %v or %v", repo.ErrTenantMismatch, id, newId)
+		}
+	}
+
+	return r.Delete(ctx, id)
+}
+
+// atomic выполняет fn над копией состояния и сохраняет копию только при успехе, как транзакцию
+// Изменения выполняются по одному, поэтому параллельные записи не теряются
+func (r *repository[T, ID]) atomic(fn func(s *state[T, ID]) error) error {
+	r.write.Lock()
+	defer r.write.Unlock()
+
+	// state меняется только под write, поэтому копировать его можно без mu
+	s := r.state.clone()
+	if err := fn(&s); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.state = s
+	r.mu.Unlock()
+
+	return nil
+}
+
+// insert добавляет сущность в s и возвращает ее id
+func (r *repository[T, ID]) insert(s *state[T, ID], entity T) (ID, error) {
+	id := r.primaryValue(entity)
+	if id == *new(ID) && !r.primary.NotSerial {
+		switch any(id).(type) {
+		case int64:
+			id = any(s.lastId + 1).(ID)
+		case string:
+			id = any(uuid.NewString()).(ID)
+		}
+		r.setPrimary(&entity, id)
+	}
+
+	if _, ok := s.items[id]; ok {
+		return id, uniqueViolation(r.primary.Column, id)
+	}
+	if _, found := r.findConflict(s, entity); found && r.conflictTarget != "" {
+		return id, uniqueViolation(r.conflictTarget, reflect.ValueOf(entity).FieldByIndex(r.byColumn[r.conflictTarget].Index).Interface())
+	}
+
+	if intId, ok := any(id).(int64); ok && intId > s.lastId {
+		s.lastId = intId
+	}
+
+	vEntity := reflect.ValueOf(&entity).Elem()
+	now := time.Now()
+	for _, column := range []string{"created_at", "updated_at"} {
+		if field, ok := r.byColumn[column]; ok {
+			setNow(vEntity.FieldByIndex(field.Index), now)
+		}
+	}
+
+	s.items[id] = entity
+	s.ids = append(s.ids, id)
+
+	return id, nil
+}
+
+// delete удаляет или помечает удаленными сущности по выражению, предварительно вызвав BeforeDelete
+func (r *repository[T, ID]) delete(ctx context.Context, criteria exp.Expression, soft bool) error {
+	criteria, err := r.tenantCriteria(ctx, criteria)
+	if err != nil {
+		return err
+	}
+
+	return r.atomic(func(s *state[T, ID]) error {
+		ids, err := r.matching(s, criteria, repo.TrashedInclude)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			entity := s.items[id]
+			if err = repo.BeforeDelete(ctx, &entity); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		for _, id := range ids {
+			if soft {
+				entity := s.items[id]
+				setNow(reflect.ValueOf(&entity).Elem().FieldByIndex(r.byColumn["deleted_at"].Index), now)
+				s.items[id] = entity
+				continue
+			}
+
+			delete(s.items, id)
+			s.ids = slices.DeleteFunc(s.ids, func(item ID) bool {
+				return item == id
+			})
+		}
+
+		return nil
+	})
+}
+
+// matching возвращает id сущностей s, подходящих под выражение, в порядке вставки
+func (r *repository[T, ID]) matching(s *state[T, ID], criteria exp.Expression, scope repo.TrashedScope) ([]ID, error) {
+	var res []ID
+	for _, id := range s.ids {
+		entity := s.items[id]
+		vEntity := reflect.ValueOf(&entity).Elem()
+
+		if r.softDeleting {
+			deleted := normalize(vEntity.FieldByIndex(r.byColumn["deleted_at"].Index).Interface()) != nil
+			if (scope == repo.TrashedExclude && deleted) || (scope == repo.TrashedOnly && !deleted) {
+				continue
+			}
+		}
+
+		ok, err := r.match(vEntity, criteria)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			res = append(res, id)
+		}
+	}
+
+	return res, nil
+}
+
+// missing возвращает ошибку для сущности id, не найденной с условием по арендатору:
+// существующая запись принадлежит другому арендатору - repo.ErrTenantMismatch, иначе pgx.ErrNoRows
+func (r *repository[T, ID]) missing(s *state[T, ID], id ID) error {
+	if _, ok := s.items[id]; ok {
+		return fmt.Errorf("%w: id %v", repo.ErrTenantMismatch, id)
+	}
+
+	return fmt.Errorf("%w: id %v", pgx.ErrNoRows, id)
+}
+
+// tenantCriteria добавляет к criteria условие по арендатору из контекста для сущностей с колонкой tenant
+func (r *repository[T, ID]) tenantCriteria(ctx context.Context, criteria exp.Expression) (exp.Expression, error) {
+	column, tenant, scoped, err := repo.TenantScope(ctx, reflect.TypeFor[T]())
+	if err != nil || !scoped {
+		return criteria, err
+	}
+
+	return goqu.And(criteria, goqu.C(column).Eq(tenant)), nil
+}
+
+// setTenant проставляет в сущность арендатора из контекста, другой арендатор в сущности - repo.ErrTenantMismatch
+func (r *repository[T, ID]) setTenant(ctx context.Context, entity *T) error {
+	column, ok := repo.TenantColumn(reflect.TypeFor[T]())
+	if !ok {
+		return nil
+	}
+
+	field := reflect.ValueOf(entity).Elem().FieldByIndex(r.byColumn[column].Index)
+	rows := map[string]any{column: field.Interface()}
+	if err := repo.SetTenant(ctx, reflect.TypeFor[T](), rows); err != nil {
+		return err
+	}
+
+	return setValue(field, rows[column])
+}
+
+func (r *repository[T, ID]) trashedScope(ctx context.Context, optHandler *repo.ListOptionHandler) repo.TrashedScope {
+	if optHandler.Trashed == repo.TrashedExclude {
+		if ctxScope, ok := ctx.Value(repo.CtxTrashedScope).(repo.TrashedScope); ok {
+			return ctxScope
+		}
+	}
+
+	return optHandler.Trashed
+}
+
+// sort сортирует по курсору и WithSort, NULL как в postgres: последними при ASC и первыми при DESC
+func (r *repository[T, ID]) sort(items []T, optHandler *repo.ListOptionHandler) error {
+	var order []exp.OrderedExpression
+	if cursor := optHandler.Cursor; cursor != nil {
+		if cursor.Desc {
+			order = append(order, goqu.I(cursor.Column).Desc())
+		} else {
+			order = append(order, goqu.I(cursor.Column).Asc())
+		}
+	}
+	order = append(order, optHandler.Sort...)
+	if len(order) == 0 {
+		return nil
+	}
+
+	fields := make([]database.StructField, len(order))
+	for i, o := range order {
+		column, err := columnName(o.SortExpression())
+		if err != nil {
+			return err
+		}
+		field, ok := r.byColumn[column]
+		if !ok {
+			return fmt.Errorf("%w: %s", repo.ErrUnknownColumn, column)
+		}
+		fields[i] = field
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := reflect.ValueOf(items[i]), reflect.ValueOf(items[j])
+		for k, o := range order {
+			av := normalize(a.FieldByIndex(fields[k].Index).Interface())
+			bv := normalize(b.FieldByIndex(fields[k].Index).Interface())
+
+			if av == nil || bv == nil {
+				if av == nil && bv == nil {
+					continue
+				}
+				nullsFirst := !o.IsAsc()
+				switch o.NullSortType() {
+				case exp.NullsFirstSortType:
+					nullsFirst = true
+				case exp.NullsLastSortType:
+					nullsFirst = false
+				}
+				return (av == nil) == nullsFirst
+			}
+
+			cmp, _ := compare(av, bv)
+			if cmp == 0 {
+				continue
+			}
+			return (cmp < 0) == o.IsAsc()
+		}
+		return false
+	})
+
+	return nil
+}
+
+// project оставляет в сущностях только колонки из WithColumns
+func (r *repository[T, ID]) project(items []T, columns []any) error {
+	var fields []database.StructField
+	for _, column := range columns {
+		name, ok := column.(string)
+		if !ok {
+			return fmt.Errorf("%w: column %T", ErrUnsupportedExpression, column)
+		}
+		name = name[strings.LastIndex(name, ".")+1:]
+
+		field, ok := r.byColumn[name]
+		if !ok {
+			return fmt.Errorf("%w: %s", repo.ErrUnknownColumn, column)
+		}
+		fields = append(fields, field)
+	}
+
+	for i := range items {
+		var projected T
+		vItem, vProjected := reflect.ValueOf(items[i]), reflect.ValueOf(&projected).Elem()
+		for _, field := range fields {
+			vProjected.FieldByIndex(field.Index).Set(vItem.FieldByIndex(field.Index))
+		}
+		items[i] = projected
+	}
+
+	return nil
+}
+
+// merge возвращает сохраненную сущность с колонками из entity, как после UPDATE
+// columns - обновляемые колонки, nil - все кроме primary и created_at
+func (r *repository[T, ID]) merge(stored, entity T, columns []string) T {
+	vStored, vEntity := reflect.ValueOf(&stored).Elem(), reflect.ValueOf(entity)
+	for column, field := range r.byColumn {
+		if column == r.primary.Column || column == "created_at" {
+			continue
+		}
+		if columns != nil && !slices.Contains(columns, column) {
+			continue
+		}
+		vStored.FieldByIndex(field.Index).Set(vEntity.FieldByIndex(field.Index))
+	}
+
+	if field, ok := r.byColumn["updated_at"]; ok {
+		setNow(vStored.FieldByIndex(field.Index), time.Now())
+	}
+	if column, version, versioned := repo.GetEntityVersion(entity); versioned {
+		_ = setValue(vStored.FieldByIndex(r.byColumn[column].Index), version+1)
+	}
+
+	return stored
+}
+
+// checkVersion проверяет совпадение версий для оптимистичной блокировки
+func (r *repository[T, ID]) checkVersion(stored, entity T) error {
+	_, version, versioned := repo.GetEntityVersion(entity)
+	if !versioned {
+		return nil
+	}
+
+	if _, storedVersion, _ := repo.GetEntityVersion(stored); storedVersion != version {
+		return fmt.Errorf("%w: id %v, version %d", repo.ErrStaleEntity, r.primaryValue(entity), version)
+	}
+
+	return nil
+}
+
+// findConflict ищет в s сущность с тем же значением conflict_target, а если его нет - с тем же primary
+func (r *repository[T, ID]) findConflict(s *state[T, ID], entity T) (ID, bool) {
+	if r.conflictTarget == "" {
+		id := r.primaryValue(entity)
+		_, ok := s.items[id]
+		return id, ok
+	}
+
+	index := r.byColumn[r.conflictTarget].Index
+	value := normalize(reflect.ValueOf(entity).FieldByIndex(index).Interface())
+	// NULL в уникальной колонке не конфликтует
+	if value == nil {
+		return *new(ID), false
+	}
+
+	for _, id := range s.ids {
+		if equal(value, normalize(reflect.ValueOf(s.items[id]).FieldByIndex(index).Interface())) {
+			return id, true
+		}
+	}
+
+	return *new(ID), false
+}
+
+func (r *repository[T, ID]) setColumns(vEntity reflect.Value, values map[string]any) error {
+	for column, value := range values {
+		field, err := r.field(vEntity, column[strings.LastIndex(column, ".")+1:])
+		if err != nil {
+			return err
+		}
+		if err = setValue(field, value); err != nil {
+			return fmt.Errorf("column %s: %w", column, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *repository[T, ID]) field(vEntity reflect.Value, column string) (reflect.Value, error) {
+	field, ok := r.byColumn[column]
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("%w: %s", repo.ErrUnknownColumn, column)
+	}
+
+	return vEntity.FieldByIndex(field.Index), nil
+}
+
+func (r *repository[T, ID]) primaryValue(entity T) ID {
+	return reflect.ValueOf(entity).FieldByIndex(r.primary.Index).Interface().(ID)
+}
+
+func (r *repository[T, ID]) setPrimary(entity *T, id ID) {
+	reflect.ValueOf(entity).Elem().FieldByIndex(r.primary.Index).Set(reflect.ValueOf(id))
+}
+
+func uniqueViolation(column string, value any) error {
+	return &pgconn.PgError{
+		Code:       uniqueViolationCode,
+		Message:    fmt.Sprintf("duplicate key value violates unique constraint: %s = %v", column, value),
+		ColumnName: column,
+	}
+}
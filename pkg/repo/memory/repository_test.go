@@ -0,0 +1,370 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/EveryHotel/core-tools/pkg/repo"
+	"github.com/EveryHotel/core-tools/pkg/repo/memory"
+	"github.com/EveryHotel/core-tools/pkg/types"
+)
+
+type hotelPatch struct {
+	Stars   types.Omitempty[int64] `db:"stars"`
+	Version types.Omitempty[int64] `db:"version"`
+}
+
+var _ = Describe("Repository", func() {
+	var (
+		ctx    context.Context
+		hotels repo.BaseRepo[hotel, int64]
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		hotels = memory.NewRepository[hotel, int64](
+			hotel{Name: "Alfa", Stars: 3},
+			hotel{Name: "Beta", Stars: 5},
+			hotel{Name: "Gamma", Stars: 4},
+		)
+	})
+
+	names := func(list []hotel) []string {
+		res := make([]string, len(list))
+		for i, item := range list {
+			res[i] = item.Name
+		}
+		return res
+	}
+
+	get := func(id int64) hotel {
+		stored, err := hotels.Get(ctx, id)
+		Expect(err).ShouldNot(HaveOccurred())
+		return stored
+	}
+
+	Describe("Create", func() {
+		It("generates int and string ids and sets timestamps", func() {
+			id, err := hotels.Create(ctx, hotel{Name: "Delta"})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(id).Should(Equal(int64(4)))
+			Expect(get(id).CreatedAt).ShouldNot(BeZero())
+			Expect(get(id).UpdatedAt).ShouldNot(BeZero())
+
+			rooms := memory.NewRepository[room, string]()
+			roomId, err := rooms.Create(ctx, room{Name: "Suite"})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(roomId).ShouldNot(BeEmpty())
+		})
+
+		It("returns unique violation for duplicate primary or conflict target", func() {
+			var pgErr *pgconn.PgError
+
+			_, err := hotels.Create(ctx, hotel{Id: 1, Name: "Delta"})
+			Expect(errors.As(err, &pgErr)).Should(BeTrue())
+			Expect(pgErr.Code).Should(Equal("23505"))
+
+			_, err = hotels.Create(ctx, hotel{Name: "Alfa"})
+			Expect(errors.As(err, &pgErr)).Should(BeTrue())
+			Expect(pgErr.ColumnName).Should(Equal("name"))
+		})
+
+		It("creates multiple entities atomically", func() {
+			ids, err := hotels.CreateMultiple(ctx, []hotel{{Name: "Delta"}, {Name: "Omega"}})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(ids).Should(Equal([]int64{4, 5}))
+
+			_, err = hotels.CreateMultiple(ctx, []hotel{{Name: "Sigma"}, {Name: "Alfa"}})
+			Expect(err).Should(HaveOccurred())
+
+			count, err := hotels.CountByExpression(ctx, goqu.And())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(count).Should(Equal(int64(5)))
+		})
+
+		It("bulk inserts entities", func() {
+			count, err := hotels.BulkInsert(ctx, []hotel{{Name: "Delta"}, {Name: "Omega"}})
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(count).Should(Equal(int64(2)))
+			Expect(get(5).Name).Should(Equal("Omega"))
+		})
+	})
+
+	Describe("Update", func() {
+		It("updates entity and increments version", func() {
+			stored := get(1)
+			stored.Stars = 4
+			Expect(hotels.Update(ctx, stored)).Should(Succeed())
+
+			updated := get(1)
+			Expect(updated.Stars).Should(Equal(int64(4)))
+			Expect(updated.Version).Should(Equal(int64(1)))
+			Expect(updated.CreatedAt).Should(Equal(stored.CreatedAt))
+		})
+
+		It("rejects stale entity", func() {
+			stale := get(1)
+			Expect(hotels.Update(ctx, stale)).Should(Succeed())
+
+			Expect(hotels.Update(ctx, stale)).Should(MatchError(repo.ErrStaleEntity))
+			Expect(hotels.Update(ctx, hotel{Id: 10, Name: "Missing"})).Should(MatchError(repo.ErrStaleEntity))
+		})
+
+		It("updates by conflict target and inserts missing entities", func() {
+			Expect(hotels.UpdateMultiple(ctx, []hotel{{Name: "Alfa", Stars: 1}, {Name: "Delta", Stars: 2}})).Should(Succeed())
+
+			Expect(get(1).Stars).Should(Equal(int64(1)))
+			Expect(get(4).Name).Should(Equal("Delta"))
+		})
+
+		It("rolls back multiple update with stale entity", func() {
+			Expect(hotels.Update(ctx, get(2))).Should(Succeed())
+
+			err := hotels.UpdateMultiple(ctx, []hotel{{Name: "Alfa", Stars: 1}, {Name: "Beta", Stars: 1}})
+
+			Expect(err).Should(MatchError(repo.ErrStaleEntity))
+			Expect(get(1).Stars).Should(Equal(int64(3)))
+		})
+
+		It("patches passed fields", func() {
+			Expect(hotels.Patch(ctx, 1, hotelPatch{Stars: types.Omitempty[int64]{Value: 1, Valid: true}})).Should(Succeed())
+			Expect(get(1).Stars).Should(Equal(int64(1)))
+
+			Expect(hotels.Patch(ctx, 1, map[string]any{"stars": 2})).Should(MatchError(repo.ErrInvalidPatch))
+			Expect(hotels.Patch(ctx, 9, hotelPatch{Stars: types.Omitempty[int64]{Value: 1, Valid: true}})).Should(MatchError(pgx.ErrNoRows))
+		})
+
+		It("increments and checks version on patch", func() {
+			stale := get(1)
+
+			Expect(hotels.Patch(ctx, 1, hotelPatch{Stars: types.Omitempty[int64]{Value: 1, Valid: true}})).Should(Succeed())
+			Expect(get(1).Version).Should(Equal(stale.Version + 1))
+			Expect(hotels.Update(ctx, stale)).Should(MatchError(repo.ErrStaleEntity))
+
+			patch := hotelPatch{
+				Stars:   types.Omitempty[int64]{Value: 2, Valid: true},
+				Version: types.Omitempty[int64]{Value: stale.Version, Valid: true},
+			}
+			Expect(hotels.Patch(ctx, 1, patch)).Should(MatchError(repo.ErrStaleEntity))
+
+			patch.Version.Value = get(1).Version
+			Expect(hotels.Patch(ctx, 1, patch)).Should(Succeed())
+			Expect(get(1).Stars).Should(Equal(int64(2)))
+		})
+
+		It("bulk updates by criteria", func() {
+			Expect(hotels.BulkUpdate(ctx, map[string]any{"stars": 2}, map[string]any{"name": []string{"Alfa", "Gamma"}})).Should(Succeed())
+
+			list, err := hotels.ListBy(ctx, map[string]any{"stars": 2})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(names(list)).Should(Equal([]string{"Alfa", "Gamma"}))
+		})
+	})
+
+	Describe("Read", func() {
+		It("gets entity by id and criteria", func() {
+			Expect(get(2).Name).Should(Equal("Beta"))
+
+			found, err := hotels.GetOneBy(ctx, map[string]any{"stars": 4})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(found.Name).Should(Equal("Gamma"))
+
+			_, err = hotels.GetOneByExpression(ctx, goqu.And(goqu.C("stars").Gt(5)))
+			Expect(err).Should(MatchError(pgx.ErrNoRows))
+		})
+
+		It("lists in insertion order", func() {
+			list, err := hotels.List(ctx)
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(names(list)).Should(Equal([]string{"Alfa", "Beta", "Gamma"}))
+		})
+
+		It("sorts, pages and projects", func() {
+			list, err := hotels.ListByExpression(ctx, goqu.And(),
+				repo.WithSort([]exp.OrderedExpression{goqu.I("h.stars").Desc()}),
+				repo.WithOffset(1),
+				repo.WithLimit(1),
+				repo.WithColumns("h.name"),
+			)
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(list).Should(Equal([]hotel{{Name: "Gamma"}}))
+		})
+
+		It("pages by cursor", func() {
+			list, err := hotels.ListByExpression(ctx, goqu.And(), repo.WithCursor("id", int64(3), true), repo.WithLimit(1))
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(names(list)).Should(Equal([]string{"Beta"}))
+		})
+
+		It("returns page with total", func() {
+			list, total, err := hotels.ListPage(ctx, goqu.And(goqu.C("stars").Gt(3)), repo.WithLimit(1))
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(names(list)).Should(Equal([]string{"Beta"}))
+			Expect(total).Should(Equal(int64(2)))
+		})
+
+		It("iterates entities", func() {
+			var seen []string
+			err := hotels.Iterate(ctx, map[string]any{}, func(item hotel) error {
+				seen = append(seen, item.Name)
+				return nil
+			})
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(seen).Should(Equal([]string{"Alfa", "Beta", "Gamma"}))
+		})
+
+		It("does not support grouping, aggregate and preloads", func() {
+			_, err := hotels.ListByExpression(ctx, goqu.And(), repo.WithGroupBy("stars"))
+			Expect(err).Should(MatchError(memory.ErrUnsupportedExpression))
+
+			_, err = repo.Aggregate[hotel](ctx, hotels, goqu.And(), repo.WithColumns("stars"))
+			Expect(err).Should(MatchError(repo.ErrAggregateNotSupported))
+
+			Expect(hotels.Preload(ctx, []hotel{get(1)}, repo.ListOptionPreload{Alias: "r"})).Should(Succeed())
+		})
+	})
+
+	Describe("Delete", func() {
+		trashed := func(scope repo.ListOption) []string {
+			list, err := hotels.ListByExpression(ctx, goqu.And(), scope)
+			Expect(err).ShouldNot(HaveOccurred())
+			return names(list)
+		}
+
+		It("soft deletes and restores entities", func() {
+			Expect(hotels.Delete(ctx, 1)).Should(Succeed())
+			Expect(hotels.SoftDelete(ctx, 2)).Should(Succeed())
+
+			_, err := hotels.Get(ctx, 1)
+			Expect(err).Should(MatchError(pgx.ErrNoRows))
+			Expect(trashed(repo.OnlyTrashed())).Should(Equal([]string{"Alfa", "Beta"}))
+			Expect(trashed(repo.WithTrashed())).Should(HaveLen(3))
+
+			trashedCtx := context.WithValue(ctx, repo.CtxTrashedScope, repo.TrashedOnly)
+			list, err := hotels.List(trashedCtx)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(names(list)).Should(Equal([]string{"Alfa", "Beta"}))
+
+			Expect(hotels.Restore(ctx, 1)).Should(Succeed())
+			Expect(hotels.Restore(ctx, 9)).Should(MatchError(pgx.ErrNoRows))
+			Expect(hotels.RestoreBy(ctx, map[string]any{"name": "Beta"})).Should(Succeed())
+			Expect(trashed(repo.OnlyTrashed())).Should(BeEmpty())
+		})
+
+		It("soft deletes by criteria and ids", func() {
+			Expect(hotels.DeleteBy(ctx, map[string]any{"stars": 3})).Should(Succeed())
+			Expect(hotels.SoftDeleteMultiple(ctx, []int64{2, 3})).Should(Succeed())
+
+			Expect(trashed(repo.OnlyTrashed())).Should(Equal([]string{"Alfa", "Beta", "Gamma"}))
+		})
+
+		It("force deletes entities", func() {
+			Expect(hotels.ForceDelete(ctx, 1)).Should(Succeed())
+			Expect(hotels.ForceDeleteBy(ctx, map[string]any{"name": "Beta"})).Should(Succeed())
+			Expect(trashed(repo.WithTrashed())).Should(Equal([]string{"Gamma"}))
+
+			Expect(hotels.ForceDeleteMultiple(ctx, []int64{3})).Should(Succeed())
+			Expect(trashed(repo.WithTrashed())).Should(BeEmpty())
+		})
+
+		It("deletes entity instead of moving references", func() {
+			Expect(hotels.DeleteAndMoveReferences(ctx, 3, 3)).Should(MatchError(repo.ErrMoveReferencesToSelf))
+			Expect(hotels.DeleteAndMoveReferences(ctx, 3, 1)).Should(Succeed())
+
+			Expect(trashed(repo.OnlyTrashed())).Should(Equal([]string{"Gamma"}))
+		})
+
+		It("rejects soft delete of regular entity", func() {
+			rooms := memory.NewRepository[room, string](room{Id: "a", Name: "Suite"})
+
+			Expect(rooms.SoftDelete(ctx, "a")).Should(MatchError(repo.ErrNotSoftDeletingEntity))
+			Expect(rooms.Restore(ctx, "a")).Should(MatchError(repo.ErrNotSoftDeletingEntity))
+			Expect(rooms.Delete(ctx, "a")).Should(Succeed())
+
+			list, err := rooms.List(ctx)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(list).Should(BeEmpty())
+		})
+	})
+
+	Describe("Hooks", func() {
+		var rooms repo.BaseRepo[hookedRoom, int64]
+
+		BeforeEach(func() {
+			hookCalls = nil
+			rooms = memory.NewRepository[hookedRoom, int64]()
+		})
+
+		It("calls hooks in order", func() {
+			id, err := rooms.Create(ctx, hookedRoom{Name: " Suite "})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(rooms.Update(ctx, hookedRoom{Id: id, Name: "Suite"})).Should(Succeed())
+			Expect(rooms.Delete(ctx, id)).Should(Succeed())
+
+			Expect(hookCalls).Should(Equal([]string{
+				"BeforeCreate Suite", "AfterCreate Suite",
+				"BeforeUpdate Suite", "AfterUpdate Suite",
+				"BeforeDelete Suite",
+			}))
+		})
+
+		It("rolls back on hook error", func() {
+			_, err := rooms.CreateMultiple(ctx, []hookedRoom{{Name: "Suite"}, {Name: "failAfterCreate"}})
+			Expect(err).Should(MatchError(errHook))
+
+			id, err := rooms.Create(ctx, hookedRoom{Name: "failBeforeDelete"})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(rooms.Delete(ctx, id)).Should(MatchError(errHook))
+
+			list, err := rooms.List(ctx)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(list).Should(Equal([]hookedRoom{{Id: id, Name: "failBeforeDelete"}}))
+		})
+	})
+
+	It("keeps concurrent writes", func() {
+		var wg sync.WaitGroup
+		for i := range 50 {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				_, err := hotels.Create(ctx, hotel{Name: fmt.Sprintf("Hotel %d", i)})
+				Expect(err).ShouldNot(HaveOccurred())
+			}()
+			go func() {
+				defer wg.Done()
+				Expect(hotels.BulkUpdate(ctx, map[string]any{"stars": i}, map[string]any{"id": 1 + i%3})).Should(Succeed())
+				_, err := hotels.List(ctx)
+				Expect(err).ShouldNot(HaveOccurred())
+			}()
+		}
+		wg.Wait()
+
+		count, err := hotels.CountByExpression(ctx, goqu.And())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(count).Should(Equal(int64(53)))
+
+		list, err := hotels.List(ctx)
+		Expect(err).ShouldNot(HaveOccurred())
+		ids := map[int64]bool{}
+		for _, item := range list {
+			ids[item.Id] = true
+		}
+		Expect(ids).Should(HaveLen(53))
+	})
+})
@@ -0,0 +1,155 @@
+package memory_test
+
+import (
+	"context"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jackc/pgx/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/EveryHotel/core-tools/pkg/repo"
+	"github.com/EveryHotel/core-tools/pkg/repo/memory"
+	"github.com/EveryHotel/core-tools/pkg/types"
+)
+
+type chainHotel struct {
+	Id      int64  `db:"id" primary:"1"`
+	ChainId int64  `db:"chain_id" tenant:"1"`
+	Name    string `db:"name" conflict_target:"1"`
+	Stars   int64  `db:"stars"`
+	Version int64  `db:"version" version:"1"`
+}
+
+type starsPatch struct {
+	Stars types.Omitempty[int64] `db:"stars"`
+}
+
+var _ = Describe("Tenant", func() {
+	var (
+		ctx    context.Context
+		bypass context.Context
+		hotels repo.BaseRepo[chainHotel, int64]
+	)
+
+	BeforeEach(func() {
+		ctx = context.WithValue(context.Background(), repo.CtxTenantId, int64(1))
+		bypass = context.WithValue(context.Background(), repo.CtxTenantBypass, true)
+		hotels = memory.NewRepository[chainHotel, int64](
+			chainHotel{ChainId: 1, Name: "Alfa", Stars: 3},
+			chainHotel{ChainId: 2, Name: "Beta", Stars: 3},
+		)
+	})
+
+	stored := func() map[string]chainHotel {
+		list, err := hotels.List(bypass)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		res := make(map[string]chainHotel, len(list))
+		for _, item := range list {
+			res[item.Name] = item
+		}
+		return res
+	}
+
+	It("requires tenant in context", func() {
+		_, err := hotels.List(context.Background())
+		Expect(err).Should(MatchError(repo.ErrTenantRequired))
+
+		_, err = hotels.Create(context.Background(), chainHotel{ChainId: 1, Name: "Gamma"})
+		Expect(err).Should(MatchError(repo.ErrTenantRequired))
+
+		Expect(hotels.Delete(context.Background(), 1)).Should(MatchError(repo.ErrTenantRequired))
+		Expect(stored()).Should(HaveLen(2))
+	})
+
+	It("reads only entities of tenant", func() {
+		list, err := hotels.List(ctx)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(list).Should(HaveLen(1))
+		Expect(list[0].Name).Should(Equal("Alfa"))
+
+		_, err = hotels.Get(ctx, 2)
+		Expect(err).Should(MatchError(pgx.ErrNoRows))
+
+		count, err := hotels.CountByExpression(ctx, goqu.And())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(count).Should(Equal(int64(1)))
+	})
+
+	It("sets tenant on Create", func() {
+		id, err := hotels.Create(ctx, chainHotel{Name: "Gamma"})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		entity, err := hotels.Get(ctx, id)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(entity.ChainId).Should(Equal(int64(1)))
+	})
+
+	It("rejects entities of another tenant on create", func() {
+		_, err := hotels.Create(ctx, chainHotel{ChainId: 2, Name: "Gamma"})
+		Expect(err).Should(MatchError(repo.ErrTenantMismatch))
+
+		_, err = hotels.CreateMultiple(ctx, []chainHotel{{Name: "Gamma"}, {ChainId: 2, Name: "Delta"}})
+		Expect(err).Should(MatchError(repo.ErrTenantMismatch))
+
+		Expect(stored()).Should(HaveLen(2))
+	})
+
+	It("updates only entities of tenant", func() {
+		err := hotels.Update(ctx, chainHotel{Id: 2, Name: "Beta", Stars: 5})
+		Expect(err).Should(MatchError(repo.ErrTenantMismatch))
+		Expect(err).ShouldNot(MatchError(repo.ErrStaleEntity))
+		Expect(hotels.Patch(ctx, 2, starsPatch{Stars: types.Omitempty[int64]{Value: 5, Valid: true}})).Should(MatchError(repo.ErrTenantMismatch))
+		Expect(hotels.Patch(ctx, 9, starsPatch{Stars: types.Omitempty[int64]{Value: 5, Valid: true}})).Should(MatchError(pgx.ErrNoRows))
+		Expect(hotels.BulkUpdate(ctx, nil, map[string]any{"name": "Beta"})).Should(Succeed())
+		Expect(hotels.BulkUpdate(ctx, map[string]any{"stars": 4}, nil)).Should(Succeed())
+
+		Expect(stored()["Alfa"].Stars).Should(Equal(int64(4)))
+		Expect(stored()["Beta"].Stars).Should(Equal(int64(3)))
+		Expect(stored()["Beta"].ChainId).Should(Equal(int64(2)))
+	})
+
+	It("deletes only entities of tenant", func() {
+		Expect(hotels.Delete(ctx, 2)).Should(Succeed())
+		Expect(hotels.DeleteBy(ctx, map[string]any{"stars": 3})).Should(Succeed())
+
+		Expect(stored()).Should(HaveKey("Beta"))
+		Expect(stored()).ShouldNot(HaveKey("Alfa"))
+	})
+
+	It("tells tenant mismatch from stale version in UpdateMultiple", func() {
+		err := hotels.UpdateMultiple(ctx, []chainHotel{{Name: "Alfa", Stars: 5}, {Name: "Beta", Stars: 5}})
+		Expect(err).Should(MatchError(repo.ErrTenantMismatch))
+		Expect(err).ShouldNot(MatchError(repo.ErrStaleEntity))
+
+		err = hotels.UpdateMultiple(ctx, []chainHotel{{ChainId: 2, Name: "Beta", Stars: 5}})
+		Expect(err).Should(MatchError(repo.ErrTenantMismatch))
+
+		err = hotels.UpdateMultiple(ctx, []chainHotel{{Name: "Alfa", Stars: 5, Version: 3}})
+		Expect(err).Should(MatchError(repo.ErrStaleEntity))
+		Expect(err).ShouldNot(MatchError(repo.ErrTenantMismatch))
+
+		Expect(stored()["Alfa"].Stars).Should(Equal(int64(3)))
+		Expect(stored()["Beta"].Stars).Should(Equal(int64(3)))
+
+		Expect(hotels.UpdateMultiple(ctx, []chainHotel{{Name: "Alfa", Stars: 5}, {Name: "Gamma"}})).Should(Succeed())
+		Expect(stored()["Alfa"].Stars).Should(Equal(int64(5)))
+		Expect(stored()["Gamma"].ChainId).Should(Equal(int64(1)))
+	})
+
+	It("moves references only between entities of tenant", func() {
+		Expect(hotels.DeleteAndMoveReferences(ctx, 1, 2)).Should(MatchError(repo.ErrTenantMismatch))
+		Expect(stored()).Should(HaveLen(2))
+	})
+
+	It("skips tenant scope with CtxTenantBypass", func() {
+		_, err := hotels.Create(bypass, chainHotel{ChainId: 2, Name: "Gamma"})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Expect(hotels.BulkUpdate(bypass, map[string]any{"stars": 4}, map[string]any{"chain_id": 2})).Should(Succeed())
+
+		Expect(stored()["Beta"].Stars).Should(Equal(int64(4)))
+		Expect(stored()["Gamma"].Stars).Should(Equal(int64(4)))
+	})
+})
@@ -0,0 +1,168 @@
+package repo_test
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/EveryHotel/core-tools/pkg/repo"
+	"github.com/EveryHotel/core-tools/pkg/types"
+)
+
+type hotelPatch struct {
+	Name  types.Omitempty[string] `db:"name"`
+	Stars types.Omitempty[int64]  `db:"stars"`
+}
+
+type ratePatch struct {
+	Price   types.Omitempty[int64]  `db:"price"`
+	Version types.Omitempty[uint32] `db:"version"`
+}
+
+type hotelCityPatch struct {
+	CityId *int64 `db:"city_id"`
+}
+
+type hotelFullPatch struct {
+	Id    int64           `db:"id" primary:"1"`
+	Patch *hotelPatch     `embedded_struct:"1"`
+	City  *hotelCityPatch `inner_struct:"city"`
+}
+
+var _ = Describe("Patch", func() {
+	var ctx context.Context
+
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+
+	Describe("SanitizePatchRows", func() {
+		It("takes only present omitempty fields", func() {
+			rows, err := repo.SanitizePatchRows(hotelPatch{Stars: types.Omitempty[int64]{Value: 4, Valid: true}})
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(rows).Should(Equal(map[string]any{"stars": int64(4)}))
+		})
+
+		It("dereferences patch and nested struct pointers", func() {
+			cityId := int64(5)
+			rows, err := repo.SanitizePatchRows(&hotelFullPatch{
+				Id:    1,
+				Patch: &hotelPatch{Name: types.Omitempty[string]{Value: "Grand", Valid: true}},
+				City:  &hotelCityPatch{CityId: &cityId},
+			})
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(rows).Should(Equal(map[string]any{"name": "Grand", "city_id": &cityId}))
+		})
+
+		It("skips nil nested structs", func() {
+			rows, err := repo.SanitizePatchRows(hotelFullPatch{Id: 1})
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(rows).Should(BeEmpty())
+		})
+
+		It("rejects non struct patch", func() {
+			_, err := repo.SanitizePatchRows(map[string]any{"name": "Grand"})
+			Expect(err).Should(MatchError(repo.ErrInvalidPatch))
+
+			_, err = repo.SanitizePatchRows((*hotelPatch)(nil))
+			Expect(err).Should(MatchError(repo.ErrInvalidPatch))
+		})
+	})
+
+	Describe("BaseRepo", func() {
+		var (
+			hotels repo.BaseRepo[hotel, int64]
+			id     int64
+		)
+
+		BeforeEach(func() {
+			hotels = repo.NewRepository[hotel, int64](openDB(), "hotels", "h", "id")
+
+			var err error
+			id, err = hotels.Create(ctx, hotel{Name: "Grand", Stars: 3})
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("updates only passed fields", func() {
+			stored, err := hotels.Get(ctx, id)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(hotels.Patch(ctx, id, &hotelPatch{Stars: types.Omitempty[int64]{Value: 5, Valid: true}})).Should(Succeed())
+
+			patched, err := hotels.Get(ctx, id)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(patched.Name).Should(Equal("Grand"))
+			Expect(patched.Stars).Should(Equal(int64(5)))
+			Expect(patched.UpdatedAt).ShouldNot(BeTemporally("<", stored.UpdatedAt))
+		})
+
+		It("does nothing for empty patch", func() {
+			Expect(hotels.Patch(ctx, id, hotelPatch{})).Should(Succeed())
+
+			stored, err := hotels.Get(ctx, id)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(stored.Stars).Should(Equal(int64(3)))
+		})
+
+		It("returns error for non struct patch", func() {
+			Expect(hotels.Patch(ctx, id, map[string]any{"stars": 1})).Should(MatchError(repo.ErrInvalidPatch))
+		})
+
+		It("returns ErrNoRows for missing entity", func() {
+			err := hotels.Patch(ctx, id+1, hotelPatch{Stars: types.Omitempty[int64]{Value: 5, Valid: true}})
+
+			Expect(err).Should(MatchError(pgx.ErrNoRows))
+		})
+	})
+
+	Describe("versioned BaseRepo", func() {
+		var (
+			rates repo.BaseRepo[rate, int64]
+			id    int64
+		)
+
+		BeforeEach(func() {
+			rates = repo.NewRepository[rate, int64](openDB(), "rates", "r", "id")
+
+			var err error
+			id, err = rates.Create(ctx, rate{Name: "BAR", Price: 100})
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("increments version and invalidates stale Update", func() {
+			stored, err := rates.Get(ctx, id)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(rates.Patch(ctx, id, ratePatch{Price: types.Omitempty[int64]{Value: 120, Valid: true}})).Should(Succeed())
+
+			patched, err := rates.Get(ctx, id)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(patched.Price).Should(Equal(int64(120)))
+			Expect(patched.Version).Should(Equal(stored.Version + 1))
+
+			stored.Price = 90
+			Expect(rates.Update(ctx, stored)).Should(MatchError(repo.ErrStaleEntity))
+		})
+
+		It("checks passed version", func() {
+			patch := ratePatch{
+				Price:   types.Omitempty[int64]{Value: 120, Valid: true},
+				Version: types.Omitempty[uint32]{Value: 5, Valid: true},
+			}
+			Expect(rates.Patch(ctx, id, patch)).Should(MatchError(repo.ErrStaleEntity))
+
+			patch.Version.Value = 0
+			Expect(rates.Patch(ctx, id, patch)).Should(Succeed())
+
+			patched, err := rates.Get(ctx, id)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(patched.Price).Should(Equal(int64(120)))
+			Expect(patched.Version).Should(Equal(uint32(1)))
+		})
+	})
+})
@@ -0,0 +1,248 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+
+	"github.com/EveryHotel/core-tools/pkg/database"
+)
+
+// ListOptionPreload описывает has-many или many-to-many связь, которая загружается отдельным запросом
+// и проставляется в поле-слайс сущности, помеченное тегом preload:"<Alias>"
+//
+//	type Hotel struct {
+//		Id     int64   `db:"id" primary:"1"`
+//		Rooms  []Room  `preload:"r"`
+//		Photos []Photo `preload:"p"`
+//	}
+//
+//	has-many:     {Alias: "r", Table: "rooms", ForeignKey: "hotel_id"}
+//	many-to-many: {Alias: "p", Table: "photos", ForeignKey: "hotel_id", Pivot: &ListOptionPivot{Table: "hotel_photos", Alias: "hp", RelatedKey: "photo_id"}}
+type ListOptionPreload struct {
+	Alias string
+	Table string
+	// ForeignKey колонка со ссылкой на сущность: в связанной таблице для has-many, в pivot таблице для many-to-many
+	ForeignKey string
+	// LocalKey колонка сущности, на которую ссылается ForeignKey, по умолчанию id
+	LocalKey string
+	Pivot    *ListOptionPivot
+	Sort     []exp.OrderedExpression
+	// Preloads вложенные связи загружаемых сущностей
+	Preloads []ListOptionPreload
+}
+
+// ListOptionPivot промежуточная таблица many-to-many связи
+type ListOptionPivot struct {
+	Table string
+	Alias string
+	// RelatedKey колонка pivot таблицы со ссылкой на связанную сущность
+	RelatedKey string
+	// OwnerKey колонка связанной таблицы, на которую ссылается RelatedKey, по умолчанию id
+	OwnerKey string
+}
+
+// applyPreloads загружает связи для всех элементов слайса items
+func applyPreloads(ctx context.Context, db database.DBService, items reflect.Value, preloads []ListOptionPreload) error {
+	for _, p := range preloads {
+		if err := applyPreload(ctx, db, items, p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyPreload загружает одну связь одним запросом WHERE fk IN (...) и раскладывает результат по элементам items
+func applyPreload(ctx context.Context, db database.DBService, items reflect.Value, p ListOptionPreload) error {
+	if items.Len() == 0 {
+		return nil
+	}
+
+	itemType := items.Type().Elem()
+	fieldIndex, ok := findPreloadField(itemType, p.Alias)
+	if !ok {
+		return fmt.Errorf("preload %s: field with tag preload:%q not found in %s", p.Table, p.Alias, itemType)
+	}
+	childType := itemType.Field(fieldIndex).Type.Elem()
+
+	localKey := p.LocalKey
+	if localKey == "" {
+		localKey = "id"
+	}
+
+	var keyType reflect.Type
+	var keys []any
+	seen := make(map[any]struct{})
+	for i := 0; i < items.Len(); i++ {
+		keyField, ok := fieldByColumn(items.Index(i), localKey)
+		if !ok {
+			return fmt.Errorf("preload %s: column %s not found in %s", p.Table, localKey, itemType)
+		}
+
+		keyType = keyField.Type()
+		key, ok := preloadKey(keyField)
+		if !ok {
+			continue
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		keys = append(keys, key)
+	}
+
+	// у всех элементов ссылка пустая, загружать нечего
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// результат сканируем в обертку из ключа родителя и самой связанной сущности
+	wrapperType := reflect.StructOf([]reflect.StructField{
+		{Name: "PreloadKey", Type: keyType, Tag: `db:"preload_key"`},
+		{Name: "PreloadItem", Type: childType, Tag: `embedded_struct:"1"`},
+	})
+
+	cols := []any{goqu.I(p.Alias + "." + p.ForeignKey)}
+	if p.Pivot != nil {
+		cols = []any{goqu.I(p.Pivot.Alias + "." + p.ForeignKey)}
+	}
+	cols = append(cols, database.Sanitize(reflect.New(childType).Elem().Interface(), database.WithPrefix(p.Alias))...)
+
+	ds := db.Dialect().Select(cols...).
+		From(database.GetTableName(p.Table).As(p.Alias))
+
+	if p.Pivot != nil {
+		ownerKey := p.Pivot.OwnerKey
+		if ownerKey == "" {
+			ownerKey = "id"
+		}
+
+		ds = ds.InnerJoin(database.GetTableName(p.Pivot.Table).As(p.Pivot.Alias), goqu.On(
+			goqu.I(p.Pivot.Alias+"."+p.Pivot.RelatedKey).Eq(goqu.I(p.Alias+"."+ownerKey)),
+		)).Where(goqu.I(p.Pivot.Alias + "." + p.ForeignKey).In(keys))
+	} else {
+		ds = ds.Where(goqu.I(p.Alias + "." + p.ForeignKey).In(keys))
+	}
+
+	if IsSoftDeletingEntity(reflect.New(childType).Elem().Interface()) {
+		ds = ds.Where(goqu.I(p.Alias + ".deleted_at").IsNull())
+	}
+
+	tenantColumn, tenant, scoped, err := TenantScope(ctx, childType)
+	if err != nil {
+		return err
+	}
+	if scoped {
+		ds = ds.Where(goqu.I(p.Alias + "." + tenantColumn).Eq(tenant))
+	}
+
+	if len(p.Sort) > 0 {
+		ds = ds.Order(p.Sort...)
+	}
+
+	sql, args, err := ds.ToSQL()
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot build SQL query for preload",
+			slog.Any("error", err),
+			slog.String("table", p.Table),
+			slog.String("sql", sql),
+		)
+		return err
+	}
+
+	wrappers := reflect.New(reflect.SliceOf(wrapperType))
+	if err = db.Select(ctx, sql, args, wrappers.Interface()); err != nil {
+		slog.ErrorContext(ctx, "Error during exec preload",
+			slog.Any("error", err),
+			slog.String("table", p.Table),
+		)
+		return err
+	}
+	wrappers = wrappers.Elem()
+
+	children := reflect.MakeSlice(reflect.SliceOf(childType), wrappers.Len(), wrappers.Len())
+	for i := 0; i < wrappers.Len(); i++ {
+		children.Index(i).Set(wrappers.Index(i).Field(1))
+	}
+
+	if err = applyPreloads(ctx, db, children, p.Preloads); err != nil {
+		return err
+	}
+
+	grouped := make(map[any]reflect.Value, len(keys))
+	for i := 0; i < wrappers.Len(); i++ {
+		key, ok := preloadKey(wrappers.Index(i).Field(0))
+		if !ok {
+			continue
+		}
+		group, ok := grouped[key]
+		if !ok {
+			group = reflect.MakeSlice(reflect.SliceOf(childType), 0, 1)
+		}
+		grouped[key] = reflect.Append(group, children.Index(i))
+	}
+
+	for i := 0; i < items.Len(); i++ {
+		keyField, _ := fieldByColumn(items.Index(i), localKey)
+		key, ok := preloadKey(keyField)
+		if !ok {
+			continue
+		}
+		if group, ok := grouped[key]; ok {
+			items.Index(i).Field(fieldIndex).Set(group)
+		}
+	}
+
+	return nil
+}
+
+// preloadKey возвращает значение ключа связи без указателей, чтобы ключи *int64 и int64 группировались
+// по значению, ok - false для пустой ссылки
+func preloadKey(v reflect.Value) (any, bool) {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil, false
+		}
+		v = v.Elem()
+	}
+
+	return v.Interface(), true
+}
+
+// findPreloadField возвращает индекс поля-слайса с тегом preload:"alias"
+func findPreloadField(itemType reflect.Type, alias string) (int, bool) {
+	for i := 0; i < itemType.NumField(); i++ {
+		field := itemType.Field(i)
+		if field.Type.Kind() == reflect.Slice && field.Tag.Get("preload") == alias {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// fieldByColumn ищет поле структуры по тегу db, в том числе во вложенных структурах
+func fieldByColumn(vItem reflect.Value, column string) (reflect.Value, bool) {
+	for i := 0; i < vItem.NumField(); i++ {
+		tag := vItem.Type().Field(i).Tag
+
+		if tag.Get("embedded_struct") == "1" || tag.Get("inner_struct") != "" {
+			if field, ok := fieldByColumn(vItem.Field(i), column); ok {
+				return field, true
+			}
+
+			continue
+		}
+
+		if tag.Get("db") == column {
+			return vItem.Field(i), true
+		}
+	}
+
+	return reflect.Value{}, false
+}
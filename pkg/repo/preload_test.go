@@ -0,0 +1,107 @@
+package repo_test
+
+import (
+	"context"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/EveryHotel/core-tools/pkg/repo"
+)
+
+type hotelWithRooms struct {
+	Id    int64  `db:"id" primary:"1"`
+	Name  string `db:"name"`
+	Rooms []room `preload:"r"`
+}
+
+// hotelWithCity ссылается на город nullable колонкой city_id
+type hotelWithCity struct {
+	Id     int64  `db:"id" primary:"1"`
+	Name   string `db:"name"`
+	CityId *int64 `db:"city_id"`
+	Cities []city `preload:"c"`
+}
+
+var roomsPreload = repo.ListOptionPreload{
+	Alias:      "r",
+	Table:      "rooms",
+	ForeignKey: "hotel_id",
+	Sort:       []exp.OrderedExpression{goqu.I("r.name").Asc()},
+}
+
+var _ = Describe("Preload", func() {
+	var (
+		ctx    context.Context
+		hotels repo.BaseRepo[hotelWithRooms, int64]
+		grand  int64
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		svc := openDB()
+		hotels = repo.NewRepository[hotelWithRooms, int64](svc, "hotels", "h", "id")
+
+		ids, err := repo.NewRepository[hotel, int64](svc, "hotels", "h", "id").
+			CreateMultiple(ctx, []hotel{{Name: "Grand"}, {Name: "Motel"}})
+		Expect(err).ShouldNot(HaveOccurred())
+		grand = ids[0]
+
+		_, err = repo.NewRepository[room, int64](svc, "rooms", "r", "id").
+			CreateMultiple(ctx, []room{{HotelId: grand, Name: "Suite"}, {HotelId: grand, Name: "Double"}, {HotelId: ids[1], Name: "Single"}})
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	roomNames := func(rooms []room) []string {
+		res := make([]string, len(rooms))
+		for i, item := range rooms {
+			res[i] = item.Name
+		}
+		return res
+	}
+
+	It("preloads relations for single entity", func() {
+		found, err := hotels.GetOneByExpression(ctx, goqu.And(goqu.I("h.id").Eq(grand)), repo.WithPreloads([]repo.ListOptionPreload{roomsPreload}))
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(roomNames(found.Rooms)).Should(Equal([]string{"Double", "Suite"}))
+	})
+
+	It("preloads relations for list", func() {
+		list, err := hotels.ListByExpression(ctx, goqu.And(),
+			repo.WithPreloads([]repo.ListOptionPreload{roomsPreload}),
+			repo.WithSort([]exp.OrderedExpression{goqu.I("h.id").Asc()}),
+		)
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(list).Should(HaveLen(2))
+		Expect(roomNames(list[0].Rooms)).Should(Equal([]string{"Double", "Suite"}))
+		Expect(roomNames(list[1].Rooms)).Should(Equal([]string{"Single"}))
+	})
+
+	It("does not preload without option", func() {
+		found, err := hotels.Get(ctx, grand)
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(found.Rooms).Should(BeNil())
+	})
+
+	It("preloads relations by nullable local key", func() {
+		svc := openDB()
+		exec(svc, `INSERT INTO cities (name) VALUES ('Moscow')`)
+		exec(svc, `INSERT INTO hotels (name, city_id, created_at, updated_at) VALUES ('Grand', 1, '2024-01-01', '2024-01-01'), ('Motel', NULL, '2024-01-01', '2024-01-01')`)
+
+		list, err := repo.NewRepository[hotelWithCity, int64](svc, "hotels", "h", "id").ListByExpression(ctx, goqu.And(),
+			repo.WithPreloads([]repo.ListOptionPreload{{Alias: "c", Table: "cities", ForeignKey: "id", LocalKey: "city_id"}}),
+			repo.WithSort([]exp.OrderedExpression{goqu.I("h.id").Asc()}),
+		)
+
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(list).Should(HaveLen(2))
+		Expect(list[0].Cities).Should(HaveLen(1))
+		Expect(list[0].Cities[0].Name).Should(Equal("Moscow"))
+		Expect(list[1].Cities).Should(BeNil())
+	})
+})
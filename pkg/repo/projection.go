@@ -0,0 +1,128 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+
+	"github.com/EveryHotel/core-tools/pkg/database"
+)
+
+var ErrUnknownColumn = errors.New("unknown entity column")
+
+// projection выборка части колонок сущности, заданных WithColumns
+// Строки сканируются в структуру только из выбранных полей и затем копируются в сущность
+type projection struct {
+	columns []any
+	fields  []database.StructField
+	typ     reflect.Type
+}
+
+func newProjection[T any](alias string, columns []any) (*projection, error) {
+	entityFields := database.StructFields(reflect.TypeOf(*new(T)))
+
+	p := &projection{}
+	var structFields []reflect.StructField
+	for i, column := range columns {
+		var name string
+		switch c := column.(type) {
+		case string:
+			name = c[strings.LastIndex(c, ".")+1:]
+		case exp.AliasedExpression:
+			name, _ = c.GetAs().GetCol().(string)
+		}
+
+		field, ok := findStructField(entityFields, name)
+		if !ok {
+			return nil, fmt.Errorf("%w: %v", ErrUnknownColumn, column)
+		}
+
+		p.columns = append(p.columns, columnExpression(alias, column))
+		p.fields = append(p.fields, field)
+		structFields = append(structFields, reflect.StructField{
+			Name: fmt.Sprintf("Column%d", i),
+			Type: field.Type,
+			Tag:  reflect.StructTag(`db:"` + field.Column + `"`),
+		})
+	}
+	p.typ = reflect.StructOf(structFields)
+
+	return p, nil
+}
+
+// newItem возвращает указатель на структуру для сканирования одной строки
+func (p *projection) newItem() reflect.Value {
+	return reflect.New(p.typ)
+}
+
+// newItems возвращает указатель на слайс структур для сканирования выборки
+func (p *projection) newItems() reflect.Value {
+	return reflect.New(reflect.SliceOf(p.typ))
+}
+
+// copyTo переносит значения выбранных колонок в сущность
+func (p *projection) copyTo(item reflect.Value, entity reflect.Value) {
+	for i, field := range p.fields {
+		entity.FieldByIndex(field.Index).Set(item.Field(i))
+	}
+}
+
+// selectProjection выполняет запрос с колонками проекции и возвращает сущности с заполненными выбранными полями
+func selectProjection[T any](ctx context.Context, db database.DBService, p *projection, sql string, args []any) ([]T, error) {
+	items := p.newItems()
+	if err := db.Select(ctx, sql, args, items.Interface()); err != nil {
+		return nil, err
+	}
+	items = items.Elem()
+
+	res := make([]T, items.Len())
+	for i := range res {
+		p.copyTo(items.Index(i), reflect.ValueOf(&res[i]).Elem())
+	}
+
+	return res, nil
+}
+
+func findStructField(fields []database.StructField, column string) (database.StructField, bool) {
+	for _, field := range fields {
+		if field.Column == column {
+			return field, true
+		}
+	}
+
+	return database.StructField{}, false
+}
+
+// columnExpression возвращает идентификатор колонки, к имени без точки добавляется алиас таблицы
+// Выражения возвращаются как есть
+func columnExpression(alias string, column any) any {
+	name, ok := column.(string)
+	if !ok {
+		return column
+	}
+
+	return columnIdentifier(alias, name)
+}
+
+// columnIdentifier возвращает идентификатор колонки name, к имени без точки добавляется алиас таблицы
+func columnIdentifier(alias string, name string) exp.IdentifierExpression {
+	if strings.Contains(name, ".") {
+		return goqu.I(name)
+	}
+
+	return goqu.I(alias + "." + name)
+}
+
+func columnExpressions(alias string, columns []any) []any {
+	res := make([]any, 0, len(columns))
+	for _, column := range columns {
+		res = append(res, columnExpression(alias, column))
+	}
+
+	return res
+}
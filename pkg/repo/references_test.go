@@ -0,0 +1,111 @@
+package repo_test
+
+import (
+	"context"
+	"strings"
+
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/EveryHotel/core-tools/pkg/database"
+	"github.com/EveryHotel/core-tools/pkg/repo"
+)
+
+// catalogTx транзакция, которая отвечает на запросы к каталогу postgres заготовленными строками
+// по подстроке запроса и запоминает изменяющие запросы
+type catalogTx struct {
+	pgx.Tx
+	rows      map[string]func() pgx.Rows
+	args      map[string][]any
+	execs     []string
+	commits   int
+	rollbacks int
+}
+
+func (t *catalogTx) Query(_ context.Context, sql string, args ...any) (pgx.Rows, error) {
+	for substr, rows := range t.rows {
+		if strings.Contains(sql, substr) {
+			t.args[substr] = args
+			return rows(), nil
+		}
+	}
+
+	return pgxpoolmock.NewRows([]string{"none"}).ToPgxRows(), nil
+}
+
+func (t *catalogTx) Exec(_ context.Context, sql string, _ ...any) (pgconn.CommandTag, error) {
+	t.execs = append(t.execs, sql)
+	return pgconn.CommandTag("UPDATE 1"), nil
+}
+
+func (t *catalogTx) Commit(context.Context) error {
+	t.commits++
+	return nil
+}
+
+func (t *catalogTx) Rollback(context.Context) error {
+	t.rollbacks++
+	return nil
+}
+
+var _ = Describe("DeleteAndMoveReferences", func() {
+	var (
+		ctx    context.Context
+		tx     *catalogTx
+		hotels repo.BaseRepo[hotel, int64]
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mockPool := pgxpoolmock.NewMockPgxPool(gomock.NewController(GinkgoT()))
+		tx = &catalogTx{rows: map[string]func() pgx.Rows{}, args: map[string][]any{}}
+		mockPool.EXPECT().Begin(gomock.Any()).Return(tx, nil).MaxTimes(1)
+
+		hotels = repo.NewRepository[hotel, int64](database.NewDBService(mockPool), "hotels", "h", "id")
+	})
+
+	It("deletes future duplicates, moves references and deletes entity", func() {
+		tx.rows["pg_constraint"] = func() pgx.Rows {
+			return pgxpoolmock.NewRows([]string{"nspname", "relname", "attname", "conname", "array_length"}).
+				AddRow("public", "rooms", "hotel_id", "rooms_hotel_id_fkey", int64(1)).
+				ToPgxRows()
+		}
+		tx.rows["pg_index"] = func() pgx.Rows {
+			return pgxpoolmock.NewRows([]string{"name", "columns"}).
+				AddRow("rooms_hotel_id_name_key", []string{"hotel_id", "name"}).
+				ToPgxRows()
+		}
+
+		Expect(hotels.DeleteAndMoveReferences(ctx, 1, 2)).Should(Succeed())
+
+		Expect(tx.execs).Should(Equal([]string{
+			`DELETE FROM "public"."rooms" WHERE (("rooms"."hotel_id" = 1) AND EXISTS (SELECT 1 FROM "public"."rooms" AS "dst" WHERE (("dst"."hotel_id" = 2) AND ("dst"."name" = "rooms"."name"))))`,
+			`UPDATE "public"."rooms" SET "hotel_id"=2 WHERE ("hotel_id" = 1)`,
+			`DELETE FROM "hotels" WHERE ("id" = 1)`,
+		}))
+		Expect(tx.commits).Should(Equal(1))
+		Expect(tx.args["pg_constraint"]).Should(Equal([]any{"hotels", "id"}))
+	})
+
+	It("rejects moving references to the deleted entity itself", func() {
+		Expect(hotels.DeleteAndMoveReferences(ctx, 1, 1)).Should(MatchError(repo.ErrMoveReferencesToSelf))
+		Expect(tx.execs).Should(BeEmpty())
+		Expect(tx.commits).Should(BeZero())
+	})
+
+	It("rolls back on composite foreign key", func() {
+		tx.rows["pg_constraint"] = func() pgx.Rows {
+			return pgxpoolmock.NewRows([]string{"nspname", "relname", "attname", "conname", "array_length"}).
+				AddRow("public", "rates", "hotel_id", "rates_hotel_fkey", int64(2)).
+				ToPgxRows()
+		}
+
+		Expect(hotels.DeleteAndMoveReferences(ctx, 1, 2)).Should(MatchError(database.ErrCompositeForeignKey))
+		Expect(tx.execs).Should(BeEmpty())
+		Expect(tx.rollbacks).Should(Equal(1))
+	})
+})
@@ -0,0 +1,98 @@
+package repo_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/EveryHotel/core-tools/pkg/database"
+	"github.com/EveryHotel/core-tools/pkg/database/sqlite"
+	"github.com/EveryHotel/core-tools/pkg/repo"
+)
+
+func TestRepo(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Repo Suite")
+}
+
+const schema = `
+CREATE TABLE cities (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL);
+CREATE TABLE hotels (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL UNIQUE,
+	city_id INTEGER REFERENCES cities (id),
+	stars INTEGER NOT NULL DEFAULT 0,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+CREATE TABLE rooms (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	hotel_id INTEGER NOT NULL DEFAULT 0,
+	name TEXT NOT NULL,
+	deleted_at DATETIME
+);
+CREATE TABLE rates (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	price INTEGER NOT NULL DEFAULT 0,
+	version INTEGER NOT NULL DEFAULT 0
+);`
+
+type city struct {
+	Id   int64  `db:"id" primary:"1"`
+	Name string `db:"name"`
+}
+
+type hotel struct {
+	Id        int64     `db:"id" primary:"1"`
+	Name      string    `db:"name"`
+	CityId    *int64    `db:"city_id"`
+	Stars     int64     `db:"stars"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+	City      *city     `relation:"c,nullable"`
+}
+
+type room struct {
+	Id        int64      `db:"id" primary:"1"`
+	HotelId   int64      `db:"hotel_id"`
+	Name      string     `db:"name"`
+	DeletedAt *time.Time `db:"deleted_at"`
+}
+
+var cityRelation = repo.ListOptionRelation{
+	Alias:       "c",
+	Table:       "cities",
+	Expressions: []goqu.Expression{goqu.I("c.id").Eq(goqu.I("h.city_id"))},
+	Nullable:    true,
+}
+
+// openDB открывает пустую базу SQLite со схемой тестов и закрывает ее после теста
+func openDB() database.DBService {
+	db, err := sqlite.Open("file::memory:")
+	Expect(err).ShouldNot(HaveOccurred())
+	DeferCleanup(func() {
+		Expect(db.Close()).Should(Succeed())
+	})
+
+	_, err = db.Exec(schema)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	return sqlite.NewDBService(db)
+}
+
+// exec выполняет запрос подготовки данных
+func exec(svc database.DBService, query string, args ...any) {
+	Expect(svc.Exec(context.Background(), query, args)).Should(Succeed())
+}
+
+// createCity добавляет город и возвращает его id
+func createCity(svc database.DBService, name string) int64 {
+	id, err := repo.NewRepository[city, int64](svc, "cities", "c", "id").Create(context.Background(), city{Name: name})
+	Expect(err).ShouldNot(HaveOccurred())
+	return id
+}
@@ -0,0 +1,52 @@
+package repo_test
+
+import (
+	"github.com/doug-martin/goqu/v9"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/EveryHotel/core-tools/pkg/repo"
+)
+
+type roomCode struct {
+	Code string `db:"code" primary:"1" not_serial:"1"`
+}
+
+type roomSerial struct {
+	Number int64 `db:"number" primary:"1"`
+}
+
+type roomWithCode struct {
+	Id     int64      `db:"id" primary:"1"`
+	Name   string     `db:"name"`
+	Code   roomCode   `embedded_struct:"1"`
+	Serial roomSerial `embedded_struct:"1"`
+}
+
+var _ = Describe("SanitizeRows", func() {
+	It("skips serial primary and returns its value", func() {
+		id, rows := repo.SanitizeRows[int64](city{Id: 3, Name: "Kazan"})
+
+		Expect(id).Should(Equal(int64(3)))
+		Expect(rows).Should(Equal(map[string]any{"name": "Kazan"}))
+	})
+
+	It("keeps not serial primary from embedded struct", func() {
+		id, rows := repo.SanitizeRows[int64](roomWithCode{
+			Id:     3,
+			Name:   "Suite",
+			Code:   roomCode{Code: "S-1"},
+			Serial: roomSerial{Number: 7},
+		})
+
+		Expect(id).Should(Equal(int64(3)))
+		Expect(rows).Should(Equal(map[string]any{"name": "Suite", "code": "S-1"}))
+	})
+
+	It("sets timestamps and increments version for update", func() {
+		_, rows := repo.SanitizeRowsForUpdate[int64](rate{Id: 1, Name: "Standard", Version: 2})
+
+		Expect(rows).Should(HaveKeyWithValue("version", goqu.L("? + 1", goqu.C("version"))))
+		Expect(rows).ShouldNot(HaveKey("id"))
+	})
+})
@@ -0,0 +1,231 @@
+package repo_test
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jackc/pgx/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/EveryHotel/core-tools/pkg/database"
+	"github.com/EveryHotel/core-tools/pkg/meilisearch"
+	"github.com/EveryHotel/core-tools/pkg/repo"
+)
+
+type roomIndex struct {
+	Id   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+func (i roomIndex) GetIdentity() int64 {
+	return i.Id
+}
+
+func (r room) GetModelIndex() roomIndex {
+	return roomIndex{Id: r.Id, Name: r.Name}
+}
+
+func (r room) IsDeleted() bool {
+	return r.DeletedAt != nil
+}
+
+// fakeMeili индекс в памяти, запоминает документы по id
+type fakeMeili struct {
+	meilisearch.MeiliService
+	documents map[string]any
+}
+
+func (m *fakeMeili) UpdateDocuments(_ string, document any) error {
+	m.documents[fmt.Sprint(document.(roomIndex).Id)] = document
+	return nil
+}
+
+func (m *fakeMeili) AddDocuments(_ string, documents any) error {
+	for _, document := range documents.([]any) {
+		m.documents[fmt.Sprint(document.(roomIndex).Id)] = document
+	}
+	return nil
+}
+
+func (m *fakeMeili) Clear(string) error {
+	clear(m.documents)
+	return nil
+}
+
+func (m *fakeMeili) DeleteDocument(_ string, id string) error {
+	delete(m.documents, id)
+	return nil
+}
+
+var _ = Describe("Soft delete", func() {
+	var (
+		ctx    context.Context
+		svc    database.DBService
+		rooms  repo.BaseRepo[room, int64]
+		single int64
+		double int64
+	)
+
+	names := func(items []room) []string {
+		res := make([]string, len(items))
+		for i, item := range items {
+			res[i] = item.Name
+		}
+		return res
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		svc = openDB()
+		rooms = repo.NewRepository[room, int64](svc, "rooms", "r", "id")
+
+		ids, err := rooms.CreateMultiple(ctx, []room{{Name: "Single"}, {Name: "Double"}, {Name: "Suite"}})
+		Expect(err).ShouldNot(HaveOccurred())
+		single, double = ids[0], ids[1]
+
+		Expect(rooms.Delete(ctx, single)).Should(Succeed())
+	})
+
+	It("marks entity deleted instead of removing it", func() {
+		_, err := rooms.Get(ctx, single)
+		Expect(err).Should(MatchError(pgx.ErrNoRows))
+
+		deleted, err := rooms.GetOneByExpression(ctx, goqu.And(goqu.I("r.id").Eq(single)), repo.WithTrashed())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(deleted.DeletedAt).ShouldNot(BeNil())
+	})
+
+	It("excludes deleted entities from lists and count by default", func() {
+		list, err := rooms.ListBy(ctx, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(names(list)).Should(ConsistOf("Double", "Suite"))
+
+		count, err := rooms.CountByExpression(ctx, goqu.And())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(count).Should(Equal(int64(2)))
+	})
+
+	It("includes deleted entities WithTrashed", func() {
+		list, err := rooms.ListBy(ctx, nil, repo.WithTrashed())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(names(list)).Should(ConsistOf("Single", "Double", "Suite"))
+
+		count, err := rooms.CountByExpression(ctx, goqu.And(), repo.WithTrashed())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(count).Should(Equal(int64(3)))
+	})
+
+	It("selects only deleted entities OnlyTrashed", func() {
+		list, err := rooms.ListBy(ctx, nil, repo.OnlyTrashed())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(names(list)).Should(Equal([]string{"Single"}))
+	})
+
+	It("takes trashed scope from context when option is not set", func() {
+		list, err := rooms.ListBy(context.WithValue(ctx, repo.CtxTrashedScope, repo.TrashedOnly), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(names(list)).Should(Equal([]string{"Single"}))
+
+		found, err := rooms.Get(context.WithValue(ctx, repo.CtxTrashedScope, repo.TrashedInclude), single)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(found.Name).Should(Equal("Single"))
+
+		list, err = rooms.List(context.WithValue(ctx, repo.CtxTrashedScope, repo.TrashedInclude))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(list).Should(HaveLen(3))
+	})
+
+	It("restores deleted entity", func() {
+		Expect(rooms.Restore(ctx, single)).Should(Succeed())
+		Expect(rooms.Restore(ctx, 100)).Should(MatchError(pgx.ErrNoRows))
+
+		found, err := rooms.Get(ctx, single)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(found.DeletedAt).Should(BeNil())
+	})
+
+	It("restores deleted entities by criteria", func() {
+		Expect(rooms.DeleteBy(ctx, map[string]any{"name": "Double"})).Should(Succeed())
+		Expect(rooms.RestoreBy(ctx, map[string]any{"id": []int64{single, double}})).Should(Succeed())
+
+		list, err := rooms.ListBy(ctx, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(list).Should(HaveLen(3))
+	})
+
+	It("removes deleted entity on force delete", func() {
+		Expect(rooms.ForceDelete(ctx, single)).Should(Succeed())
+
+		list, err := rooms.ListBy(ctx, nil, repo.WithTrashed())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(names(list)).Should(ConsistOf("Double", "Suite"))
+	})
+
+	It("does not restore entities without deleted_at", func() {
+		cities := repo.NewRepository[city, int64](svc, "cities", "c", "id")
+
+		Expect(cities.Restore(ctx, 1)).Should(MatchError(repo.ErrNotSoftDeletingEntity))
+		Expect(cities.RestoreBy(ctx, nil)).Should(MatchError(repo.ErrNotSoftDeletingEntity))
+	})
+
+	Describe("IndexableBaseRepo", func() {
+		var (
+			index   *fakeMeili
+			indexed repo.IndexableBaseRepo[roomIndex, room, int64]
+		)
+
+		BeforeEach(func() {
+			index = &fakeMeili{documents: map[string]any{}}
+			indexed = repo.NewIndexableRepository[roomIndex, room, int64](svc, index, "rooms", "rooms", "r", "id",
+				func(ptr *room, id int64) { ptr.Id = id }, nil, nil, nil)
+		})
+
+		It("removes document on delete and returns it on restore", func() {
+			Expect(indexed.Update(ctx, room{Id: double, Name: "Double"})).Should(Succeed())
+			Expect(index.documents).Should(HaveKey(fmt.Sprint(double)))
+
+			Expect(indexed.Delete(ctx, double)).Should(Succeed())
+			Expect(index.documents).ShouldNot(HaveKey(fmt.Sprint(double)))
+
+			Expect(indexed.Restore(ctx, double)).Should(Succeed())
+			Expect(index.documents).Should(HaveKeyWithValue(fmt.Sprint(double), roomIndex{Id: double, Name: "Double"}))
+		})
+
+		It("reindexes entities in batches with queries from extend callback", func() {
+			exec(svc, `WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 600)
+				INSERT INTO rooms (name) SELECT 'Room ' || i FROM n`)
+
+			var batches []int
+			queryCtx := ctx
+			indexed = repo.NewIndexableRepository[roomIndex, room, int64](svc, index, "rooms", "rooms", "r", "id",
+				func(ptr *room, id int64) { ptr.Id = id },
+				func(items []room) ([]room, error) {
+					// соединение SQLite одно, запрос при открытом курсоре заблокировал бы переиндексацию
+					if _, err := rooms.CountByExpression(queryCtx, goqu.And()); err != nil {
+						return nil, err
+					}
+					batches = append(batches, len(items))
+					return items, nil
+				}, nil, nil)
+
+			Expect(indexed.Reindex(ctx)).Should(Succeed())
+			Expect(batches).Should(Equal([]int{500, 102}))
+			Expect(index.documents).Should(HaveLen(602))
+			Expect(index.documents).ShouldNot(HaveKey(fmt.Sprint(single)))
+
+			batches = nil
+			Expect(svc.RunInTx(ctx, func(ctx context.Context) error {
+				queryCtx = ctx
+				return indexed.Reindex(ctx)
+			})).Should(Succeed())
+			Expect(batches).Should(Equal([]int{500, 102}))
+		})
+
+		It("does not index entity when restore fails", func() {
+			Expect(indexed.Restore(ctx, 100)).Should(MatchError(pgx.ErrNoRows))
+			Expect(index.documents).Should(BeEmpty())
+		})
+	})
+})
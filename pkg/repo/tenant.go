@@ -0,0 +1,125 @@
+package repo
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+
+	"github.com/EveryHotel/core-tools/pkg/database"
+)
+
+// CtxTenantId ключ контекста с id арендатора (сети отелей) для сущностей с колонкой, помеченной тегом tenant:"1"
+//
+//	type Hotel struct {
+//		Id      int64 `db:"id" primary:"1"`
+//		ChainId int64 `db:"chain_id" tenant:"1"`
+//	}
+const CtxTenantId = "tenant_id"
+
+// CtxTenantBypass ключ контекста (bool), отключающий ограничение по арендатору, для служебных задач по всем арендаторам
+const CtxTenantBypass = "tenant_bypass"
+
+var (
+	// ErrTenantRequired запрос к сущности с арендатором без CtxTenantId в контексте
+	ErrTenantRequired = errors.New("tenant is required in context")
+	// ErrTenantMismatch в сущности задан арендатор, отличный от арендатора из контекста
+	ErrTenantMismatch = errors.New("entity belongs to another tenant")
+)
+
+// TenantColumn возвращает колонку арендатора сущности, помеченную тегом tenant:"1"
+func TenantColumn(typ reflect.Type) (string, bool) {
+	for _, field := range database.StructFields(typ) {
+		if !field.Embedded && field.Tag.Get("tenant") != "" {
+			return field.Column, true
+		}
+	}
+
+	return "", false
+}
+
+// TenantScope возвращает колонку и арендатора из контекста для запросов к сущностям типа typ
+// scoped - false, если сущность не разделяется по арендаторам или ограничение отключено через CtxTenantBypass
+func TenantScope(ctx context.Context, typ reflect.Type) (column string, tenant any, scoped bool, err error) {
+	column, ok := TenantColumn(typ)
+	if !ok {
+		return "", nil, false, nil
+	}
+
+	if bypass, ok := ctx.Value(CtxTenantBypass).(bool); ok && bypass {
+		return "", nil, false, nil
+	}
+
+	tenant = ctx.Value(CtxTenantId)
+	if tenant == nil {
+		return "", nil, false, fmt.Errorf("%w: column %s", ErrTenantRequired, column)
+	}
+
+	return column, tenant, true, nil
+}
+
+// tenantWhere возвращает условие по арендатору колонки таблицы table (пусто - без таблицы),
+// для сущностей без арендатора условие пустое
+func (r baseRepo[T, ID]) tenantWhere(ctx context.Context, table string) ([]exp.Expression, error) {
+	column, tenant, scoped, err := TenantScope(ctx, reflect.TypeFor[T]())
+	if err != nil || !scoped {
+		return nil, err
+	}
+
+	if table == "" {
+		return []exp.Expression{goqu.C(column).Eq(tenant)}, nil
+	}
+
+	return []exp.Expression{goqu.C(column).Table(table).Eq(tenant)}, nil
+}
+
+// setTenant проставляет арендатора из контекста в колонки записи rows
+func (r baseRepo[T, ID]) setTenant(ctx context.Context, rows map[string]any) error {
+	return SetTenant(ctx, reflect.TypeFor[T](), rows)
+}
+
+// SetTenant проставляет арендатора из контекста в колонки rows записи сущности типа typ
+// Если в записи уже задан другой арендатор, возвращается ErrTenantMismatch
+func SetTenant(ctx context.Context, typ reflect.Type, rows map[string]any) error {
+	column, tenant, scoped, err := TenantScope(ctx, typ)
+	if err != nil || !scoped {
+		return err
+	}
+
+	if value, ok := tenantString(rows[column]); ok {
+		if expected, _ := tenantString(tenant); value != expected {
+			return fmt.Errorf("%w: %s = %s, tenant %s", ErrTenantMismatch, column, value, expected)
+		}
+	}
+	rows[column] = tenant
+
+	return nil
+}
+
+// tenantString приводит арендатора к строке для сравнения, ok - false для пустого значения
+func tenantString(value any) (string, bool) {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return "", false
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() || v.IsZero() {
+		return "", false
+	}
+
+	if valuer, ok := v.Interface().(driver.Valuer); ok {
+		val, err := valuer.Value()
+		if err != nil || val == nil {
+			return "", false
+		}
+		return fmt.Sprint(val), true
+	}
+
+	return fmt.Sprint(v.Interface()), true
+}
@@ -0,0 +1,128 @@
+package repo_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/EveryHotel/core-tools/pkg/repo"
+)
+
+type rate struct {
+	Id      int64  `db:"id" primary:"1"`
+	Name    string `db:"name"`
+	Price   int64  `db:"price"`
+	Version uint32 `db:"version" version:"1"`
+}
+
+type ratePtr struct {
+	Id      int64  `db:"id" primary:"1"`
+	Name    string `db:"name"`
+	Price   int64  `db:"price"`
+	Version *int64 `db:"version" lock:"version"`
+}
+
+var _ = Describe("Optimistic locking", func() {
+	var ctx context.Context
+
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+
+	Describe("GetEntityVersion", func() {
+		It("reads signed, unsigned and pointer versions", func() {
+			version := int64(7)
+
+			column, value, ok := repo.GetEntityVersion(rate{Version: 3})
+			Expect([]any{column, value, ok}).Should(Equal([]any{"version", int64(3), true}))
+
+			column, value, ok = repo.GetEntityVersion(ratePtr{Version: &version})
+			Expect([]any{column, value, ok}).Should(Equal([]any{"version", int64(7), true}))
+		})
+
+		It("treats nil pointer as zero version", func() {
+			column, value, ok := repo.GetEntityVersion(ratePtr{})
+			Expect([]any{column, value, ok}).Should(Equal([]any{"version", int64(0), true}))
+		})
+
+		It("ignores entities without version and non integer version fields", func() {
+			_, _, ok := repo.GetEntityVersion(city{})
+			Expect(ok).Should(BeFalse())
+
+			_, _, ok = repo.GetEntityVersion(struct {
+				Version string `db:"version" version:"1"`
+			}{})
+			Expect(ok).Should(BeFalse())
+		})
+	})
+
+	Describe("BaseRepo", func() {
+		var rates repo.BaseRepo[rate, int64]
+
+		BeforeEach(func() {
+			rates = repo.NewRepository[rate, int64](openDB(), "rates", "r", "id")
+		})
+
+		It("increments version on update", func() {
+			id, err := rates.Create(ctx, rate{Name: "Standard", Price: 100})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			stored, err := rates.Get(ctx, id)
+			Expect(err).ShouldNot(HaveOccurred())
+			stored.Price = 120
+			Expect(rates.Update(ctx, stored)).Should(Succeed())
+
+			updated, err := rates.Get(ctx, id)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(updated.Price).Should(Equal(int64(120)))
+			Expect(updated.Version).Should(Equal(uint32(1)))
+		})
+
+		It("rejects update of stale entity", func() {
+			id, err := rates.Create(ctx, rate{Name: "Standard", Price: 100})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			stale, err := rates.Get(ctx, id)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			fresh := stale
+			fresh.Price = 110
+			Expect(rates.Update(ctx, fresh)).Should(Succeed())
+
+			stale.Price = 90
+			Expect(rates.Update(ctx, stale)).Should(MatchError(repo.ErrStaleEntity))
+
+			stored, err := rates.Get(ctx, id)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(stored.Price).Should(Equal(int64(110)))
+		})
+
+		It("rejects multiple update with stale entity", func() {
+			ids, err := rates.CreateMultiple(ctx, []rate{{Name: "Standard"}, {Name: "Flex"}})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			stored, err := rates.ListBy(ctx, map[string]any{"r.id": ids})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(rates.UpdateMultiple(ctx, stored)).Should(Succeed())
+
+			// версии в stored уже устарели
+			Expect(rates.UpdateMultiple(ctx, stored)).Should(MatchError(repo.ErrStaleEntity))
+		})
+
+		It("updates pointer version", func() {
+			ptrRates := repo.NewRepository[ratePtr, int64](openDB(), "rates", "r", "id")
+
+			zero := int64(0)
+			id, err := ptrRates.Create(ctx, ratePtr{Name: "Standard", Version: &zero})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			stored, err := ptrRates.Get(ctx, id)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(*stored.Version).Should(Equal(int64(0)))
+
+			Expect(ptrRates.Update(ctx, stored)).Should(Succeed())
+			Expect(ptrRates.Update(ctx, stored)).Should(MatchError(repo.ErrStaleEntity))
+		})
+	})
+})
@@ -23,6 +23,22 @@ type Omitempty[T any] struct {
 	Valid bool
 }
 
+// OmitemptyValue позволяет работать с Omitempty любого типа через рефлексию
+type OmitemptyValue interface {
+	IsPresent() bool
+	GetValue() any
+}
+
+// IsPresent возвращает true, если поле было передано в запросе (в том числе как null)
+func (i Omitempty[T]) IsPresent() bool {
+	return i.Valid
+}
+
+// GetValue возвращает значение поля
+func (i Omitempty[T]) GetValue() any {
+	return i.Value
+}
+
 func (i *Omitempty[T]) UnmarshalJSON(data []byte) error {
 	i.Valid = true
 